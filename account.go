@@ -0,0 +1,179 @@
+package systemservice
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+ManagedDirectory is a set of directories systemd creates for the
+service before it starts and hands over to the service's user.
+*/
+type ManagedDirectory struct {
+	// The directory names, relative to the base directory of the kind
+	// (e.g. "my-service" for /var/lib/my-service).
+	Paths []string
+
+	// The access mode of the directories. Optional, systemd defaults
+	// to 0755.
+	Mode os.FileMode
+}
+
+/*
+Directories configures the state, cache, logs, runtime and
+configuration directories systemd manages for the service. Only
+used by systemd.
+*/
+type Directories struct {
+	// StateDirectory=, below /var/lib or ~/.local/state
+	State ManagedDirectory
+
+	// CacheDirectory=, below /var/cache or ~/.cache
+	Cache ManagedDirectory
+
+	// LogsDirectory=, below /var/log or ~/.local/state/log
+	Logs ManagedDirectory
+
+	// RuntimeDirectory=, below /run or $XDG_RUNTIME_DIR
+	Runtime ManagedDirectory
+
+	// ConfigurationDirectory=, below /etc or ~/.config
+	Configuration ManagedDirectory
+
+	// Whether or not Uninstall removes the directories and their
+	// contents.
+	PurgeOnUninstall bool
+}
+
+/*
+directoryKind describes where one kind of managed directory lives
+*/
+type directoryKind struct {
+	ManagedDirectory
+
+	// The name of the systemd directive, without the "Directory" suffix
+	name string
+
+	// The base directory for system and user scope
+	systemBase string
+	userBase   func() string
+
+	// Whether or not DynamicUser= moves the directory below "private/"
+	private bool
+}
+
+func (d Directories) kinds() []directoryKind {
+	stateHome := func() string { return xdgDir("XDG_STATE_HOME", ".local/state") }
+
+	return []directoryKind{
+		{d.State, "State", "/var/lib", stateHome, true},
+		{d.Cache, "Cache", "/var/cache", func() string { return xdgDir("XDG_CACHE_HOME", ".cache") }, true},
+		{d.Logs, "Logs", "/var/log", func() string { return filepath.Join(stateHome(), "log") }, true},
+		{d.Runtime, "Runtime", "/run", func() string { return os.Getenv("XDG_RUNTIME_DIR") }, false},
+		{d.Configuration, "Configuration", "/etc", func() string { return xdgDir("XDG_CONFIG_HOME", ".config") }, false},
+	}
+}
+
+/*
+validate makes sure all directory names are relative and stay
+below their base directory
+*/
+func (d Directories) validate() error {
+	for _, k := range d.kinds() {
+		for _, p := range k.Paths {
+			clean := filepath.Clean(p)
+			if p == "" || filepath.IsAbs(p) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+				return fmt.Errorf("%sDirectory %q must be a relative path below %s", k.name, p, k.systemBase)
+			}
+		}
+	}
+	return nil
+}
+
+/*
+directives returns the [Service] directives for the managed
+directories
+*/
+func (d Directories) directives() []Directive {
+	var out []Directive
+	for _, k := range d.kinds() {
+		if len(k.Paths) == 0 {
+			continue
+		}
+		out = append(out, Directive{Key: k.name + "Directory", Value: strings.Join(k.Paths, " ")})
+		if k.Mode != 0 {
+			out = append(out, Directive{Key: k.name + "DirectoryMode", Value: fmt.Sprintf("%04o", k.Mode.Perm())})
+		}
+	}
+	return out
+}
+
+/*
+paths returns the absolute paths of all managed directories for the
+given scope. With a dynamic user, systemd keeps the real directories
+below "private/" and links them into the base directory, both are
+returned.
+*/
+func (d Directories) paths(userScope bool, dynamicUser bool) []string {
+	var out []string
+	for _, k := range d.kinds() {
+		base := k.systemBase
+		if userScope {
+			base = k.userBase()
+			if base == "" {
+				continue
+			}
+		}
+		for _, p := range k.Paths {
+			out = append(out, filepath.Join(base, p))
+			if dynamicUser && !userScope && k.private {
+				out = append(out, filepath.Join(base, "private", p))
+			}
+		}
+	}
+	return out
+}
+
+/*
+accountDirectives returns the [Service] directives selecting the user
+the service runs as
+*/
+func (c *ServiceCommand) accountDirectives() []Directive {
+	var out []Directive
+	if c.User != "" {
+		out = append(out, Directive{Key: "User", Value: c.User})
+	}
+	if c.Group != "" {
+		out = append(out, Directive{Key: "Group", Value: c.Group})
+	}
+	if c.DynamicUser {
+		out = append(out, Directive{Key: "DynamicUser", Value: "yes"})
+	}
+	return out
+}
+
+/*
+validateAccount checks that the user options do not conflict
+*/
+func (c *ServiceCommand) validateAccount() error {
+	if c.CreateUser && c.User == "" {
+		return fmt.Errorf("CreateUser requires User to be set")
+	}
+	if c.CreateUser && c.DynamicUser {
+		return fmt.Errorf("CreateUser and DynamicUser cannot be used together")
+	}
+	return c.Directories.validate()
+}
+
+/*
+xdgDir returns the value of the given XDG environment variable or
+the fallback relative to the home directory
+*/
+func xdgDir(env string, fallback string) string {
+	if dir := os.Getenv(env); dir != "" {
+		return dir
+	}
+	return filepath.Join(homeDir(), fallback)
+}
@@ -0,0 +1,54 @@
+package systemservice
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirectoriesDirectives(t *testing.T) {
+	assert := assert.New(t)
+
+	dirs := Directories{
+		State:   ManagedDirectory{Paths: []string{"app"}, Mode: 0750},
+		Runtime: ManagedDirectory{Paths: []string{"app", "app/sockets"}},
+	}
+
+	assert.Equal([]Directive{
+		{Key: "StateDirectory", Value: "app"},
+		{Key: "StateDirectoryMode", Value: "0750"},
+		{Key: "RuntimeDirectory", Value: "app app/sockets"},
+	}, dirs.directives())
+
+	assert.Equal([]string{
+		"/var/lib/app",
+		"/var/lib/private/app",
+		"/run/app",
+		"/run/app/sockets",
+	}, dirs.paths(false, true))
+}
+
+func TestDirectoriesValidate(t *testing.T) {
+	assert := assert.New(t)
+	tables := []struct {
+		path  string
+		valid bool
+	}{
+		{"app", true},
+		{"app/data", true},
+		{"/var/lib/app", false},
+		{"../app", false},
+		{"app/../..", false},
+		{"", false},
+	}
+
+	for _, table := range tables {
+		dirs := Directories{Cache: ManagedDirectory{Paths: []string{table.path}}}
+		err := dirs.validate()
+		if table.valid {
+			assert.NoError(err, table.path)
+		} else {
+			assert.Error(err, table.path)
+		}
+	}
+}
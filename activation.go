@@ -0,0 +1,144 @@
+package systemservice
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+/*
+listenFdsStart is the first file descriptor passed by systemd
+*/
+const listenFdsStart = 3
+
+/*
+activated holds the sockets passed to the process. They are only
+read once since the file descriptors are taken over on first use.
+*/
+var activated struct {
+	once        sync.Once
+	listeners   []net.Listener
+	packetConns []net.PacketConn
+	names       map[interface{}]string
+}
+
+/*
+Listeners returns the stream sockets passed to the service by socket
+activation (see ServiceCommand.Sockets), in the order they are listed
+in the socket unit. It returns an empty slice when the service was not
+socket activated, so the caller can fall back to listening itself.
+*/
+func Listeners() []net.Listener {
+	loadActivatedSockets()
+	return activated.listeners
+}
+
+/*
+ListenersWithNames returns the activated stream sockets grouped by
+their FileDescriptorName (Sockets.Name).
+*/
+func ListenersWithNames() map[string][]net.Listener {
+	loadActivatedSockets()
+
+	named := map[string][]net.Listener{}
+	for _, l := range activated.listeners {
+		name := activated.names[l]
+		named[name] = append(named[name], l)
+	}
+	return named
+}
+
+/*
+PacketConns returns the datagram sockets passed to the service by
+socket activation.
+*/
+func PacketConns() []net.PacketConn {
+	loadActivatedSockets()
+	return activated.packetConns
+}
+
+/*
+loadActivatedSockets takes over the file descriptors passed in
+$LISTEN_FDS and clears the environment so child processes do not
+inherit them
+*/
+func loadActivatedSockets() {
+	activated.once.Do(func() {
+		activated.names = map[interface{}]string{}
+
+		count, names := listenFds()
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+
+		for i := 0; i < count; i++ {
+			name := "unknown"
+			if i < len(names) {
+				name = names[i]
+			}
+
+			f := os.NewFile(uintptr(listenFdsStart+i), name)
+
+			l, c, err := activatedSocket(f)
+			switch {
+			case err != nil:
+				defaultLog().Warn("ignoring activated file descriptor", "name", name, "error", err)
+			case l != nil:
+				activated.listeners = append(activated.listeners, l)
+				activated.names[l] = name
+			default:
+				activated.packetConns = append(activated.packetConns, c)
+				activated.names[c] = name
+			}
+
+			// The listeners use a duplicate of the descriptor
+			f.Close()
+		}
+	})
+}
+
+/*
+activatedSocket returns a listener for an activated stream or
+sequential packet socket and a packet connection for a datagram
+socket. The type is read from the socket, net.FileListener accepts
+Unix datagram sockets as well.
+*/
+func activatedSocket(f *os.File) (net.Listener, net.PacketConn, error) {
+	typ, err := socketType(f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if typ == syscall.SOCK_DGRAM {
+		c, err := net.FilePacketConn(f)
+		return nil, c, err
+	}
+
+	l, err := net.FileListener(f)
+	return l, nil, err
+}
+
+/*
+listenFds returns the number of file descriptors passed to this
+process and their names
+*/
+func listenFds() (int, []string) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return 0, nil
+	}
+
+	var names []string
+	if n := os.Getenv("LISTEN_FDNAMES"); n != "" {
+		names = strings.Split(n, ":")
+	}
+
+	return count, names
+}
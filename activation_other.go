@@ -0,0 +1,16 @@
+// +build !linux,!darwin
+
+package systemservice
+
+import (
+	"errors"
+	"os"
+)
+
+/*
+socketType is only needed for socket activation, which only exists on
+Linux and Mac
+*/
+func socketType(f *os.File) (int, error) {
+	return 0, errors.New("socket activation is not supported on this platform")
+}
@@ -0,0 +1,29 @@
+// +build linux darwin
+
+package systemservice
+
+import (
+	"os"
+	"syscall"
+)
+
+/*
+socketType returns the SO_TYPE of the socket, e.g. syscall.SOCK_STREAM
+*/
+func socketType(f *os.File) (int, error) {
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var typ int
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		typ, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_TYPE)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return typ, sockErr
+}
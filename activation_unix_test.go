@@ -0,0 +1,73 @@
+// +build linux darwin
+
+package systemservice
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActivatedSocket(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "activation")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer tcp.Close()
+
+	unixStream, err := net.Listen("unix", filepath.Join(dir, "stream.sock"))
+	assert.NoError(err)
+	defer unixStream.Close()
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(err)
+	defer udp.Close()
+
+	unixgram, err := net.ListenPacket("unixgram", filepath.Join(dir, "datagram.sock"))
+	assert.NoError(err)
+	defer unixgram.Close()
+
+	type filer interface {
+		File() (*os.File, error)
+	}
+
+	tests := []struct {
+		name     string
+		socket   filer
+		listener bool
+	}{
+		{"tcp", tcp.(*net.TCPListener), true},
+		{"unix", unixStream.(*net.UnixListener), true},
+		{"udp", udp.(*net.UDPConn), false},
+		{"unixgram", unixgram.(*net.UnixConn), false},
+	}
+
+	for _, test := range tests {
+		f, err := test.socket.File()
+		if !assert.NoError(err, test.name) {
+			continue
+		}
+
+		l, c, err := activatedSocket(f)
+		f.Close()
+		assert.NoError(err, test.name)
+
+		if test.listener {
+			assert.NotNil(l, test.name)
+			assert.Nil(c, test.name)
+			l.Close()
+		} else {
+			assert.Nil(l, test.name)
+			assert.NotNil(c, test.name)
+			c.Close()
+		}
+	}
+}
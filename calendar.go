@@ -0,0 +1,454 @@
+package systemservice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+CalendarSpec is a parsed systemd calendar event expression, as used by
+OnCalendar= (see systemd.time(7)), e.g. "Mon..Fri *-*-* 09:00:00".
+
+Supported are weekday lists and ranges, dates and times made of "*",
+values, lists ("1,15"), ranges ("9..17") and repetitions ("*:0/15",
+"9..17/2"), an optional time zone and the shorthands "minutely",
+"hourly", "daily", "weekly", "monthly", "yearly", "annually",
+"quarterly" and "semiannually". The last-day-of-month syntax ("~") and
+fractional seconds are not supported.
+*/
+type CalendarSpec struct {
+	// Bitmask of the allowed weekdays, bit 0 is Sunday. Zero allows
+	// every day.
+	weekdays uint8
+
+	year, month, day, hour, minute, second []calendarComponent
+
+	location *time.Location
+}
+
+/*
+calendarComponent is a single value, range or repetition of one date
+or time field. An end of -1 repeats without bound.
+*/
+type calendarComponent struct {
+	start  int
+	end    int
+	repeat int
+}
+
+var calendarShorthands = map[string]string{
+	"minutely":     "*-*-* *:*:00",
+	"hourly":       "*-*-* *:00:00",
+	"daily":        "*-*-* 00:00:00",
+	"monthly":      "*-*-01 00:00:00",
+	"weekly":       "Mon *-*-* 00:00:00",
+	"yearly":       "*-01-01 00:00:00",
+	"annually":     "*-01-01 00:00:00",
+	"quarterly":    "*-01,04,07,10-01 00:00:00",
+	"semiannually": "*-01,07-01 00:00:00",
+}
+
+var weekdayNames = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+/*
+ParseCalendar parses a systemd calendar event expression
+*/
+func ParseCalendar(expr string) (*CalendarSpec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty calendar expression")
+	}
+
+	spec := &CalendarSpec{location: time.Local}
+
+	invalid := func(err error) (*CalendarSpec, error) {
+		return nil, fmt.Errorf("invalid calendar expression %q: %v", expr, err)
+	}
+
+	if len(fields) == 1 || len(fields) == 2 {
+		if full, ok := calendarShorthands[strings.ToLower(fields[0])]; ok {
+			fields = append(strings.Fields(full), fields[1:]...)
+		}
+	}
+
+	// The time zone is the last field if it is neither a date nor a time
+	if last := fields[len(fields)-1]; len(fields) > 1 && !strings.ContainsAny(last, ":-*") {
+		loc, err := time.LoadLocation(last)
+		if err == nil {
+			spec.location = loc
+			fields = fields[:len(fields)-1]
+		}
+	}
+
+	if strings.ContainsAny(fields[0], "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz") {
+		weekdays, err := parseWeekdays(fields[0])
+		if err != nil {
+			return invalid(err)
+		}
+		spec.weekdays = weekdays
+		fields = fields[1:]
+	}
+
+	var date, clock string
+	switch len(fields) {
+	case 0:
+		date, clock = "*-*-*", "00:00:00"
+	case 1:
+		if strings.Contains(fields[0], ":") {
+			date, clock = "*-*-*", fields[0]
+		} else {
+			date, clock = fields[0], "00:00:00"
+		}
+	case 2:
+		date, clock = fields[0], fields[1]
+	default:
+		return invalid(fmt.Errorf("too many fields"))
+	}
+
+	if strings.Contains(date, "~") {
+		return invalid(fmt.Errorf("last day of month (~) is not supported"))
+	}
+
+	parts := strings.Split(date, "-")
+	if len(parts) == 2 {
+		parts = append([]string{"*"}, parts...)
+	}
+	if len(parts) != 3 {
+		return invalid(fmt.Errorf("date %q must be YYYY-MM-DD or MM-DD", date))
+	}
+
+	times := strings.Split(clock, ":")
+	if len(times) == 2 {
+		times = append(times, "00")
+	}
+	if len(times) != 3 {
+		return invalid(fmt.Errorf("time %q must be HH:MM or HH:MM:SS", clock))
+	}
+
+	var err error
+	fieldSpecs := []struct {
+		value    string
+		min, max int
+		target   *[]calendarComponent
+	}{
+		{parts[0], 1970, 2199, &spec.year},
+		{parts[1], 1, 12, &spec.month},
+		{parts[2], 1, 31, &spec.day},
+		{times[0], 0, 23, &spec.hour},
+		{times[1], 0, 59, &spec.minute},
+		{times[2], 0, 59, &spec.second},
+	}
+	for _, f := range fieldSpecs {
+		*f.target, err = parseCalendarField(f.value, f.min, f.max)
+		if err != nil {
+			return invalid(err)
+		}
+	}
+
+	return spec, nil
+}
+
+/*
+parseWeekdays parses a list of weekday names and ranges such as
+"Mon,Wed..Fri" into a bitmask
+*/
+func parseWeekdays(s string) (uint8, error) {
+	var mask uint8
+	for _, item := range strings.Split(s, ",") {
+		bounds := strings.SplitN(item, "..", 2)
+		start, err := parseWeekday(bounds[0])
+		if err != nil {
+			return 0, err
+		}
+		end := start
+		if len(bounds) == 2 {
+			if end, err = parseWeekday(bounds[1]); err != nil {
+				return 0, err
+			}
+		}
+		for d := start; ; d = (d + 1) % 7 {
+			mask |= 1 << uint(d)
+			if d == end {
+				break
+			}
+		}
+	}
+	return mask, nil
+}
+
+func parseWeekday(s string) (int, error) {
+	for i, name := range weekdayNames {
+		full := strings.ToLower(time.Weekday(i).String())
+		lower := strings.ToLower(s)
+		if lower == strings.ToLower(name) || lower == full {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q", s)
+}
+
+/*
+parseCalendarField parses one date or time field
+*/
+func parseCalendarField(s string, min int, max int) ([]calendarComponent, error) {
+	if s == "*" {
+		return nil, nil
+	}
+
+	var out []calendarComponent
+	for _, item := range strings.Split(s, ",") {
+		c := calendarComponent{}
+
+		value := item
+		if i := strings.Index(item, "/"); i >= 0 {
+			repeat, err := strconv.Atoi(item[i+1:])
+			if err != nil || repeat <= 0 {
+				return nil, fmt.Errorf("invalid repetition %q", item)
+			}
+			c.repeat = repeat
+			value = item[:i]
+		}
+
+		bounds := strings.SplitN(value, "..", 2)
+		if bounds[0] == "*" {
+			c.start = min
+		} else {
+			start, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", item)
+			}
+			c.start = start
+		}
+
+		c.end = c.start
+		if c.repeat > 0 {
+			c.end = -1
+		}
+		if len(bounds) == 2 {
+			end, err := strconv.Atoi(bounds[1])
+			if err != nil || end < c.start {
+				return nil, fmt.Errorf("invalid range %q", item)
+			}
+			c.end = end
+		}
+
+		if c.start < min || c.start > max || c.end > max {
+			return nil, fmt.Errorf("value %q out of range %d..%d", item, min, max)
+		}
+
+		out = append(out, c)
+	}
+
+	return out, nil
+}
+
+/*
+String returns the normalized form of the expression, as systemd
+would print it
+*/
+func (c *CalendarSpec) String() string {
+	var parts []string
+
+	if c.weekdays != 0 {
+		parts = append(parts, formatWeekdays(c.weekdays))
+	}
+
+	parts = append(parts,
+		formatCalendarField(c.year, 4)+"-"+formatCalendarField(c.month, 2)+"-"+formatCalendarField(c.day, 2),
+		formatCalendarField(c.hour, 2)+":"+formatCalendarField(c.minute, 2)+":"+formatCalendarField(c.second, 2),
+	)
+
+	if c.location != time.Local {
+		parts = append(parts, c.location.String())
+	}
+
+	return strings.Join(parts, " ")
+}
+
+/*
+formatWeekdays formats the weekday bitmask, joining consecutive days
+to ranges
+*/
+func formatWeekdays(mask uint8) string {
+	var items []string
+	// Weeks start on Monday for systemd
+	order := []int{1, 2, 3, 4, 5, 6, 0}
+	for i := 0; i < len(order); i++ {
+		if mask&(1<<uint(order[i])) == 0 {
+			continue
+		}
+		j := i
+		for j+1 < len(order) && mask&(1<<uint(order[j+1])) != 0 {
+			j++
+		}
+		switch {
+		case j == i:
+			items = append(items, weekdayNames[order[i]])
+		case j == i+1:
+			items = append(items, weekdayNames[order[i]], weekdayNames[order[j]])
+		default:
+			items = append(items, weekdayNames[order[i]]+".."+weekdayNames[order[j]])
+		}
+		i = j
+	}
+	return strings.Join(items, ",")
+}
+
+func formatCalendarField(components []calendarComponent, width int) string {
+	if components == nil {
+		return "*"
+	}
+
+	pad := func(v int) string {
+		return fmt.Sprintf("%0*d", width, v)
+	}
+
+	var items []string
+	for _, c := range components {
+		s := pad(c.start)
+		if c.end != c.start && c.end != -1 {
+			s += ".." + pad(c.end)
+		}
+		if c.repeat > 0 {
+			s += "/" + strconv.Itoa(c.repeat)
+		}
+		items = append(items, s)
+	}
+	return strings.Join(items, ",")
+}
+
+/*
+matchCalendarField returns whether or not the value is allowed by the
+components of a field
+*/
+func matchCalendarField(components []calendarComponent, v int) bool {
+	if components == nil {
+		return true
+	}
+
+	for _, c := range components {
+		if v < c.start || (c.end != -1 && v > c.end) {
+			continue
+		}
+		if c.repeat == 0 || (v-c.start)%c.repeat == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Next returns the first time after the given time the expression
+elapses, or the zero time if it never does within the next ten years
+*/
+func (c *CalendarSpec) Next(after time.Time) time.Time {
+	t := after.In(c.location).Truncate(time.Second).Add(time.Second)
+	first := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.location)
+
+	for i := 0; i < 366*10; i++ {
+		day := first.AddDate(0, 0, i)
+
+		if !matchCalendarField(c.year, day.Year()) ||
+			!matchCalendarField(c.month, int(day.Month())) ||
+			!matchCalendarField(c.day, day.Day()) ||
+			(c.weekdays != 0 && c.weekdays&(1<<uint(day.Weekday())) == 0) {
+			continue
+		}
+
+		for h := 0; h < 24; h++ {
+			if !matchCalendarField(c.hour, h) {
+				continue
+			}
+			for m := 0; m < 60; m++ {
+				if !matchCalendarField(c.minute, m) {
+					continue
+				}
+				for s := 0; s < 60; s++ {
+					if !matchCalendarField(c.second, s) {
+						continue
+					}
+					next := time.Date(day.Year(), day.Month(), day.Day(), h, m, s, 0, c.location)
+					if !next.Before(t) {
+						return next
+					}
+				}
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+/*
+launchdIntervals converts the expression into the list of
+StartCalendarInterval dictionaries of launchd, which only supports
+minute precision, has no years and matches either the day of the
+month or the weekday.
+*/
+func (c *CalendarSpec) launchdIntervals() ([]map[string]int, error) {
+	if c.year != nil {
+		return nil, fmt.Errorf("launchd calendar intervals cannot restrict the year")
+	}
+	if c.location != time.Local {
+		return nil, fmt.Errorf("launchd calendar intervals are always in local time")
+	}
+	if seconds := expandCalendarField(c.second, 0, 59); len(seconds) != 1 || seconds[0] != 0 {
+		return nil, fmt.Errorf("launchd calendar intervals have minute precision")
+	}
+	if c.weekdays != 0 && c.day != nil {
+		return nil, fmt.Errorf("launchd calendar intervals cannot restrict both the day and weekday")
+	}
+
+	var weekdays []int
+	for d := 0; d < 7; d++ {
+		if c.weekdays&(1<<uint(d)) != 0 {
+			weekdays = append(weekdays, d)
+		}
+	}
+
+	intervals := []map[string]int{{}}
+	expand := func(key string, values []int) {
+		if values == nil {
+			return
+		}
+		var out []map[string]int
+		for _, interval := range intervals {
+			for _, v := range values {
+				next := map[string]int{key: v}
+				for k, existing := range interval {
+					next[k] = existing
+				}
+				out = append(out, next)
+			}
+		}
+		intervals = out
+	}
+
+	expand("Month", expandCalendarField(c.month, 1, 12))
+	expand("Day", expandCalendarField(c.day, 1, 31))
+	expand("Weekday", weekdays)
+	expand("Hour", expandCalendarField(c.hour, 0, 23))
+	expand("Minute", expandCalendarField(c.minute, 0, 59))
+
+	return intervals, nil
+}
+
+/*
+expandCalendarField lists every value allowed by a field, or nil for
+a wildcard
+*/
+func expandCalendarField(components []calendarComponent, min int, max int) []int {
+	if components == nil {
+		return nil
+	}
+
+	var values []int
+	for v := min; v <= max; v++ {
+		if matchCalendarField(components, v) {
+			values = append(values, v)
+		}
+	}
+	return values
+}
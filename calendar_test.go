@@ -0,0 +1,98 @@
+package systemservice
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCalendar(t *testing.T) {
+	assert := assert.New(t)
+	tables := []struct {
+		expr       string
+		normalized string
+	}{
+		{"daily", "*-*-* 00:00:00"},
+		{"weekly", "Mon *-*-* 00:00:00"},
+		{"quarterly", "*-01,04,07,10-01 00:00:00"},
+		{"Mon..Fri 9:00", "Mon..Fri *-*-* 09:00:00"},
+		{"sat,sun *-*-* 10:30", "Sat,Sun *-*-* 10:30:00"},
+		{"Mon,Tue,Wed,Fri 12:00", "Mon..Wed,Fri *-*-* 12:00:00"},
+		{"*:0/15", "*-*-* *:00/15:00"},
+		{"2030-1-1", "2030-01-01 00:00:00"},
+		{"12-24 18:00", "*-12-24 18:00:00"},
+		{"*-*-1..7 9..17/2:00", "*-*-01..07 09..17/2:00:00"},
+		{"daily UTC", "*-*-* 00:00:00 UTC"},
+	}
+
+	for _, table := range tables {
+		spec, err := ParseCalendar(table.expr)
+		if assert.NoError(err, table.expr) {
+			assert.Equal(table.normalized, spec.String(), table.expr)
+		}
+	}
+}
+
+func TestParseCalendarInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, expr := range []string{
+		"",
+		"Funday 10:00",
+		"*-13-01",
+		"25:00",
+		"10:00 *-*-* 10:00",
+		"*-02~01",
+		"*:0/0",
+		"5..1:00",
+		"10:00:00:00",
+	} {
+		_, err := ParseCalendar(expr)
+		assert.Error(err, expr)
+	}
+}
+
+func TestCalendarNext(t *testing.T) {
+	assert := assert.New(t)
+	// A Wednesday
+	now := time.Date(2024, 1, 3, 10, 20, 30, 0, time.UTC)
+	tables := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"hourly UTC", time.Date(2024, 1, 3, 11, 0, 0, 0, time.UTC)},
+		{"*:0/15 UTC", time.Date(2024, 1, 3, 10, 30, 0, 0, time.UTC)},
+		{"Mon 09:00 UTC", time.Date(2024, 1, 8, 9, 0, 0, 0, time.UTC)},
+		{"Wed 10:20:30 UTC", time.Date(2024, 1, 10, 10, 20, 30, 0, time.UTC)},
+		{"*-02-29 UTC", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"2023-01-01 UTC", time.Time{}},
+	}
+
+	for _, table := range tables {
+		spec, err := ParseCalendar(table.expr)
+		if assert.NoError(err, table.expr) {
+			assert.True(table.expected.Equal(spec.Next(now)), "%s: %s", table.expr, spec.Next(now))
+		}
+	}
+}
+
+func TestCalendarLaunchdIntervals(t *testing.T) {
+	assert := assert.New(t)
+
+	spec, _ := ParseCalendar("Mon,Fri 09,17:30")
+	intervals, err := spec.launchdIntervals()
+	assert.NoError(err)
+	assert.Equal([]map[string]int{
+		{"Weekday": 1, "Hour": 9, "Minute": 30},
+		{"Weekday": 1, "Hour": 17, "Minute": 30},
+		{"Weekday": 5, "Hour": 9, "Minute": 30},
+		{"Weekday": 5, "Hour": 17, "Minute": 30},
+	}, intervals)
+
+	for _, expr := range []string{"*:*:0/10", "2030-01-01", "Mon *-*-01"} {
+		spec, _ := ParseCalendar(expr)
+		_, err := spec.launchdIntervals()
+		assert.Error(err, expr)
+	}
+}
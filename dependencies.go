@@ -0,0 +1,98 @@
+package systemservice
+
+import "strings"
+
+/*
+Dependencies configures the ordering of the service relative to other
+units and which units it pulls in. Only used by systemd.
+
+Every entry is either a full unit name (e.g. "postgresql.service" or
+"network.target") or the Label of another service managed by
+systemservice, in which case ".service" is appended.
+*/
+type Dependencies struct {
+	// Start this service after the given units have started.
+	After []string
+
+	// Start this service before the given units.
+	Before []string
+
+	// Units which must be started with this service. If one of them
+	// fails to start, this service is not started either.
+	Requires []string
+
+	// Units which are started with this service, but whose failure
+	// does not prevent this service from starting.
+	Wants []string
+
+	// Like Requires, but this service is also stopped whenever one of
+	// the given units stops.
+	BindsTo []string
+
+	// Units whose stop and restart is propagated to this service, e.g.
+	// restart an API whenever its database is restarted.
+	PartOf []string
+
+	// Units which can not run at the same time as this service.
+	Conflicts []string
+
+	// Whether or not to wait until the network is configured before
+	// starting the service (network-online.target).
+	NetworkOnline bool
+}
+
+/*
+directives returns the [Unit] directives for the dependencies. The
+service is always ordered after network.target.
+*/
+func (d Dependencies) directives() []Directive {
+	after := append([]string{"network.target"}, d.After...)
+	wants := d.Wants
+	if d.NetworkOnline {
+		after = append(after, "network-online.target")
+		wants = append([]string{"network-online.target"}, wants...)
+	}
+
+	var out []Directive
+	add := func(key string, names []string) {
+		if len(names) == 0 {
+			return
+		}
+		units := make([]string, len(names))
+		for i, name := range names {
+			units[i] = unitName(name)
+		}
+		out = append(out, Directive{Key: key, Value: strings.Join(units, " ")})
+	}
+
+	add("After", after)
+	add("Before", d.Before)
+	add("Requires", d.Requires)
+	add("Wants", wants)
+	add("BindsTo", d.BindsTo)
+	add("PartOf", d.PartOf)
+	add("Conflicts", d.Conflicts)
+
+	return out
+}
+
+/*
+unitSuffixes are the unit types systemd knows about
+*/
+var unitSuffixes = []string{
+	".service", ".socket", ".target", ".timer", ".path", ".mount",
+	".automount", ".swap", ".device", ".slice", ".scope",
+}
+
+/*
+unitName returns the name as is if it already is a unit name, otherwise
+it is treated as the label of a service and ".service" is appended.
+*/
+func unitName(name string) string {
+	for _, suffix := range unitSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return name
+		}
+	}
+	return name + ".service"
+}
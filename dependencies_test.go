@@ -0,0 +1,46 @@
+package systemservice
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependenciesDirectives(t *testing.T) {
+	assert := assert.New(t)
+	tables := []struct {
+		dependencies Dependencies
+		expected     []Directive
+	}{
+		{
+			dependencies: Dependencies{},
+			expected:     []Directive{{Key: "After", Value: "network.target"}},
+		},
+		{
+			dependencies: Dependencies{
+				After:  []string{"com.example.db", "postgresql.service"},
+				PartOf: []string{"com.example.db"},
+			},
+			expected: []Directive{
+				{Key: "After", Value: "network.target com.example.db.service postgresql.service"},
+				{Key: "PartOf", Value: "com.example.db.service"},
+			},
+		},
+		{
+			dependencies: Dependencies{
+				NetworkOnline: true,
+				Wants:         []string{"redis"},
+				Conflicts:     []string{"shutdown.target"},
+			},
+			expected: []Directive{
+				{Key: "After", Value: "network.target network-online.target"},
+				{Key: "Wants", Value: "network-online.target redis.service"},
+				{Key: "Conflicts", Value: "shutdown.target"},
+			},
+		},
+	}
+
+	for _, table := range tables {
+		assert.Equal(table.expected, table.dependencies.directives())
+	}
+}
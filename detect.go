@@ -0,0 +1,71 @@
+package systemservice
+
+import (
+	"errors"
+	"strings"
+)
+
+/*
+ErrNoSupportedInitSystem is returned if the init system of the machine
+cannot be driven by the package, e.g. in a container without an init
+system. The returned errors wrap it with what DetectBackend found.
+*/
+var ErrNoSupportedInitSystem = errors.New("no supported init system found")
+
+/*
+Detection is what DetectBackend found out about the init system of the
+machine
+*/
+type Detection struct {
+	// The backend services are managed with, empty if the init system
+	// is not supported.
+	Backend Backend
+
+	// The init system found, e.g. "systemd", "openrc", "runit", "s6"
+	// or "sysvinit". Empty if unknown.
+	InitSystem string
+
+	// The container runtime the process runs in, e.g. "docker",
+	// "podman" or "lxc". Empty if no container was detected.
+	Container string
+
+	// What was checked and found, in order
+	Reasons []string
+}
+
+/*
+String summarizes the detection, e.g.
+`openrc (PID 1 is openrc-init; /sbin/openrc exists)`
+*/
+func (d *Detection) String() string {
+	found := d.InitSystem
+	if found == "" {
+		found = "unknown init system"
+	}
+	if d.Container != "" {
+		found += " in a " + d.Container + " container"
+	}
+	if len(d.Reasons) == 0 {
+		return found
+	}
+	return found + " (" + strings.Join(d.Reasons, "; ") + ")"
+}
+
+/*
+reason records a check
+*/
+func (d *Detection) reason(reason string) {
+	d.Reasons = append(d.Reasons, reason)
+}
+
+/*
+DetectBackend detects the init system of the machine and the backend
+managing its services. It returns an error wrapping
+ErrNoSupportedInitSystem, along with the detection, if the init system
+is not supported. Pass WithDetectionFS to detect the init system of a
+fake root.
+*/
+func DetectBackend(opts ...Option) (*Detection, error) {
+	s := New(ServiceCommand{}, opts...)
+	return s.detect()
+}
@@ -0,0 +1,184 @@
+// +build linux
+
+package systemservice
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+/*
+initMarkers are the files and directories installed by the init systems
+other than systemd, in the order they are checked
+*/
+var initMarkers = []struct {
+	initSystem string
+	paths      []string
+}{
+	{"openrc", []string{"/sbin/openrc", "/sbin/openrc-run", "/run/openrc"}},
+	{"runit", []string{"/etc/runit", "/run/runit"}},
+	{"s6", []string{"/run/s6", "/etc/s6", "/etc/s6-overlay"}},
+	{"sysvinit", []string{"/etc/inittab"}},
+}
+
+/*
+initPrograms maps the program running as PID 1 to its init system
+*/
+var initPrograms = map[string]string{
+	"systemd":     "systemd",
+	"openrc-init": "openrc",
+	"runit":       "runit",
+	"runsvdir":    "runit",
+	"s6-svscan":   "s6",
+}
+
+/*
+hostDetection caches the detection of the init system of the machine,
+which does not change while the process runs
+*/
+var hostDetection struct {
+	once sync.Once
+	d    *Detection
+	err  error
+}
+
+/*
+detect detects the init system on the file system set with
+WithDetectionFS, or on the one of the machine, which is only detected
+once
+*/
+func (s *SystemService) detect() (*Detection, error) {
+	if s.detectionFS != nil {
+		return detectInitSystem(s.detectionFS)
+	}
+
+	hostDetection.once.Do(func() {
+		hostDetection.d, hostDetection.err = detectInitSystem(afero.NewOsFs())
+	})
+
+	d := *hostDetection.d
+	return &d, hostDetection.err
+}
+
+/*
+detectInitSystem checks for a booted systemd first, then for the
+program running as PID 1 and, if that is a generic init, for the files
+of the other init systems
+*/
+func detectInitSystem(fs afero.Fs) (*Detection, error) {
+	d := &Detection{}
+
+	d.Container = detectContainer(fs, d)
+
+	if info, err := fs.Stat("/run/systemd/system"); err == nil && info.IsDir() {
+		d.reason("/run/systemd/system exists")
+		d.InitSystem = "systemd"
+	} else {
+		d.InitSystem = detectInit(fs, d)
+	}
+
+	switch d.InitSystem {
+	case "systemd":
+		d.Backend = BackendSystemd
+		return d, nil
+	case "sysvinit":
+		d.Backend = BackendSysV
+		return d, nil
+	case "openrc":
+		d.Backend = BackendOpenRC
+		return d, nil
+	}
+
+	return d, fmt.Errorf("%w: %s", ErrNoSupportedInitSystem, d)
+}
+
+/*
+detectInit returns the init system running as PID 1
+*/
+func detectInit(fs afero.Fs, d *Detection) string {
+	content, err := afero.ReadFile(fs, "/proc/1/comm")
+	comm := strings.TrimSpace(string(content))
+
+	if err != nil || comm == "" {
+		d.reason("PID 1 is unknown")
+	} else {
+		d.reason("PID 1 is " + comm)
+		if initSystem, ok := initPrograms[comm]; ok {
+			return initSystem
+		}
+
+		// Anything else than init is not an init system, e.g. the
+		// program of a container or tini
+		if comm != "init" {
+			return ""
+		}
+	}
+
+	for _, marker := range initMarkers {
+		for _, path := range marker.paths {
+			if _, err := fs.Stat(path); err == nil {
+				d.reason(path + " exists")
+				return marker.initSystem
+			}
+		}
+	}
+
+	return ""
+}
+
+/*
+detectContainer returns the container runtime the process runs in
+*/
+func detectContainer(fs afero.Fs, d *Detection) string {
+	if _, err := fs.Stat("/.dockerenv"); err == nil {
+		d.reason("/.dockerenv exists")
+		return "docker"
+	}
+
+	if _, err := fs.Stat("/run/.containerenv"); err == nil {
+		d.reason("/run/.containerenv exists")
+		return "podman"
+	}
+
+	// Set by LXC, systemd-nspawn and others, only readable by root
+	if environ, err := afero.ReadFile(fs, "/proc/1/environ"); err == nil {
+		for _, v := range strings.Split(string(environ), "\x00") {
+			if strings.HasPrefix(v, "container=") {
+				d.reason("PID 1 has " + v + " set")
+				return strings.TrimPrefix(v, "container=")
+			}
+		}
+	}
+
+	if cgroup, err := afero.ReadFile(fs, "/proc/1/cgroup"); err == nil {
+		for _, runtime := range []string{"docker", "kubepods", "lxc"} {
+			if strings.Contains(string(cgroup), "/"+runtime) {
+				d.reason("/proc/1/cgroup is below /" + runtime)
+				if runtime == "kubepods" {
+					return "kubernetes"
+				}
+				return runtime
+			}
+		}
+	}
+
+	return ""
+}
+
+/*
+checkInitSystem returns an error wrapping ErrNoSupportedInitSystem if
+no supported init system manages the services of the machine. Services installed
+below a Root and services whose backend was chosen with WithBackend are
+not checked.
+*/
+func (s *SystemService) checkInitSystem() error {
+	if s.Command.Root != "" || s.backendOption != "" {
+		return nil
+	}
+
+	_, err := s.detect()
+	return err
+}
@@ -0,0 +1,21 @@
+// +build !linux
+
+package systemservice
+
+/*
+detect returns the service manager of the platform, it is always there
+*/
+func (s *SystemService) detect() (*Detection, error) {
+	return &Detection{
+		Backend:    platformBackend,
+		InitSystem: string(platformBackend),
+	}, nil
+}
+
+/*
+checkInitSystem does nothing, the service manager of the platform is
+always there
+*/
+func (s *SystemService) checkInitSystem() error {
+	return nil
+}
@@ -0,0 +1,153 @@
+// +build linux
+
+package systemservice
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+TestMain detects systemd on the machine, so the tests do not depend on
+the init system of the one running them
+*/
+func TestMain(m *testing.M) {
+	hostDetection.once.Do(func() {
+		hostDetection.d = &Detection{
+			Backend:    BackendSystemd,
+			InitSystem: "systemd",
+			Reasons:    []string{"/run/systemd/system exists"},
+		}
+	})
+
+	os.Exit(m.Run())
+}
+
+func TestDetectBackend(t *testing.T) {
+	assert := assert.New(t)
+	tables := []struct {
+		files      map[string]string
+		backend    Backend
+		initSystem string
+		container  string
+		reasons    []string
+	}{
+		{
+			files:      map[string]string{"/run/systemd/system/": "", "/proc/1/comm": "systemd\n"},
+			backend:    BackendSystemd,
+			initSystem: "systemd",
+			reasons:    []string{"/run/systemd/system exists"},
+		},
+		{
+			// systemd installed but not booted, e.g. in a chroot
+			files:   map[string]string{"/lib/systemd/systemd": "", "/proc/1/comm": "bash\n"},
+			reasons: []string{"PID 1 is bash"},
+		},
+		{
+			files:      map[string]string{"/proc/1/comm": "init\n", "/sbin/openrc": "", "/etc/inittab": ""},
+			backend:    BackendOpenRC,
+			initSystem: "openrc",
+			reasons:    []string{"PID 1 is init", "/sbin/openrc exists"},
+		},
+		{
+			files:      map[string]string{"/proc/1/comm": "openrc-init\n"},
+			backend:    BackendOpenRC,
+			initSystem: "openrc",
+			reasons:    []string{"PID 1 is openrc-init"},
+		},
+		{
+			files:      map[string]string{"/proc/1/comm": "runit\n"},
+			initSystem: "runit",
+			reasons:    []string{"PID 1 is runit"},
+		},
+		{
+			files:      map[string]string{"/proc/1/comm": "s6-svscan\n", "/.dockerenv": ""},
+			initSystem: "s6",
+			container:  "docker",
+			reasons:    []string{"/.dockerenv exists", "PID 1 is s6-svscan"},
+		},
+		{
+			files:      map[string]string{"/etc/inittab": ""},
+			backend:    BackendSysV,
+			initSystem: "sysvinit",
+			reasons:    []string{"PID 1 is unknown", "/etc/inittab exists"},
+		},
+		{
+			files:     map[string]string{"/proc/1/comm": "node\n", "/run/.containerenv": ""},
+			container: "podman",
+			reasons:   []string{"/run/.containerenv exists", "PID 1 is node"},
+		},
+		{
+			files:      map[string]string{"/proc/1/environ": "PATH=/bin\x00container=lxc\x00", "/run/systemd/system/": ""},
+			backend:    BackendSystemd,
+			initSystem: "systemd",
+			container:  "lxc",
+			reasons:    []string{"PID 1 has container=lxc set", "/run/systemd/system exists"},
+		},
+		{
+			files:     map[string]string{"/proc/1/comm": "tini\n", "/proc/1/cgroup": "0::/kubepods/besteffort/pod1\n"},
+			container: "kubernetes",
+			reasons:   []string{"/proc/1/cgroup is below /kubepods", "PID 1 is tini"},
+		},
+	}
+
+	for _, table := range tables {
+		fs := afero.NewMemMapFs()
+		for path, content := range table.files {
+			if path[len(path)-1] == '/' {
+				assert.NoError(fs.MkdirAll(path, 0755))
+				continue
+			}
+			assert.NoError(afero.WriteFile(fs, path, []byte(content), 0644))
+		}
+
+		d, err := DetectBackend(WithDetectionFS(fs))
+		assert.Equal(table.backend, d.Backend)
+		assert.Equal(table.initSystem, d.InitSystem)
+		assert.Equal(table.container, d.Container)
+		assert.Equal(table.reasons, d.Reasons)
+
+		if table.backend == "" {
+			assert.True(errors.Is(err, ErrNoSupportedInitSystem))
+			assert.Contains(err.Error(), d.String())
+		} else {
+			assert.NoError(err)
+		}
+	}
+}
+
+func TestNoSupportedInitSystem(t *testing.T) {
+	assert := assert.New(t)
+
+	fs := afero.NewMemMapFs()
+	assert.NoError(afero.WriteFile(fs, "/proc/1/comm", []byte("init\n"), 0644))
+	assert.NoError(afero.WriteFile(fs, "/etc/runit/1", nil, 0755))
+
+	serv := New(ServiceCommand{Label: "app", Program: "/usr/bin/app"}, WithFS(fs), WithDetectionFS(fs))
+
+	err := serv.Install(true)
+	assert.True(errors.Is(err, ErrNoSupportedInitSystem))
+	assert.Equal("no supported init system found: runit (PID 1 is init; /etc/runit exists)", err.Error())
+	assert.False(serv.Exists())
+
+	_, err = serv.Status()
+	assert.True(errors.Is(err, ErrNoSupportedInitSystem))
+
+	// Choosing the backend or installing into an image skips the detection
+	forced := New(ServiceCommand{}, WithDetectionFS(fs), WithBackend(BackendSystemd))
+	assert.NoError(forced.checkBackend())
+
+	image := New(ServiceCommand{Root: "/image"}, WithDetectionFS(fs))
+	assert.NoError(image.checkBackend())
+
+	// The file system of the service is not the one of the machine
+	inMemory := New(ServiceCommand{Label: "app", Program: "/usr/bin/app"}, WithFS(fs))
+	assert.NoError(inMemory.checkBackend())
+	d, err := DetectBackend(WithFS(fs))
+	assert.NoError(err)
+	assert.Equal(BackendSystemd, d.Backend)
+}
@@ -0,0 +1,367 @@
+package systemservice
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+/*
+Diff describes how the installed service differs from the one the
+ServiceCommand describes. It only lists the files which differ.
+*/
+type Diff struct {
+	Files []FileDiff
+}
+
+/*
+FileDiff is a file of the service whose installed content differs
+from the desired content
+*/
+type FileDiff struct {
+	// The path of the file. On Windows this is the registry key of
+	// the service.
+	Path string
+
+	Installed string
+	Desired   string
+
+	// Whether or not the file is not installed yet
+	Missing bool
+
+	// Whether or not the file is installed but no longer needed, e.g.
+	// the timer unit of a service which is no longer scheduled
+	Stale bool
+}
+
+/*
+Changed returns whether or not the installed service differs from
+the desired one
+*/
+func (d *Diff) Changed() bool {
+	return len(d.Files) > 0
+}
+
+/*
+String returns the unified diff of all files
+*/
+func (d *Diff) String() string {
+	var b strings.Builder
+	for _, f := range d.Files {
+		b.WriteString(f.Unified())
+	}
+	return b.String()
+}
+
+/*
+Unified returns the unified diff from the installed to the desired
+content of the file
+*/
+func (f FileDiff) Unified() string {
+	from, to := f.Path, f.Path
+	if f.Missing {
+		from = "/dev/null"
+	}
+	if f.Stale {
+		to = "/dev/null"
+	}
+	return unifiedDiff(from, to, f.Installed, f.Desired)
+}
+
+/*
+compareFile reads the installed file at path and compares it to the
+desired content, returning nil if they are the same
+*/
+func compareFile(fs afero.Fs, path string, desired string) (*FileDiff, error) {
+	installed, err := afero.ReadFile(fs, path)
+
+	if os.IsNotExist(err) {
+		return &FileDiff{Path: path, Desired: desired, Missing: true}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if string(installed) == desired {
+		return nil, nil
+	}
+
+	return &FileDiff{Path: path, Installed: string(installed), Desired: desired}, nil
+}
+
+/*
+staleFile reads an installed file which is no longer desired,
+returning nil if it does not exist
+*/
+func staleFile(fs afero.Fs, path string) (*FileDiff, error) {
+	installed, err := afero.ReadFile(fs, path)
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileDiff{Path: path, Installed: string(installed), Stale: true}, nil
+}
+
+/*
+applyFile writes the desired content of the file, or removes it if it
+is stale
+*/
+func (s *SystemService) applyFile(f FileDiff) error {
+	if f.Stale {
+		s.log().Info("removing file", "path", f.Path)
+
+		err := s.fs().Remove(f.Path)
+
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return nil
+	}
+
+	s.log().Debug("writing file", "path", f.Path)
+
+	return writeFileAtomic(s.fs(), f.Path, []byte(f.Desired), 0644)
+}
+
+/*
+diffContext is the number of unchanged lines shown around changes
+*/
+const diffContext = 3
+
+/*
+diffOp is a line of an edit script: ' ' keeps, '-' removes and '+'
+adds the line
+*/
+type diffOp struct {
+	kind byte
+	line string
+}
+
+/*
+diffLines returns the shortest edit script turning a into b, based on
+their longest common subsequence
+*/
+func diffLines(a []string, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+
+	return ops
+}
+
+/*
+splitLines splits the content into lines, without the trailing
+newline
+*/
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+/*
+unifiedDiff returns the unified diff between two versions of a file,
+or an empty string if they are the same
+*/
+func unifiedDiff(fromName string, toName string, from string, to string) string {
+	ops := diffLines(splitLines(from), splitLines(to))
+
+	// The line numbers in the old and new file before each op
+	oldLine := make([]int, len(ops)+1)
+	newLine := make([]int, len(ops)+1)
+	for i, op := range ops {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if op.kind != '+' {
+			oldLine[i+1]++
+		}
+		if op.kind != '-' {
+			newLine[i+1]++
+		}
+	}
+
+	var b strings.Builder
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+
+		// Extend the hunk over changes separated by few enough
+		// unchanged lines to share their context
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*diffContext {
+				break
+			}
+			end = next
+		}
+
+		stop := end + diffContext
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		if b.Len() == 0 {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+		}
+
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n",
+			hunkRange(oldLine[start], oldLine[stop]-oldLine[start]),
+			hunkRange(newLine[start], newLine[stop]-newLine[start]))
+
+		for _, op := range ops[start:stop] {
+			fmt.Fprintf(&b, "%c%s\n", op.kind, op.line)
+		}
+
+		i = stop
+	}
+
+	return b.String()
+}
+
+/*
+hunkRange formats the range of a hunk header. Empty ranges are
+numbered after the line they follow.
+*/
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+/*
+allSections stands for every section of a unit file which does not
+parse
+*/
+const allSections = "*"
+
+/*
+changedSections returns the names of the unit file sections which
+differ between the installed and desired content. If either does not
+parse, everything is reported as changed.
+*/
+func changedSections(installed string, desired string) []string {
+	a, errA := parseSections(installed)
+	b, errB := parseSections(desired)
+
+	if errA != nil || errB != nil {
+		return []string{allSections}
+	}
+
+	var changed []string
+	seen := map[string]bool{}
+
+	for _, s := range append(a, b...) {
+		if seen[s.Name] {
+			continue
+		}
+		seen[s.Name] = true
+
+		if !sameDirectives(findSection(a, s.Name), findSection(b, s.Name)) {
+			changed = append(changed, s.Name)
+		}
+	}
+
+	return changed
+}
+
+func findSection(sections []Section, name string) []Directive {
+	var directives []Directive
+	for _, s := range sections {
+		if s.Name == name {
+			directives = append(directives, s.Directives...)
+		}
+	}
+	return directives
+}
+
+func sameDirectives(a []Directive, b []Directive) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Key != b[i].Key || a[i].Value != b[i].Value {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+needsRestart returns whether or not a change of the unit requires the
+unit to be restarted. The [Unit] and [Install] sections only take
+effect on reload and enable.
+*/
+func needsRestart(sections []string) bool {
+	for _, name := range sections {
+		if name != "Unit" && name != "Install" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package systemservice
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	assert := assert.New(t)
+	tables := []struct {
+		from     string
+		to       string
+		expected string
+	}{
+		{
+			from:     "a\nb\nc\n",
+			to:       "a\nb\nc\n",
+			expected: "",
+		},
+		{
+			from:     "",
+			to:       "a\nb\n",
+			expected: "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			from:     "1\n2\n3\n4\n5\n6\n7\n",
+			to:       "1\n2\n3\nfour\n5\n6\n7\n",
+			expected: "--- old\n+++ new\n@@ -1,7 +1,7 @@\n 1\n 2\n 3\n-4\n+four\n 5\n 6\n 7\n",
+		},
+		{
+			from: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			to:   "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n",
+			expected: "--- old\n+++ new\n" +
+				"@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n" +
+				"@@ -9,4 +9,3 @@\n 9\n 10\n 11\n-12\n",
+		},
+	}
+
+	for _, table := range tables {
+		assert.Equal(table.expected, unifiedDiff("old", "new", table.from, table.to))
+	}
+}
+
+func TestCompareFile(t *testing.T) {
+	assert := assert.New(t)
+
+	fs := afero.NewMemMapFs()
+	serv := New(ServiceCommand{}, WithFS(fs))
+	path := "/etc/systemd/system/app.service"
+
+	f, err := compareFile(fs, path, "[Service]\n")
+	assert.NoError(err)
+	assert.Equal(&FileDiff{Path: path, Desired: "[Service]\n", Missing: true}, f)
+
+	assert.NoError(serv.applyFile(*f))
+
+	f, err = compareFile(fs, path, "[Service]\n")
+	assert.NoError(err)
+	assert.Nil(f)
+
+	f, err = compareFile(fs, path, "[Service]\nType=oneshot\n")
+	assert.NoError(err)
+	assert.Equal("--- "+path+"\n+++ "+path+"\n@@ -1 +1,2 @@\n [Service]\n+Type=oneshot\n", f.Unified())
+
+	f, err = staleFile(fs, path)
+	assert.NoError(err)
+	assert.True(f.Stale)
+	assert.NoError(serv.applyFile(*f))
+
+	f, err = staleFile(fs, path)
+	assert.NoError(err)
+	assert.Nil(f)
+}
+
+func TestChangedSections(t *testing.T) {
+	assert := assert.New(t)
+
+	installed := "[Unit]\nDescription=App\n\n[Service]\nExecStart=/bin/app\n\n[Install]\nWantedBy=multi-user.target\n"
+
+	described := "[Unit]\nDescription=My app\n\n[Service]\nExecStart=/bin/app\n\n[Install]\nWantedBy=multi-user.target\n"
+	assert.Equal([]string{"Unit"}, changedSections(installed, described))
+	assert.False(needsRestart(changedSections(installed, described)))
+
+	command := "[Unit]\nDescription=App\n\n[Service]\nExecStart=/bin/app --verbose\n\n[Install]\nWantedBy=multi-user.target\n"
+	assert.Equal([]string{"Service"}, changedSections(installed, command))
+	assert.True(needsRestart(changedSections(installed, command)))
+
+	assert.Empty(changedSections(installed, installed))
+
+	edited := "[Service\nExecStart=/bin/app\n"
+	assert.Equal([]string{allSections}, changedSections(edited, command))
+	assert.True(needsRestart(changedSections(edited, command)))
+}
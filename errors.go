@@ -1,6 +1,9 @@
 package systemservice
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 /*
 ServiceDoesNotExistError is an error return if a given service does
@@ -17,3 +20,89 @@ Error implements the errors.Error interface
 func (e *ServiceDoesNotExistError) Error() string {
 	return fmt.Sprintf("the service \"%s\" does not exist", e.serviceName)
 }
+
+/*
+NewServiceDoesNotExistError returns the error for a service which is
+not installed, e.g. for implementations of Service in tests
+*/
+func NewServiceDoesNotExistError(serviceName string) *ServiceDoesNotExistError {
+	return &ServiceDoesNotExistError{serviceName: serviceName}
+}
+
+/*
+ServiceNotOwnedError is returned if the user attempts to install or
+uninstall a service opened with Open.
+*/
+type ServiceNotOwnedError struct {
+	serviceName string
+}
+
+/*
+Error implements the errors.Error interface
+*/
+func (e *ServiceNotOwnedError) Error() string {
+	return fmt.Sprintf("the service \"%s\" was not installed by this program", e.serviceName)
+}
+
+/*
+UnsupportedOptionError is returned if the service command uses an
+option the service manager of the current platform cannot express.
+*/
+type UnsupportedOptionError struct {
+	Backend string
+	Option  string
+	Reason  string
+}
+
+/*
+Error implements the errors.Error interface
+*/
+func (e *UnsupportedOptionError) Error() string {
+	return fmt.Sprintf("%s does not support %s: %s", e.Backend, e.Option, e.Reason)
+}
+
+/*
+InvalidUnitError is returned by Install if Lint finds errors in the
+generated units, e.g. because of a bad Extra directive.
+*/
+type InvalidUnitError struct {
+	Issues []LintIssue
+}
+
+/*
+Error implements the errors.Error interface
+*/
+func (e *InvalidUnitError) Error() string {
+	issues := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		issues[i] = issue.String()
+	}
+	return "invalid unit: " + strings.Join(issues, "; ")
+}
+
+/*
+InstallError is returned by Install if a step after writing the
+first file failed. The files and the state of the units from before
+the install were restored, unless RollbackErr is set.
+*/
+type InstallError struct {
+	Err         error
+	RollbackErr error
+}
+
+/*
+Error implements the errors.Error interface
+*/
+func (e *InstallError) Error() string {
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("install failed: %v, rolling back failed: %v", e.Err, e.RollbackErr)
+	}
+	return fmt.Sprintf("install failed and was rolled back: %v", e.Err)
+}
+
+/*
+Unwrap returns the error which made the install fail
+*/
+func (e *InstallError) Unwrap() error {
+	return e.Err
+}
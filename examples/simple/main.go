@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/danawoodman/systemservice"
 )
@@ -67,10 +69,50 @@ func main() {
 func run() {
 	logger.Log("Running service...")
 
-	err := service.Run()
+	err := service.Run(&program{})
 	if err != nil {
-		panic(err)
+		logger.Log("service failed: ", err)
+		os.Exit(1)
+	}
+}
+
+/*
+program is the code the service runs, it logs a message every few
+seconds until it is stopped
+*/
+type program struct {
+	done chan struct{}
+}
+
+func (p *program) Start(ctx context.Context) error {
+	p.done = make(chan struct{})
+
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				logger.Log("[RUN] still running")
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (p *program) Stop(ctx context.Context) error {
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+	return nil
 }
 
 func status() {
@@ -1,5 +1,86 @@
 package systemservice
 
-import "github.com/spf13/afero"
+import (
+	"errors"
+	"os"
 
-var appFS = afero.NewOsFs()
+	"github.com/spf13/afero"
+)
+
+/*
+fs returns the file system of the service
+*/
+func (s *SystemService) fs() afero.Fs {
+	if s.FS == nil {
+		return afero.NewOsFs()
+	}
+	return s.FS
+}
+
+/*
+symlinker and linkReader are implemented by file systems supporting
+symbolic links. The methods are the ones of afero.Linker and
+afero.LinkReader in later versions of afero.
+*/
+type symlinker interface {
+	SymlinkIfPossible(oldname, newname string) error
+}
+
+type linkReader interface {
+	ReadlinkIfPossible(name string) (string, error)
+}
+
+var errNoSymlinks = errors.New("file system does not support symbolic links")
+
+/*
+symlink creates newname as a symbolic link to oldname
+*/
+func symlink(fs afero.Fs, oldname, newname string) error {
+	if l, ok := fs.(symlinker); ok {
+		return l.SymlinkIfPossible(oldname, newname)
+	}
+
+	if _, ok := fs.(*afero.OsFs); ok {
+		return os.Symlink(oldname, newname)
+	}
+
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: errNoSymlinks}
+}
+
+/*
+readlink returns the target of the symbolic link
+*/
+func readlink(fs afero.Fs, name string) (string, error) {
+	if l, ok := fs.(linkReader); ok {
+		return l.ReadlinkIfPossible(name)
+	}
+
+	if _, ok := fs.(*afero.OsFs); ok {
+		return os.Readlink(name)
+	}
+
+	return "", &os.PathError{Op: "readlink", Path: name, Err: errNoSymlinks}
+}
+
+/*
+lstat returns the file info of the file, not following symbolic links
+if the file system supports them
+*/
+func lstat(fs afero.Fs, name string) (os.FileInfo, error) {
+	if l, ok := fs.(afero.Lstater); ok {
+		info, _, err := l.LstatIfPossible(name)
+		return info, err
+	}
+
+	return fs.Stat(name)
+}
+
+/*
+removeEmptyDir removes the folder if it is empty. Not all file systems
+refuse to remove folders with files in them.
+*/
+func removeEmptyDir(fs afero.Fs, dir string) {
+	if empty, err := afero.IsEmpty(fs, dir); err == nil && empty {
+		fs.Remove(dir)
+	}
+}
@@ -0,0 +1,93 @@
+package systemservice
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+linkMemFs is an in-memory file system which supports symbolic links.
+Links are empty files whose target is kept aside, they are never
+followed.
+*/
+type linkMemFs struct {
+	afero.Fs
+	links map[string]string
+}
+
+func newLinkMemFs() *linkMemFs {
+	return &linkMemFs{Fs: afero.NewMemMapFs(), links: map[string]string{}}
+}
+
+func (fs *linkMemFs) SymlinkIfPossible(oldname, newname string) error {
+	if _, err := fs.Stat(newname); err == nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrExist}
+	}
+
+	if err := afero.WriteFile(fs.Fs, newname, nil, 0777); err != nil {
+		return err
+	}
+
+	fs.links[newname] = oldname
+
+	return nil
+}
+
+func (fs *linkMemFs) ReadlinkIfPossible(name string) (string, error) {
+	if _, err := fs.Stat(name); err != nil {
+		return "", err
+	}
+
+	target, ok := fs.links[name]
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+
+	return target, nil
+}
+
+func (fs *linkMemFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	info, err := fs.Stat(name)
+	return info, true, err
+}
+
+func TestSymlink(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Error(symlink(afero.NewMemMapFs(), "/etc/app.conf", "/etc/app.link"))
+
+	fs := newLinkMemFs()
+	assert.NoError(symlink(fs, "/etc/app.conf", "/etc/app.link"))
+	assert.Error(symlink(fs, "/etc/app.conf", "/etc/app.link"))
+
+	target, err := readlink(fs, "/etc/app.link")
+	assert.NoError(err)
+	assert.Equal("/etc/app.conf", target)
+
+	_, err = lstat(fs, "/etc/app.link")
+	assert.NoError(err)
+
+	assert.NoError(fs.Remove("/etc/app.link"))
+	_, err = readlink(fs, "/etc/app.link")
+	assert.True(os.IsNotExist(err))
+}
+
+func TestRemoveEmptyDir(t *testing.T) {
+	assert := assert.New(t)
+
+	fs := afero.NewMemMapFs()
+	assert.NoError(afero.WriteFile(fs, "/etc/full/file", nil, 0644))
+	assert.NoError(fs.MkdirAll("/etc/empty", 0755))
+
+	removeEmptyDir(fs, "/etc/full")
+	removeEmptyDir(fs, "/etc/empty")
+
+	assert.True(fileExists(fs, "/etc/full/file"))
+
+	_, err := fs.Stat("/etc/empty")
+	assert.True(os.IsNotExist(err))
+}
@@ -3,6 +3,7 @@ module github.com/danawoodman/systemservice
 go 1.13
 
 require (
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/spf13/afero v1.2.2
 	github.com/stretchr/testify v1.4.0
 	golang.org/x/sys v0.0.0-20191010194322-b09406accb47
@@ -0,0 +1,267 @@
+package systemservice
+
+import "fmt"
+
+/*
+HardeningLevel is a named preset of sandboxing directives applied
+to the generated systemd unit.
+*/
+type HardeningLevel string
+
+const (
+	// HardeningNone applies no sandboxing at all. This is the default.
+	HardeningNone HardeningLevel = "none"
+
+	// HardeningStandard applies sandboxing that is safe for most
+	// network daemons: the OS is read-only, home directories are
+	// hidden, kernel tunables are protected and privileges cannot
+	// be regained.
+	HardeningStandard HardeningLevel = "standard"
+
+	// HardeningStrict builds on HardeningStandard by making the whole
+	// file system read-only (use ReadWritePaths to allow writes),
+	// dropping all capabilities and filtering system calls down to
+	// the @system-service set.
+	HardeningStrict HardeningLevel = "strict"
+)
+
+/*
+Hardening configures the systemd sandboxing of the service.
+
+Level selects a preset, the remaining fields override individual
+directives of that preset. Fields left at their zero value keep the
+preset's value. For list fields, a nil slice keeps the preset while
+a non-nil empty slice writes an empty assignment, which resets the
+directive (for CapabilityBoundingSet this drops all capabilities).
+Each list entry is written on its own line.
+
+Hardening is only applied by the systemd backend.
+*/
+type Hardening struct {
+	// The preset to start from. Defaults to HardeningNone.
+	Level HardeningLevel
+
+	// NoNewPrivileges= prevents the service from gaining privileges
+	// via setuid binaries or file capabilities.
+	NoNewPrivileges *bool
+
+	// ProtectSystem= takes "yes", "full", "strict" or "no".
+	ProtectSystem string
+
+	// ProtectHome= takes "yes", "read-only", "tmpfs" or "no".
+	ProtectHome string
+
+	PrivateTmp            *bool
+	PrivateDevices        *bool
+	ProtectKernelTunables *bool
+	ProtectKernelModules  *bool
+	ProtectKernelLogs     *bool
+
+	// RestrictAddressFamilies= entries, e.g. "AF_UNIX AF_INET AF_INET6".
+	RestrictAddressFamilies []string
+
+	// SystemCallFilter= entries, e.g. "@system-service" or "~@privileged".
+	SystemCallFilter []string
+
+	// CapabilityBoundingSet= entries, e.g. "CAP_NET_BIND_SERVICE".
+	CapabilityBoundingSet []string
+
+	// AmbientCapabilities= entries, e.g. "CAP_NET_BIND_SERVICE".
+	AmbientCapabilities []string
+
+	// ReadWritePaths= entries which stay writable when the file
+	// system is otherwise protected.
+	ReadWritePaths []string
+}
+
+/*
+Bool returns a pointer to the given bool. It is a helper for setting
+the optional boolean fields of Hardening.
+*/
+func Bool(v bool) *bool {
+	return &v
+}
+
+/*
+validate returns an error if the hardening level is not known
+*/
+func (h Hardening) validate() error {
+	switch h.Level {
+	case "", HardeningNone, HardeningStandard, HardeningStrict:
+		return nil
+	}
+	return fmt.Errorf("unknown hardening level %q", h.Level)
+}
+
+/*
+directives resolves the preset and overrides into the ordered list
+of directives to add to the [Service] section.
+*/
+func (h Hardening) directives() []Directive {
+	p := hardeningPreset(h.Level)
+
+	if h.NoNewPrivileges != nil {
+		p.noNewPrivileges = boolValue(*h.NoNewPrivileges)
+	}
+	if h.ProtectSystem != "" {
+		p.protectSystem = h.ProtectSystem
+	}
+	if h.ProtectHome != "" {
+		p.protectHome = h.ProtectHome
+	}
+	if h.PrivateTmp != nil {
+		p.privateTmp = boolValue(*h.PrivateTmp)
+	}
+	if h.PrivateDevices != nil {
+		p.privateDevices = boolValue(*h.PrivateDevices)
+	}
+	if h.ProtectKernelTunables != nil {
+		p.protectKernelTunables = boolValue(*h.ProtectKernelTunables)
+	}
+	if h.ProtectKernelModules != nil {
+		p.protectKernelModules = boolValue(*h.ProtectKernelModules)
+	}
+	if h.ProtectKernelLogs != nil {
+		p.protectKernelLogs = boolValue(*h.ProtectKernelLogs)
+	}
+	if h.RestrictAddressFamilies != nil {
+		p.restrictAddressFamilies = h.RestrictAddressFamilies
+	}
+	if h.SystemCallFilter != nil {
+		p.systemCallFilter = h.SystemCallFilter
+	}
+	if h.CapabilityBoundingSet != nil {
+		p.capabilityBoundingSet = h.CapabilityBoundingSet
+	}
+	if h.AmbientCapabilities != nil {
+		p.ambientCapabilities = h.AmbientCapabilities
+	}
+	if h.ReadWritePaths != nil {
+		p.readWritePaths = h.ReadWritePaths
+	}
+
+	var d []Directive
+	add := func(key, value string) {
+		if value != "" {
+			d = append(d, Directive{Key: key, Value: value})
+		}
+	}
+	addList := func(key string, values []string) {
+		if values != nil && len(values) == 0 {
+			d = append(d, Directive{Key: key, Value: ""})
+		}
+		for _, v := range values {
+			d = append(d, Directive{Key: key, Value: v})
+		}
+	}
+
+	add("NoNewPrivileges", p.noNewPrivileges)
+	add("ProtectSystem", p.protectSystem)
+	add("ProtectHome", p.protectHome)
+	add("PrivateTmp", p.privateTmp)
+	add("PrivateDevices", p.privateDevices)
+	add("ProtectKernelTunables", p.protectKernelTunables)
+	add("ProtectKernelModules", p.protectKernelModules)
+	add("ProtectKernelLogs", p.protectKernelLogs)
+	for _, e := range p.extra {
+		add(e.Key, e.Value)
+	}
+	addList("RestrictAddressFamilies", p.restrictAddressFamilies)
+	addList("SystemCallFilter", p.systemCallFilter)
+	if len(p.systemCallFilter) > 0 {
+		add("SystemCallErrorNumber", p.systemCallErrorNumber)
+	}
+	addList("CapabilityBoundingSet", p.capabilityBoundingSet)
+	addList("AmbientCapabilities", p.ambientCapabilities)
+	addList("ReadWritePaths", p.readWritePaths)
+
+	return d
+}
+
+/*
+preset holds the resolved values of every hardening directive. Empty
+strings and nil slices are not written.
+*/
+type preset struct {
+	noNewPrivileges         string
+	protectSystem           string
+	protectHome             string
+	privateTmp              string
+	privateDevices          string
+	protectKernelTunables   string
+	protectKernelModules    string
+	protectKernelLogs       string
+	restrictAddressFamilies []string
+	systemCallFilter        []string
+	systemCallErrorNumber   string
+	capabilityBoundingSet   []string
+	ambientCapabilities     []string
+	readWritePaths          []string
+
+	// Directives which are part of a preset but cannot be
+	// overridden individually.
+	extra []Directive
+}
+
+func hardeningPreset(level HardeningLevel) preset {
+	switch level {
+	case HardeningStandard:
+		return preset{
+			noNewPrivileges:         "yes",
+			protectSystem:           "full",
+			protectHome:             "read-only",
+			privateTmp:              "yes",
+			privateDevices:          "yes",
+			protectKernelTunables:   "yes",
+			protectKernelModules:    "yes",
+			protectKernelLogs:       "yes",
+			restrictAddressFamilies: []string{"AF_UNIX AF_INET AF_INET6"},
+			extra: []Directive{
+				{Key: "ProtectControlGroups", Value: "yes"},
+				{Key: "RestrictSUIDSGID", Value: "yes"},
+				{Key: "RestrictRealtime", Value: "yes"},
+				{Key: "LockPersonality", Value: "yes"},
+				{Key: "SystemCallArchitectures", Value: "native"},
+			},
+		}
+	case HardeningStrict:
+		return preset{
+			noNewPrivileges:         "yes",
+			protectSystem:           "strict",
+			protectHome:             "yes",
+			privateTmp:              "yes",
+			privateDevices:          "yes",
+			protectKernelTunables:   "yes",
+			protectKernelModules:    "yes",
+			protectKernelLogs:       "yes",
+			restrictAddressFamilies: []string{"AF_UNIX AF_INET AF_INET6"},
+			systemCallFilter:        []string{"@system-service", "~@privileged @resources"},
+			systemCallErrorNumber:   "EPERM",
+			capabilityBoundingSet:   []string{},
+			extra: []Directive{
+				{Key: "ProtectControlGroups", Value: "yes"},
+				{Key: "ProtectClock", Value: "yes"},
+				{Key: "ProtectHostname", Value: "yes"},
+				{Key: "ProtectProc", Value: "invisible"},
+				{Key: "ProcSubset", Value: "pid"},
+				{Key: "RestrictNamespaces", Value: "yes"},
+				{Key: "RestrictSUIDSGID", Value: "yes"},
+				{Key: "RestrictRealtime", Value: "yes"},
+				{Key: "LockPersonality", Value: "yes"},
+				{Key: "MemoryDenyWriteExecute", Value: "yes"},
+				{Key: "RemoveIPC", Value: "yes"},
+				{Key: "UMask", Value: "0077"},
+				{Key: "SystemCallArchitectures", Value: "native"},
+			},
+		}
+	}
+
+	return preset{}
+}
+
+func boolValue(v bool) string {
+	if v {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,90 @@
+package systemservice
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHardeningDirectives(t *testing.T) {
+	assert := assert.New(t)
+	tables := []struct {
+		name      string
+		hardening Hardening
+		contains  []Directive
+		excludes  []string
+	}{
+		{
+			name:      "none writes nothing",
+			hardening: Hardening{},
+		},
+		{
+			name:      "standard preset",
+			hardening: Hardening{Level: HardeningStandard},
+			contains: []Directive{
+				{Key: "NoNewPrivileges", Value: "yes"},
+				{Key: "ProtectSystem", Value: "full"},
+				{Key: "PrivateTmp", Value: "yes"},
+				{Key: "RestrictAddressFamilies", Value: "AF_UNIX AF_INET AF_INET6"},
+			},
+			excludes: []string{"SystemCallFilter", "CapabilityBoundingSet"},
+		},
+		{
+			name:      "strict drops all capabilities",
+			hardening: Hardening{Level: HardeningStrict},
+			contains: []Directive{
+				{Key: "ProtectSystem", Value: "strict"},
+				{Key: "SystemCallFilter", Value: "@system-service"},
+				{Key: "SystemCallFilter", Value: "~@privileged @resources"},
+				{Key: "CapabilityBoundingSet", Value: ""},
+			},
+		},
+		{
+			name: "overrides replace preset values",
+			hardening: Hardening{
+				Level:                 HardeningStrict,
+				ProtectHome:           "read-only",
+				PrivateDevices:        Bool(false),
+				CapabilityBoundingSet: []string{"CAP_NET_BIND_SERVICE"},
+				AmbientCapabilities:   []string{"CAP_NET_BIND_SERVICE"},
+				ReadWritePaths:        []string{"/var/lib/app"},
+			},
+			contains: []Directive{
+				{Key: "ProtectHome", Value: "read-only"},
+				{Key: "PrivateDevices", Value: "no"},
+				{Key: "CapabilityBoundingSet", Value: "CAP_NET_BIND_SERVICE"},
+				{Key: "AmbientCapabilities", Value: "CAP_NET_BIND_SERVICE"},
+				{Key: "ReadWritePaths", Value: "/var/lib/app"},
+			},
+		},
+		{
+			name:      "overrides apply without a preset",
+			hardening: Hardening{NoNewPrivileges: Bool(true)},
+			contains:  []Directive{{Key: "NoNewPrivileges", Value: "yes"}},
+			excludes:  []string{"ProtectSystem"},
+		},
+	}
+
+	for _, table := range tables {
+		d := table.hardening.directives()
+		if table.contains == nil && table.excludes == nil {
+			assert.Empty(d, table.name)
+		}
+		for _, c := range table.contains {
+			assert.Contains(d, c, table.name)
+		}
+		for _, e := range table.excludes {
+			for _, a := range d {
+				assert.NotEqual(e, a.Key, table.name)
+			}
+		}
+	}
+}
+
+func TestHardeningValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(Hardening{}.validate())
+	assert.NoError(Hardening{Level: HardeningStrict}.validate())
+	assert.Error(Hardening{Level: "paranoid"}.validate())
+}
@@ -0,0 +1,651 @@
+package systemservice
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danawoodman/systemservice/launchd"
+	"github.com/danawoodman/systemservice/unit"
+)
+
+/*
+Unmapped is a directive of an imported unit or a key of an imported
+plist which has no equivalent in ServiceCommand, or whose value could
+not be read. Section is empty for plist keys.
+*/
+type Unmapped struct {
+	Section string
+	Key     string
+	Value   string
+	Reason  string
+}
+
+func (u Unmapped) String() string {
+	if u.Section == "" {
+		return fmt.Sprintf("%s=%s: %s", u.Key, u.Value, u.Reason)
+	}
+	return fmt.Sprintf("[%s] %s=%s: %s", u.Section, u.Key, u.Value, u.Reason)
+}
+
+/*
+CommandFromUnit maps a systemd service unit, and optionally its
+companion .socket, .timer and .path units, to a ServiceCommand. The
+label is taken from the name of the service unit. Directives which
+cannot be expressed by the command, including those with values
+which do not parse, are returned as unmapped. Directives the
+generator writes itself, such as Restart=on-failure, are not
+reported.
+
+Arguments and environment values are literal, "%%" and "$$" are
+decoded. Only "%i" is kept, it is replaced by the instance name on
+InstallInstance. Directives using other specifiers or, in ExecStart,
+environment variables are returned as unmapped.
+*/
+func CommandFromUnit(service *unit.File, companions ...*unit.File) (ServiceCommand, []Unmapped, error) {
+	var cmd ServiceCommand
+	m := &unitMapper{cmd: &cmd}
+
+	label := strings.TrimSuffix(service.Name, ".service")
+	label = strings.TrimSuffix(label, "@")
+	cmd.Label = label
+	cmd.Name = label
+
+	if service.Section("Service") == nil {
+		return cmd, nil, fmt.Errorf("unit %q has no [Service] section", service.Name)
+	}
+
+	for _, s := range service.Sections {
+		switch s.Name {
+		case "Unit":
+			m.mapUnitSection(s, true)
+		case "Service":
+			m.mapServiceSection(s)
+		case "Install":
+			m.mapInstallSection(s, "multi-user.target", "default.target")
+		default:
+			m.unmappedSection(s)
+		}
+	}
+
+	if cmd.Program == "" {
+		return cmd, m.unmapped, fmt.Errorf("unit %q has no ExecStart", service.Name)
+	}
+
+	for _, f := range companions {
+		for _, s := range f.Sections {
+			switch s.Name {
+			case "Unit":
+				m.mapUnitSection(s, false)
+			case "Install":
+				m.mapInstallSection(s, "sockets.target", "timers.target", "paths.target")
+			case "Socket":
+				m.mapSocketSection(s)
+			case "Timer":
+				m.mapTimerSection(s)
+			case "Path":
+				m.mapPathSection(s)
+			default:
+				m.unmappedSection(s)
+			}
+		}
+	}
+
+	m.finish()
+
+	return cmd, m.unmapped, nil
+}
+
+/*
+unitMapper collects the directives of a unit into a command
+*/
+type unitMapper struct {
+	cmd      *ServiceCommand
+	unmapped []Unmapped
+
+	restart      string
+	notifyAccess string
+}
+
+func (m *unitMapper) skip(section string, d Directive, reason string) {
+	m.unmapped = append(m.unmapped, Unmapped{section, d.Key, d.Value, reason})
+}
+
+func (m *unitMapper) unmappedSection(s Section) {
+	for _, d := range s.Directives {
+		m.skip(s.Name, d, "section is not supported")
+	}
+}
+
+func (m *unitMapper) mapUnitSection(s Section, service bool) {
+	deps := &m.cmd.Dependencies
+
+	for _, d := range s.Directives {
+		units := strings.Fields(d.Value)
+
+		switch {
+		case d.Key == "Description" && service:
+			m.cmd.Description = d.Value
+		case d.Key == "Description":
+			// Companion units describe themselves after the service
+		case d.Key == "Documentation" && service:
+			m.cmd.Documentation = d.Value
+		case d.Key == "After" && service:
+			for _, u := range units {
+				switch u {
+				case "network.target":
+				case "network-online.target":
+					deps.NetworkOnline = true
+				default:
+					deps.After = append(deps.After, u)
+				}
+			}
+		case d.Key == "Wants" && service:
+			for _, u := range units {
+				if u != "network-online.target" {
+					deps.Wants = append(deps.Wants, u)
+				}
+			}
+		case d.Key == "Before" && service:
+			deps.Before = append(deps.Before, units...)
+		case d.Key == "Requires" && service:
+			deps.Requires = append(deps.Requires, units...)
+		case d.Key == "BindsTo" && service:
+			deps.BindsTo = append(deps.BindsTo, units...)
+		case d.Key == "PartOf" && service:
+			deps.PartOf = append(deps.PartOf, units...)
+		case d.Key == "Conflicts" && service:
+			deps.Conflicts = append(deps.Conflicts, units...)
+		default:
+			m.skip(s.Name, d, "no equivalent option")
+		}
+	}
+}
+
+func (m *unitMapper) mapInstallSection(s Section, targets ...string) {
+	for _, d := range s.Directives {
+		if d.Key == "WantedBy" && contains(targets, d.Value) {
+			continue
+		}
+		m.skip(s.Name, d, "services are always enabled for the default target")
+	}
+}
+
+func (m *unitMapper) mapServiceSection(s Section) {
+	cmd := m.cmd
+	hardening := &cmd.Hardening
+
+	for _, d := range s.Directives {
+		var err error
+
+		switch d.Key {
+		case "ExecStart":
+			if d.Value == "" {
+				cmd.Program, cmd.Args = "", nil
+				continue
+			}
+			if cmd.Program != "" {
+				m.skip(s.Name, d, "only one ExecStart is supported")
+				continue
+			}
+			if strings.ContainsAny(d.Value[:1], "@-:+!") {
+				m.skip(s.Name, d, "command prefixes are not supported")
+				continue
+			}
+			if reason := expansions(d.Value, true); reason != "" {
+				m.skip(s.Name, d, reason)
+				continue
+			}
+			var words []string
+			if words, err = unit.SplitCommand(d.Value); err == nil && len(words) > 0 {
+				cmd.Program = words[0]
+				cmd.Args = words[1:]
+			}
+		case "Type":
+			switch t := ServiceType(d.Value); t {
+			case ServiceTypeSimple, ServiceTypeExec, ServiceTypeNotify, ServiceTypeNotifyReload,
+				ServiceTypeForking, ServiceTypeOneshot, ServiceTypeIdle:
+				cmd.Type = t
+			default:
+				err = fmt.Errorf("unknown service type %q", d.Value)
+			}
+		case "PIDFile":
+			cmd.PIDFile = d.Value
+		case "RemainAfterExit":
+			cmd.RemainAfterExit, err = parseBool(d.Value)
+		case "WatchdogSec":
+			cmd.WatchdogSec, err = parseTimespan(d.Value)
+		case "TimeoutStopSec":
+			cmd.StopTimeout, err = parseTimespan(d.Value)
+		case "Environment":
+			if reason := expansions(d.Value, false); reason != "" {
+				m.skip(s.Name, d, reason)
+				continue
+			}
+			var words []string
+			if words, err = unit.SplitWords(unit.UnescapeSpecifiers(d.Value)); err == nil {
+				for _, w := range words {
+					parts := strings.SplitN(w, "=", 2)
+					if len(parts) != 2 {
+						err = fmt.Errorf("invalid assignment %q", w)
+						break
+					}
+					if cmd.Environment == nil {
+						cmd.Environment = map[string]string{}
+					}
+					cmd.Environment[parts[0]] = parts[1]
+				}
+			}
+		case "User":
+			cmd.User = d.Value
+		case "Group":
+			cmd.Group = d.Value
+		case "DynamicUser":
+			cmd.DynamicUser, err = parseBool(d.Value)
+		case "Restart":
+			m.restart = d.Value
+		case "NotifyAccess":
+			m.notifyAccess = d.Value
+		case "StandardOutput":
+			if d.Value != "null" {
+				m.skip(s.Name, d, "output always goes to the default location")
+			}
+		case "StateDirectory", "CacheDirectory", "LogsDirectory", "RuntimeDirectory", "ConfigurationDirectory":
+			dir := m.directory(strings.TrimSuffix(d.Key, "Directory"))
+			dir.Paths = append(dir.Paths, strings.Fields(d.Value)...)
+		case "StateDirectoryMode", "CacheDirectoryMode", "LogsDirectoryMode", "RuntimeDirectoryMode", "ConfigurationDirectoryMode":
+			var mode uint64
+			if mode, err = strconv.ParseUint(d.Value, 8, 32); err == nil {
+				m.directory(strings.TrimSuffix(d.Key, "DirectoryMode")).Mode = os.FileMode(mode)
+			}
+		case "NoNewPrivileges":
+			hardening.NoNewPrivileges, err = parseBoolPointer(d.Value)
+		case "PrivateTmp":
+			hardening.PrivateTmp, err = parseBoolPointer(d.Value)
+		case "PrivateDevices":
+			hardening.PrivateDevices, err = parseBoolPointer(d.Value)
+		case "ProtectKernelTunables":
+			hardening.ProtectKernelTunables, err = parseBoolPointer(d.Value)
+		case "ProtectKernelModules":
+			hardening.ProtectKernelModules, err = parseBoolPointer(d.Value)
+		case "ProtectKernelLogs":
+			hardening.ProtectKernelLogs, err = parseBoolPointer(d.Value)
+		case "ProtectSystem":
+			hardening.ProtectSystem = d.Value
+		case "ProtectHome":
+			hardening.ProtectHome = d.Value
+		case "RestrictAddressFamilies":
+			hardening.RestrictAddressFamilies = appendListValue(hardening.RestrictAddressFamilies, d.Value)
+		case "SystemCallFilter":
+			hardening.SystemCallFilter = appendListValue(hardening.SystemCallFilter, d.Value)
+		case "CapabilityBoundingSet":
+			hardening.CapabilityBoundingSet = appendListValue(hardening.CapabilityBoundingSet, d.Value)
+		case "AmbientCapabilities":
+			hardening.AmbientCapabilities = appendListValue(hardening.AmbientCapabilities, d.Value)
+		case "ReadWritePaths":
+			hardening.ReadWritePaths = appendListValue(hardening.ReadWritePaths, d.Value)
+		default:
+			m.skip(s.Name, d, "no equivalent option")
+			continue
+		}
+
+		if err != nil {
+			m.skip(s.Name, d, err.Error())
+		}
+	}
+}
+
+/*
+directory returns the managed directory of the given kind
+*/
+func (m *unitMapper) directory(kind string) *ManagedDirectory {
+	dirs := &m.cmd.Directories
+	switch kind {
+	case "State":
+		return &dirs.State
+	case "Cache":
+		return &dirs.Cache
+	case "Logs":
+		return &dirs.Logs
+	case "Runtime":
+		return &dirs.Runtime
+	}
+	return &dirs.Configuration
+}
+
+func (m *unitMapper) mapSocketSection(s Section) {
+	sockets := &m.cmd.Sockets
+
+	for _, d := range s.Directives {
+		var err error
+
+		switch d.Key {
+		case "ListenStream":
+			sockets.ListenStream = append(sockets.ListenStream, d.Value)
+		case "ListenDatagram":
+			sockets.ListenDatagram = append(sockets.ListenDatagram, d.Value)
+		case "ListenSequentialPacket":
+			sockets.ListenSequentialPacket = append(sockets.ListenSequentialPacket, d.Value)
+		case "Accept":
+			sockets.Accept, err = parseBool(d.Value)
+		case "FileDescriptorName":
+			sockets.Name = d.Value
+		default:
+			m.skip(s.Name, d, "no equivalent option")
+			continue
+		}
+
+		if err != nil {
+			m.skip(s.Name, d, err.Error())
+		}
+	}
+}
+
+func (m *unitMapper) mapTimerSection(s Section) {
+	schedule := &m.cmd.Schedule
+
+	for _, d := range s.Directives {
+		var err error
+
+		switch d.Key {
+		case "OnCalendar":
+			if _, err = ParseCalendar(d.Value); err == nil {
+				schedule.OnCalendar = append(schedule.OnCalendar, d.Value)
+			}
+		case "OnBootSec":
+			schedule.OnBootSec, err = parseTimespan(d.Value)
+		case "OnUnitActiveSec":
+			schedule.OnUnitActiveSec, err = parseTimespan(d.Value)
+		case "RandomizedDelaySec":
+			schedule.RandomizedDelaySec, err = parseTimespan(d.Value)
+		case "Persistent":
+			schedule.Persistent, err = parseBool(d.Value)
+		default:
+			m.skip(s.Name, d, "no equivalent option")
+			continue
+		}
+
+		if err != nil {
+			m.skip(s.Name, d, err.Error())
+		}
+	}
+}
+
+func (m *unitMapper) mapPathSection(s Section) {
+	for _, d := range s.Directives {
+		switch t := TriggerType(d.Key); t {
+		case TriggerPathExists, TriggerPathChanged, TriggerPathModified, TriggerDirectoryNotEmpty:
+			m.cmd.Triggers = append(m.cmd.Triggers, Trigger{Type: t, Path: d.Value})
+		default:
+			m.skip(s.Name, d, "no equivalent option")
+		}
+	}
+}
+
+/*
+finish reports the directives whose value depends on other
+directives, once all of them are known
+*/
+func (m *unitMapper) finish() {
+	restart := "on-failure"
+	if m.cmd.serviceType() == ServiceTypeOneshot {
+		restart = "no"
+	}
+	if m.restart != "" && m.restart != restart {
+		m.skip("Service", Directive{Key: "Restart", Value: m.restart}, fmt.Sprintf("the service type implies Restart=%s", restart))
+	}
+
+	if m.notifyAccess != "" && !(m.notifyAccess == "main" && m.cmd.WatchdogSec > 0) {
+		m.skip("Service", Directive{Key: "NotifyAccess", Value: m.notifyAccess}, "only set along with WatchdogSec")
+	}
+}
+
+/*
+CommandFromPlist maps a launchd job to a ServiceCommand. Keys which
+cannot be expressed by the command are returned as unmapped. Keys
+the generator writes itself, such as the default log paths, are not
+reported.
+*/
+func CommandFromPlist(job *launchd.Job) (ServiceCommand, []Unmapped, error) {
+	var cmd ServiceCommand
+	var unmapped []Unmapped
+
+	skip := func(key string, reason string) {
+		unmapped = append(unmapped, Unmapped{Key: key, Value: fmt.Sprint(job.Raw[key]), Reason: reason})
+	}
+
+	cmd.Label = job.Label
+	cmd.Name = job.Label
+
+	// The generator logs to <name>.stdout.log and <name>.stderr.log
+	if base := filepath.Base(job.StandardOutPath); strings.HasSuffix(base, ".stdout.log") {
+		cmd.Name = strings.TrimSuffix(base, ".stdout.log")
+	}
+
+	switch {
+	case job.Program != "":
+		cmd.Program = job.Program
+		if len(job.ProgramArguments) > 0 {
+			cmd.Args = job.ProgramArguments[1:]
+		}
+	case len(job.ProgramArguments) > 0:
+		cmd.Program = job.ProgramArguments[0]
+		cmd.Args = job.ProgramArguments[1:]
+	default:
+		return cmd, nil, fmt.Errorf("job %q has no Program or ProgramArguments", job.Label)
+	}
+
+	cmd.Environment = job.EnvironmentVariables
+	cmd.User = job.UserName
+	cmd.Group = job.GroupName
+	cmd.StopTimeout = time.Duration(job.ExitTimeOut) * time.Second
+
+	for _, interval := range job.StartCalendarInterval {
+		calendar, err := calendarFromInterval(interval)
+		if err != nil {
+			unmapped = append(unmapped, Unmapped{Key: "StartCalendarInterval", Value: fmt.Sprint(interval), Reason: err.Error()})
+			continue
+		}
+		cmd.Schedule.OnCalendar = append(cmd.Schedule.OnCalendar, calendar)
+	}
+	cmd.Schedule.OnUnitActiveSec = time.Duration(job.StartInterval) * time.Second
+
+	for _, path := range job.WatchPaths {
+		cmd.Triggers = append(cmd.Triggers, Trigger{Type: TriggerPathChanged, Path: path})
+	}
+	for _, path := range job.QueueDirectories {
+		cmd.Triggers = append(cmd.Triggers, Trigger{Type: TriggerDirectoryNotEmpty, Path: path})
+	}
+
+	names := make([]string, 0, len(job.Sockets))
+	for name := range job.Sockets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if len(names) > 1 {
+		skip("Sockets", "only one named group of sockets is supported")
+	} else if len(names) == 1 {
+		if names[0] != "Listeners" {
+			cmd.Sockets.Name = names[0]
+		}
+		for _, listener := range job.Sockets[names[0]] {
+			if err := addListener(&cmd.Sockets, listener); err != nil {
+				skip("Sockets", err.Error())
+			}
+		}
+		cmd.Sockets.Accept = job.InetdCompatibility
+	}
+
+	// Jobs which are loaded but neither kept alive nor activated run
+	// to completion once
+	if !job.KeepAlive && job.RunAtLoad && !cmd.activated() {
+		cmd.Type = ServiceTypeOneshot
+	}
+
+	for _, key := range job.Keys() {
+		switch key {
+		case "Label", "Program", "ProgramArguments", "EnvironmentVariables",
+			"UserName", "GroupName", "ExitTimeOut", "StartCalendarInterval",
+			"StartInterval", "WatchPaths", "QueueDirectories", "Sockets",
+			"inetdCompatibility", "RunAtLoad":
+		case "KeepAlive":
+			if job.KeepAliveConditions != nil {
+				skip(key, "keep alive conditions are not supported")
+			}
+		case "StandardOutPath", "StandardErrorPath":
+			if dir := filepath.Base(filepath.Dir(job.Raw[key].(string))); dir != cmd.Name {
+				skip(key, "output always goes to the default location")
+			}
+		default:
+			skip(key, "no equivalent option")
+		}
+	}
+
+	return cmd, unmapped, nil
+}
+
+/*
+addListener adds a launchd socket dictionary to the sockets
+*/
+func addListener(sockets *Sockets, listener launchd.Dict) error {
+	str := func(key string) string {
+		s, _ := listener[key].(string)
+		return s
+	}
+
+	for key := range listener {
+		switch key {
+		case "SockType", "SockPathName", "SockNodeName", "SockServiceName":
+		default:
+			return fmt.Errorf("socket option %s is not supported", key)
+		}
+	}
+
+	address := str("SockServiceName")
+	switch {
+	case str("SockPathName") != "":
+		address = str("SockPathName")
+	case str("SockNodeName") != "":
+		address = net.JoinHostPort(str("SockNodeName"), address)
+	}
+
+	switch str("SockType") {
+	case "", "stream":
+		sockets.ListenStream = append(sockets.ListenStream, address)
+	case "dgram":
+		sockets.ListenDatagram = append(sockets.ListenDatagram, address)
+	case "seqpacket":
+		sockets.ListenSequentialPacket = append(sockets.ListenSequentialPacket, address)
+	default:
+		return fmt.Errorf("unknown socket type %q", str("SockType"))
+	}
+
+	return nil
+}
+
+/*
+expansions returns why a value cannot be imported literally: systemd
+expands its specifiers other than "%i" or, in Exec directives, its
+environment variables. Empty if it can.
+*/
+func expansions(value string, exec bool) string {
+	for _, c := range unit.Specifiers(value) {
+		if c != 'i' {
+			return fmt.Sprintf("specifier %%%c cannot be expressed literally", c)
+		}
+	}
+	if exec && unit.HasVariables(value) {
+		return "environment variables cannot be expressed literally"
+	}
+	return ""
+}
+
+/*
+calendarIntervalRanges are the valid values of the keys of a
+StartCalendarInterval dictionary. Weekday 7 is Sunday, like 0.
+*/
+var calendarIntervalRanges = []struct {
+	key      string
+	min, max int
+}{
+	{"Month", 1, 12},
+	{"Day", 1, 31},
+	{"Weekday", 0, 7},
+	{"Hour", 0, 23},
+	{"Minute", 0, 59},
+}
+
+/*
+calendarFromInterval converts a StartCalendarInterval dictionary to a
+calendar expression. Missing keys match every value, values out of
+range return an error.
+*/
+func calendarFromInterval(interval map[string]int) (string, error) {
+	for _, r := range calendarIntervalRanges {
+		if v, ok := interval[r.key]; ok && (v < r.min || v > r.max) {
+			return "", fmt.Errorf("%s %d is not between %d and %d", r.key, v, r.min, r.max)
+		}
+	}
+
+	field := func(key string) string {
+		if v, ok := interval[key]; ok {
+			return fmt.Sprintf("%02d", v)
+		}
+		return "*"
+	}
+
+	expr := fmt.Sprintf("*-%s-%s %s:%s:00", field("Month"), field("Day"), field("Hour"), field("Minute"))
+
+	if weekday, ok := interval["Weekday"]; ok {
+		expr = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}[weekday%7] + " " + expr
+	}
+
+	return expr, nil
+}
+
+/*
+parseBool parses a systemd boolean
+*/
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "1", "yes", "y", "true", "t", "on":
+		return true, nil
+	case "0", "no", "n", "false", "f", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q", s)
+}
+
+func parseBoolPointer(s string) (*bool, error) {
+	v, err := parseBool(s)
+	if err != nil {
+		return nil, err
+	}
+	return Bool(v), nil
+}
+
+/*
+appendListValue adds the value of a list directive, an empty value
+resets the list
+*/
+func appendListValue(list []string, value string) []string {
+	if value == "" {
+		return []string{}
+	}
+	return append(list, value)
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package systemservice
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danawoodman/systemservice/launchd"
+	"github.com/danawoodman/systemservice/unit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandFromUnit(t *testing.T) {
+	assert := assert.New(t)
+
+	service, err := unit.Parse(strings.NewReader(`[Unit]
+Description=Queue worker
+After=network.target network-online.target postgresql.service
+Wants=network-online.target
+OnFailure=notify@%n.service
+
+[Service]
+Type=notify
+ExecStart=/usr/local/bin/worker --queue "high priority"
+Environment="LEVEL=debug" NAME=worker
+Restart=always
+TimeoutStopSec=1min 30s
+User=worker
+StateDirectory=worker
+StateDirectoryMode=0750
+ProtectSystem=strict
+NoNewPrivileges=yes
+LimitNOFILE=65536
+RemainAfterExit=maybe
+
+[Install]
+WantedBy=multi-user.target
+`))
+	assert.NoError(err)
+	service.Name = "worker.service"
+
+	timer, err := unit.Parse(strings.NewReader(`[Unit]
+Description=Queue worker (timer)
+
+[Timer]
+OnCalendar=daily
+Persistent=true
+AccuracySec=1h
+
+[Install]
+WantedBy=timers.target
+`))
+	assert.NoError(err)
+
+	cmd, unmapped, err := CommandFromUnit(service, timer)
+	assert.NoError(err)
+
+	assert.Equal("worker", cmd.Label)
+	assert.Equal("Queue worker", cmd.Description)
+	assert.Equal(Dependencies{After: []string{"postgresql.service"}, NetworkOnline: true}, cmd.Dependencies)
+	assert.Equal(ServiceTypeNotify, cmd.Type)
+	assert.Equal("/usr/local/bin/worker", cmd.Program)
+	assert.Equal([]string{"--queue", "high priority"}, cmd.Args)
+	assert.Equal(map[string]string{"LEVEL": "debug", "NAME": "worker"}, cmd.Environment)
+	assert.Equal(90*time.Second, cmd.StopTimeout)
+	assert.Equal("worker", cmd.User)
+	assert.Equal(ManagedDirectory{Paths: []string{"worker"}, Mode: 0750}, cmd.Directories.State)
+	assert.Equal("strict", cmd.Hardening.ProtectSystem)
+	assert.Equal(Bool(true), cmd.Hardening.NoNewPrivileges)
+	assert.Equal(Schedule{OnCalendar: []string{"daily"}, Persistent: true}, cmd.Schedule)
+
+	assert.Equal([]Unmapped{
+		{"Unit", "OnFailure", "notify@%n.service", "no equivalent option"},
+		{"Service", "LimitNOFILE", "65536", "no equivalent option"},
+		{"Service", "RemainAfterExit", "maybe", `invalid boolean "maybe"`},
+		{"Timer", "AccuracySec", "1h", "no equivalent option"},
+		{"Service", "Restart", "always", "the service type implies Restart=on-failure"},
+	}, unmapped)
+
+	_, _, err = CommandFromUnit(&unit.File{Name: "empty.service"})
+	assert.Error(err)
+}
+
+func TestCommandFromUnitExpansions(t *testing.T) {
+	assert := assert.New(t)
+
+	service, err := unit.Parse(strings.NewReader(`[Service]
+ExecStart=/usr/bin/app --instance=%i --fmt=100%%d $$HOME
+ExecStart=
+ExecStart=/usr/bin/app $OPTIONS
+Environment="DATA=%h/data" "RATE=50%%"
+`))
+	assert.NoError(err)
+	service.Name = "app@.service"
+
+	_, unmapped, err := CommandFromUnit(service)
+	assert.Error(err, "the only ExecStart left uses a variable")
+	if assert.Len(unmapped, 2) {
+		assert.Equal("environment variables cannot be expressed literally", unmapped[0].Reason)
+		assert.Equal("specifier %h cannot be expressed literally", unmapped[1].Reason)
+	}
+
+	service, err = unit.Parse(strings.NewReader(`[Service]
+ExecStart=/usr/bin/app --instance=%i --fmt=100%%d $$HOME
+Environment="RATE=50%%" NAME=%i
+`))
+	assert.NoError(err)
+	service.Name = "app@.service"
+
+	cmd, unmapped, err := CommandFromUnit(service)
+	assert.NoError(err)
+	assert.Empty(unmapped)
+	assert.Equal([]string{"--instance=%i", "--fmt=100%d", "$HOME"}, cmd.Args)
+	assert.Equal(map[string]string{"RATE": "50%", "NAME": "%i"}, cmd.Environment)
+}
+
+func TestCommandFromPlist(t *testing.T) {
+	assert := assert.New(t)
+
+	job, err := launchd.ParseJobFile("launchd/testdata/job.binary.plist")
+	assert.NoError(err)
+
+	cmd, unmapped, err := CommandFromPlist(job)
+	assert.NoError(err)
+
+	assert.Equal("com.example.worker", cmd.Label)
+	assert.Equal("worker", cmd.Name)
+	assert.Equal("/usr/local/bin/worker", cmd.Program)
+	assert.Equal([]string{"--queue", "emails"}, cmd.Args)
+	assert.Equal(30*time.Second, cmd.StopTimeout)
+	assert.Equal([]string{"*-*-* 03:00:00", "Mon *-*-* 09:30:00"}, cmd.Schedule.OnCalendar)
+	assert.Equal([]Trigger{{Type: TriggerPathChanged, Path: "/etc/worker.conf"}}, cmd.Triggers)
+	assert.Equal(Sockets{ListenStream: []string{"8080"}}, cmd.Sockets)
+	assert.NoError(cmd.validate())
+
+	var keys []string
+	for _, u := range unmapped {
+		keys = append(keys, u.Key)
+	}
+	assert.Equal([]string{"Big", "Blob", "KeepAlive", "Nice", "Ratio", "Updated"}, keys)
+}
+
+func TestCommandFromPlistCalendarRange(t *testing.T) {
+	assert := assert.New(t)
+
+	job := &launchd.Job{
+		Label:            "com.example.report",
+		ProgramArguments: []string{"/usr/local/bin/report"},
+		StartCalendarInterval: []map[string]int{
+			{"Weekday": -1},
+			{"Hour": 24},
+			{"Weekday": 7, "Hour": 6},
+		},
+	}
+
+	cmd, unmapped, err := CommandFromPlist(job)
+	assert.NoError(err)
+	assert.Equal([]string{"Sun *-*-* 06:*:00"}, cmd.Schedule.OnCalendar)
+
+	if assert.Len(unmapped, 2) {
+		assert.Equal("StartCalendarInterval", unmapped[0].Key)
+		assert.Equal("Weekday -1 is not between 0 and 7", unmapped[0].Reason)
+		assert.Equal("Hour 24 is not between 0 and 23", unmapped[1].Reason)
+	}
+}
+
+func TestParseTimespan(t *testing.T) {
+	assert := assert.New(t)
+	tables := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"30", 30 * time.Second},
+		{"30s", 30 * time.Second},
+		{"1min 30s", 90 * time.Second},
+		{"1min30s", 90 * time.Second},
+		{"2 h", 2 * time.Hour},
+		{"500ms", 500 * time.Millisecond},
+		{"1.5s", 1500 * time.Millisecond},
+		{"1d", 24 * time.Hour},
+	}
+
+	for _, table := range tables {
+		d, err := parseTimespan(table.value)
+		assert.NoError(err, table.value)
+		assert.Equal(table.expected, d, table.value)
+		assert.Equal(table.expected, mustParseTimespan(t, timespan(d)), table.value)
+	}
+
+	for _, value := range []string{"", "s", "5 fortnights", "-5s"} {
+		_, err := parseTimespan(value)
+		assert.Error(err, value)
+	}
+}
+
+func mustParseTimespan(t *testing.T, value string) time.Duration {
+	d, err := parseTimespan(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
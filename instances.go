@@ -0,0 +1,125 @@
+package systemservice
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/danawoodman/systemservice/unit"
+)
+
+/*
+validateInstance checks that the instance name only uses characters
+allowed in unit names
+*/
+func validateInstance(instance string) error {
+	if instance == "" {
+		return fmt.Errorf("instance name must not be empty")
+	}
+
+	for _, r := range instance {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune(":-_.\\", r):
+		default:
+			return fmt.Errorf("invalid character %q in instance name %q", r, instance)
+		}
+	}
+
+	return nil
+}
+
+/*
+validateInstanced checks that the command can be run as a template.
+Only the service itself is instanced, not its sockets, timers or
+triggers.
+*/
+func (c *ServiceCommand) validateInstanced() error {
+	if c.activated() {
+		return fmt.Errorf("instances cannot be used together with Sockets, Schedule or Triggers")
+	}
+	return nil
+}
+
+/*
+forInstance returns the command of a single instance, with "%i" in the
+arguments and environment replaced by the instance name and the label
+and name suffixed with "@<instance>". It is used by service managers
+which have no template support of their own.
+*/
+func (c ServiceCommand) forInstance(instance string) ServiceCommand {
+	expand := func(s string) string {
+		return strings.Replace(s, "%i", instance, -1)
+	}
+
+	c.Label = c.Label + "@" + instance
+	c.Name = c.Name + "@" + instance
+
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = expand(arg)
+	}
+	c.Args = args
+
+	if c.Environment != nil {
+		env := make(map[string]string, len(c.Environment))
+		for k, v := range c.Environment {
+			env[k] = expand(v)
+		}
+		c.Environment = env
+	}
+
+	return c
+}
+
+/*
+environment returns the environment as sorted "KEY=value" pairs
+*/
+func (c *ServiceCommand) environment() []string {
+	var env []string
+	for k, v := range c.Environment {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	return env
+}
+
+/*
+environmentDirectives returns the Environment= directives for systemd,
+quoted so values may contain spaces. Percent signs are escaped, except
+for "%i".
+*/
+func (c *ServiceCommand) environmentDirectives() []Directive {
+	var out []Directive
+	for _, e := range c.environment() {
+		out = append(out, Directive{Key: "Environment", Value: unit.Quote(unit.EscapeSpecifiers(e, "i"))})
+	}
+	return out
+}
+
+/*
+instancesFromNames returns the sorted, unique instance names of the
+given units or labels of the form "<label>@<instance>[.service]"
+*/
+func instancesFromNames(label string, names []string) []string {
+	seen := map[string]bool{}
+	instances := []string{}
+
+	for _, name := range names {
+		name = strings.TrimSuffix(name, ".service")
+		if !strings.HasPrefix(name, label+"@") {
+			continue
+		}
+
+		instance := strings.TrimPrefix(name, label+"@")
+		if instance == "" || seen[instance] {
+			continue
+		}
+
+		seen[instance] = true
+		instances = append(instances, instance)
+	}
+
+	sort.Strings(instances)
+	return instances
+}
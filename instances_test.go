@@ -0,0 +1,78 @@
+package systemservice
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateInstance(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(validateInstance("8080"))
+	assert.NoError(validateInstance("eth0:1.vlan-2_a"))
+	assert.Error(validateInstance(""))
+	assert.Error(validateInstance("a b"))
+	assert.Error(validateInstance("a/b"))
+	assert.Error(validateInstance("a@b"))
+}
+
+func TestValidateInstanced(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError((&ServiceCommand{}).validateInstanced())
+	assert.Error((&ServiceCommand{Sockets: Sockets{ListenStream: []string{"8080"}}}).validateInstanced())
+	assert.Error((&ServiceCommand{Schedule: Schedule{OnCalendar: []string{"daily"}}}).validateInstanced())
+}
+
+func TestForInstance(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := ServiceCommand{
+		Label:       "com.example.worker",
+		Name:        "worker",
+		Args:        []string{"--queue", "%i"},
+		Environment: map[string]string{"QUEUE": "%i", "LEVEL": "debug"},
+	}
+
+	inst := cmd.forInstance("emails")
+
+	assert.Equal("com.example.worker@emails", inst.Label)
+	assert.Equal("worker@emails", inst.Name)
+	assert.Equal([]string{"--queue", "emails"}, inst.Args)
+	assert.Equal(map[string]string{"QUEUE": "emails", "LEVEL": "debug"}, inst.Environment)
+
+	// The template command is left untouched
+	assert.Equal([]string{"--queue", "%i"}, cmd.Args)
+	assert.Equal("%i", cmd.Environment["QUEUE"])
+}
+
+func TestEnvironmentDirectives(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := ServiceCommand{Environment: map[string]string{
+		"B": `say "hi"`,
+		"A": "two words",
+	}}
+
+	assert.Equal([]Directive{
+		{Key: "Environment", Value: `"A=two words"`},
+		{Key: "Environment", Value: `"B=say \"hi\""`},
+	}, cmd.environmentDirectives())
+}
+
+func TestInstancesFromNames(t *testing.T) {
+	assert := assert.New(t)
+
+	names := []string{
+		"worker@b.service",
+		"worker@a.service",
+		"worker@a",
+		"worker@.service",
+		"worker.service",
+		"other@c.service",
+	}
+
+	assert.Equal([]string{"a", "b"}, instancesFromNames("worker", names))
+	assert.Equal([]string{}, instancesFromNames("none", names))
+}
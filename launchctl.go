@@ -2,9 +2,6 @@
 
 package systemservice
 
-import "strings"
-
-func runLaunchCtlCommand(args ...string) (out string, err error) {
-	logger.Log("running command: launchctl ", strings.Join(args, " "))
-	return runCommand("launchctl", args...)
+func (s *SystemService) launchctl(args ...string) (out string, err error) {
+	return s.run("launchctl", args...)
 }
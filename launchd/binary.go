@@ -0,0 +1,320 @@
+package launchd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	binaryMagic = "bplist00"
+
+	// The length of the trailer at the end of a binary plist
+	binaryTrailerSize = 32
+
+	// How deep arrays and dictionaries may be nested
+	binaryMaxDepth = 128
+)
+
+/*
+binaryEpoch is the reference date of binary plist dates
+*/
+var binaryEpoch = time.Date(2001, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+/*
+binaryParser reads the objects of a binary plist through its offset
+table
+*/
+type binaryParser struct {
+	data    []byte
+	refSize int
+	offsets []uint64
+
+	// The objects currently being decoded, to detect cycles
+	visiting map[uint64]bool
+}
+
+/*
+parseBinary reads the top level object of a binary property list
+*/
+func parseBinary(data []byte) (interface{}, error) {
+	if len(data) < len(binaryMagic)+binaryTrailerSize {
+		return nil, fmt.Errorf("binary plist is too short")
+	}
+
+	trailer := data[len(data)-binaryTrailerSize:]
+	offsetSize := int(trailer[6])
+	refSize := int(trailer[7])
+	numObjects := binary.BigEndian.Uint64(trailer[8:16])
+	top := binary.BigEndian.Uint64(trailer[16:24])
+	tableOffset := binary.BigEndian.Uint64(trailer[24:32])
+
+	if offsetSize < 1 || offsetSize > 8 || refSize < 1 || refSize > 8 {
+		return nil, fmt.Errorf("invalid binary plist trailer")
+	}
+
+	end := uint64(len(data) - binaryTrailerSize)
+	if numObjects == 0 || top >= numObjects || tableOffset > end || numObjects > (end-tableOffset)/uint64(offsetSize) {
+		return nil, fmt.Errorf("invalid binary plist offset table")
+	}
+
+	p := &binaryParser{
+		data:     data[:end],
+		refSize:  refSize,
+		offsets:  make([]uint64, numObjects),
+		visiting: map[uint64]bool{},
+	}
+
+	for i := range p.offsets {
+		start := tableOffset + uint64(i*offsetSize)
+		p.offsets[i] = readUint(data[start : start+uint64(offsetSize)])
+	}
+
+	return p.object(top, 0)
+}
+
+/*
+readUint reads a big endian unsigned integer of up to 8 bytes
+*/
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+/*
+bytes returns n bytes at offset, or an error if they are out of range
+*/
+func (p *binaryParser) bytes(offset uint64, n uint64) ([]byte, error) {
+	if offset > uint64(len(p.data)) || n > uint64(len(p.data))-offset {
+		return nil, fmt.Errorf("binary plist object out of range")
+	}
+	return p.data[offset : offset+n], nil
+}
+
+/*
+length returns the element count encoded in the low nibble of the
+marker at offset, and the offset of the first element
+*/
+func (p *binaryParser) length(offset uint64) (uint64, uint64, error) {
+	marker, err := p.bytes(offset, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if n := marker[0] & 0x0f; n != 0x0f {
+		return uint64(n), offset + 1, nil
+	}
+
+	// Longer lengths follow as an integer object
+	intMarker, err := p.bytes(offset+1, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	if intMarker[0]>>4 != 0x1 || intMarker[0]&0x0f > 3 {
+		return 0, 0, fmt.Errorf("invalid length in binary plist")
+	}
+
+	size := uint64(1) << (intMarker[0] & 0x0f)
+	b, err := p.bytes(offset+2, size)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return readUint(b), offset + 2 + size, nil
+}
+
+/*
+refs reads n object references starting at offset
+*/
+func (p *binaryParser) refs(offset uint64, n uint64) ([]uint64, error) {
+	if n > uint64(len(p.data))/uint64(p.refSize) {
+		return nil, fmt.Errorf("binary plist object out of range")
+	}
+
+	b, err := p.bytes(offset, n*uint64(p.refSize))
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]uint64, n)
+	for i := range refs {
+		refs[i] = readUint(b[i*p.refSize : (i+1)*p.refSize])
+	}
+
+	return refs, nil
+}
+
+/*
+object decodes the object with the given reference
+*/
+func (p *binaryParser) object(ref uint64, depth int) (interface{}, error) {
+	if ref >= uint64(len(p.offsets)) {
+		return nil, fmt.Errorf("invalid object reference %d", ref)
+	}
+
+	if depth > binaryMaxDepth || p.visiting[ref] {
+		return nil, fmt.Errorf("binary plist objects are nested too deep or cyclic")
+	}
+
+	offset := p.offsets[ref]
+	marker, err := p.bytes(offset, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	info := marker[0] & 0x0f
+
+	switch marker[0] >> 4 {
+	case 0x0:
+		switch info {
+		case 0x0:
+			return nil, nil
+		case 0x8:
+			return false, nil
+		case 0x9:
+			return true, nil
+		}
+
+	case 0x1:
+		if info > 4 {
+			break
+		}
+		size := uint64(1) << info
+		b, err := p.bytes(offset+1, size)
+		if err != nil {
+			return nil, err
+		}
+		// 16 byte integers only exist to store unsigned 64 bit values
+		if size == 16 {
+			return readUint(b[8:]), nil
+		}
+		// Smaller integers are unsigned, 8 byte integers are signed
+		return int64(readUint(b)), nil
+
+	case 0x2:
+		switch info {
+		case 2:
+			b, err := p.bytes(offset+1, 4)
+			if err != nil {
+				return nil, err
+			}
+			return float64(math.Float32frombits(uint32(readUint(b)))), nil
+		case 3:
+			b, err := p.bytes(offset+1, 8)
+			if err != nil {
+				return nil, err
+			}
+			return math.Float64frombits(readUint(b)), nil
+		}
+
+	case 0x3:
+		if info != 3 {
+			break
+		}
+		b, err := p.bytes(offset+1, 8)
+		if err != nil {
+			return nil, err
+		}
+		seconds := math.Float64frombits(readUint(b))
+		return binaryEpoch.Add(time.Duration(seconds * float64(time.Second))), nil
+
+	case 0x4, 0x5:
+		n, start, err := p.length(offset)
+		if err != nil {
+			return nil, err
+		}
+		b, err := p.bytes(start, n)
+		if err != nil {
+			return nil, err
+		}
+		if marker[0]>>4 == 0x5 {
+			return string(b), nil
+		}
+		return append([]byte(nil), b...), nil
+
+	case 0x6:
+		n, start, err := p.length(offset)
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(p.data)) {
+			return nil, fmt.Errorf("binary plist object out of range")
+		}
+		b, err := p.bytes(start, 2*n)
+		if err != nil {
+			return nil, err
+		}
+		units := make([]uint16, n)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(b[2*i:])
+		}
+		return string(utf16.Decode(units)), nil
+
+	case 0x8:
+		b, err := p.bytes(offset+1, uint64(info)+1)
+		if err != nil {
+			return nil, err
+		}
+		return readUint(b), nil
+
+	case 0xa:
+		n, start, err := p.length(offset)
+		if err != nil {
+			return nil, err
+		}
+		refs, err := p.refs(start, n)
+		if err != nil {
+			return nil, err
+		}
+
+		p.visiting[ref] = true
+		defer delete(p.visiting, ref)
+
+		values := make([]interface{}, len(refs))
+		for i, r := range refs {
+			if values[i], err = p.object(r, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+
+	case 0xd:
+		n, start, err := p.length(offset)
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(p.data))/2 {
+			return nil, fmt.Errorf("binary plist object out of range")
+		}
+		refs, err := p.refs(start, 2*n)
+		if err != nil {
+			return nil, err
+		}
+
+		p.visiting[ref] = true
+		defer delete(p.visiting, ref)
+
+		dict := Dict{}
+		for i := uint64(0); i < n; i++ {
+			k, err := p.object(refs[i], depth+1)
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("dictionary key is a %T, not a string", k)
+			}
+			if dict[key], err = p.object(refs[n+i], depth+1); err != nil {
+				return nil, fmt.Errorf("%s: %v", key, err)
+			}
+		}
+		return dict, nil
+	}
+
+	return nil, fmt.Errorf("unknown binary plist object marker 0x%02x", marker[0])
+}
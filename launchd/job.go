@@ -0,0 +1,258 @@
+package launchd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+/*
+Job is a launchd job definition, as found in the plists below
+/Library/LaunchDaemons and ~/Library/LaunchAgents. Only the commonly
+used keys are typed, all keys including these are kept in Raw.
+*/
+type Job struct {
+	Label                string
+	Program              string
+	ProgramArguments     []string
+	EnvironmentVariables map[string]string
+	WorkingDirectory     string
+	UserName             string
+	GroupName            string
+	RunAtLoad            bool
+	StandardOutPath      string
+	StandardErrorPath    string
+	ExitTimeOut          int
+
+	// Whether or not the job is kept running. KeepAlive is also true
+	// if it is restarted under the conditions in KeepAliveConditions.
+	KeepAlive           bool
+	KeepAliveConditions Dict
+
+	StartInterval         int
+	StartCalendarInterval []map[string]int
+	WatchPaths            []string
+	QueueDirectories      []string
+
+	// The listeners of each entry of the Sockets dictionary
+	Sockets map[string][]Dict
+
+	// Whether or not the job is started per connection
+	// (inetdCompatibility with Wait set to false)
+	InetdCompatibility bool
+
+	Raw Dict
+}
+
+/*
+ParseJob reads a job definition from a property list, see Parse
+*/
+func ParseJob(data []byte) (*Job, error) {
+	dict, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewJob(dict)
+}
+
+/*
+ParseJobFile reads the job definition at path, see Parse
+*/
+func ParseJobFile(path string) (*Job, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := ParseJob(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return job, nil
+}
+
+/*
+NewJob types the keys of a job dictionary. It returns an error if a
+known key has a value of the wrong type.
+*/
+func NewJob(dict Dict) (*Job, error) {
+	job := &Job{Raw: dict}
+	var err error
+
+	str := func(key string, dst *string) {
+		if v, ok := dict[key]; ok && err == nil {
+			if *dst, ok = v.(string); !ok {
+				err = typeError(key, "string", v)
+			}
+		}
+	}
+	integer := func(key string, dst *int) {
+		if v, ok := dict[key]; ok && err == nil {
+			i, ok := v.(int64)
+			if !ok {
+				err = typeError(key, "integer", v)
+			}
+			*dst = int(i)
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		if v, ok := dict[key]; ok && err == nil {
+			if *dst, ok = v.(bool); !ok {
+				err = typeError(key, "boolean", v)
+			}
+		}
+	}
+	list := func(key string, dst *[]string) {
+		if v, ok := dict[key]; ok && err == nil {
+			*dst, err = stringArray(key, v)
+		}
+	}
+
+	str("Label", &job.Label)
+	str("Program", &job.Program)
+	list("ProgramArguments", &job.ProgramArguments)
+	str("WorkingDirectory", &job.WorkingDirectory)
+	str("UserName", &job.UserName)
+	str("GroupName", &job.GroupName)
+	boolean("RunAtLoad", &job.RunAtLoad)
+	str("StandardOutPath", &job.StandardOutPath)
+	str("StandardErrorPath", &job.StandardErrorPath)
+	integer("ExitTimeOut", &job.ExitTimeOut)
+	integer("StartInterval", &job.StartInterval)
+	list("WatchPaths", &job.WatchPaths)
+	list("QueueDirectories", &job.QueueDirectories)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if v, ok := dict["EnvironmentVariables"]; ok {
+		env, ok := v.(Dict)
+		if !ok {
+			return nil, typeError("EnvironmentVariables", "dictionary", v)
+		}
+		job.EnvironmentVariables = map[string]string{}
+		for k, value := range env {
+			s, ok := value.(string)
+			if !ok {
+				return nil, typeError("EnvironmentVariables."+k, "string", value)
+			}
+			job.EnvironmentVariables[k] = s
+		}
+	}
+
+	switch v := dict["KeepAlive"].(type) {
+	case nil:
+	case bool:
+		job.KeepAlive = v
+	case Dict:
+		job.KeepAlive = true
+		job.KeepAliveConditions = v
+	default:
+		return nil, typeError("KeepAlive", "boolean or dictionary", v)
+	}
+
+	if v, ok := dict["StartCalendarInterval"]; ok {
+		entries, err := dictArray("StartCalendarInterval", v)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			interval := map[string]int{}
+			for k, value := range entry {
+				i, ok := value.(int64)
+				if !ok {
+					return nil, typeError("StartCalendarInterval."+k, "integer", value)
+				}
+				interval[k] = int(i)
+			}
+			job.StartCalendarInterval = append(job.StartCalendarInterval, interval)
+		}
+	}
+
+	if v, ok := dict["Sockets"]; ok {
+		sockets, ok := v.(Dict)
+		if !ok {
+			return nil, typeError("Sockets", "dictionary", v)
+		}
+		job.Sockets = map[string][]Dict{}
+		for name, listeners := range sockets {
+			entries, err := dictArray("Sockets."+name, listeners)
+			if err != nil {
+				return nil, err
+			}
+			job.Sockets[name] = entries
+		}
+	}
+
+	if v, ok := dict["inetdCompatibility"]; ok {
+		inetd, ok := v.(Dict)
+		if !ok {
+			return nil, typeError("inetdCompatibility", "dictionary", v)
+		}
+		wait, _ := inetd["Wait"].(bool)
+		job.InetdCompatibility = !wait
+	}
+
+	return job, nil
+}
+
+/*
+Keys returns the sorted keys of the job dictionary
+*/
+func (j *Job) Keys() []string {
+	var keys []string
+	for k := range j.Raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+/*
+stringArray converts an array of strings
+*/
+func stringArray(key string, v interface{}) ([]string, error) {
+	values, ok := v.([]interface{})
+	if !ok {
+		return nil, typeError(key, "array", v)
+	}
+
+	out := make([]string, len(values))
+	for i, value := range values {
+		if out[i], ok = value.(string); !ok {
+			return nil, typeError(key, "array of strings", value)
+		}
+	}
+
+	return out, nil
+}
+
+/*
+dictArray converts a dictionary or an array of dictionaries, as
+accepted by StartCalendarInterval and Sockets
+*/
+func dictArray(key string, v interface{}) ([]Dict, error) {
+	if d, ok := v.(Dict); ok {
+		return []Dict{d}, nil
+	}
+
+	values, ok := v.([]interface{})
+	if !ok {
+		return nil, typeError(key, "dictionary or array", v)
+	}
+
+	out := make([]Dict, len(values))
+	for i, value := range values {
+		if out[i], ok = value.(Dict); !ok {
+			return nil, typeError(key, "array of dictionaries", value)
+		}
+	}
+
+	return out, nil
+}
+
+func typeError(key string, expected string, v interface{}) error {
+	return fmt.Errorf("%s must be a %s, got %T", key, expected, v)
+}
@@ -0,0 +1,237 @@
+/*
+Package launchd reads launchd property lists, in XML or binary
+format, into dictionaries and typed job definitions.
+*/
+package launchd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Dict is a plist dictionary. Its values are string, int64, uint64
+(for integers which do not fit an int64), float64, bool, time.Time,
+[]byte, []interface{} or Dict.
+*/
+type Dict map[string]interface{}
+
+/*
+Parse reads a property list whose top level object is a dictionary.
+The format is detected from the content.
+*/
+func Parse(data []byte) (Dict, error) {
+	var v interface{}
+	var err error
+
+	if bytes.HasPrefix(data, []byte(binaryMagic)) {
+		v, err = parseBinary(data)
+	} else {
+		v, err = parseXML(data)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	dict, ok := v.(Dict)
+	if !ok {
+		return nil, fmt.Errorf("top level object is a %T, not a dictionary", v)
+	}
+
+	return dict, nil
+}
+
+/*
+ParseFile reads the property list at path, see Parse
+*/
+func ParseFile(path string) (Dict, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	dict, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return dict, nil
+}
+
+/*
+parseXML reads the top level object of an XML property list
+*/
+func parseXML(data []byte) (interface{}, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, fmt.Errorf("no plist object found: %v", err)
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local == "plist" {
+			continue
+		}
+
+		return decodeXMLValue(d, start)
+	}
+}
+
+/*
+decodeXMLValue decodes the element which was just started
+*/
+func decodeXMLValue(d *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	switch start.Name.Local {
+	case "dict":
+		return decodeXMLDict(d)
+
+	case "array":
+		values := []interface{}{}
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := tok.(type) {
+			case xml.StartElement:
+				v, err := decodeXMLValue(d, t)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, v)
+			case xml.EndElement:
+				return values, nil
+			}
+		}
+
+	case "true", "false":
+		if _, err := xmlText(d); err != nil {
+			return nil, err
+		}
+		return start.Name.Local == "true", nil
+	}
+
+	text, err := xmlText(d)
+	if err != nil {
+		return nil, err
+	}
+
+	switch start.Name.Local {
+	case "string":
+		return text, nil
+
+	case "integer":
+		text = strings.TrimSpace(text)
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return i, nil
+		}
+		u, err := strconv.ParseUint(text, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", text)
+		}
+		return u, nil
+
+	case "real":
+		f, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid real %q", text)
+		}
+		return f, nil
+
+	case "date":
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q", text)
+		}
+		return t, nil
+
+	case "data":
+		b, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid data: %v", err)
+		}
+		return b, nil
+	}
+
+	return nil, fmt.Errorf("unknown plist element <%s>", start.Name.Local)
+}
+
+/*
+decodeXMLDict decodes the key and value pairs of a <dict>
+*/
+func decodeXMLDict(d *xml.Decoder) (Dict, error) {
+	dict := Dict{}
+	var key *string
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "key" {
+				if key != nil {
+					return nil, fmt.Errorf("key %q has no value", *key)
+				}
+				k, err := xmlText(d)
+				if err != nil {
+					return nil, err
+				}
+				key = &k
+				continue
+			}
+
+			if key == nil {
+				return nil, fmt.Errorf("<%s> without a key in dictionary", t.Name.Local)
+			}
+
+			v, err := decodeXMLValue(d, t)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", *key, err)
+			}
+			dict[*key] = v
+			key = nil
+
+		case xml.EndElement:
+			if key != nil {
+				return nil, fmt.Errorf("key %q has no value", *key)
+			}
+			return dict, nil
+		}
+	}
+}
+
+/*
+xmlText returns the text content of the element which was just
+started, consuming its end
+*/
+func xmlText(d *xml.Decoder) (string, error) {
+	var b strings.Builder
+
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return "", err
+		}
+
+		switch t := tok.(type) {
+		case xml.CharData:
+			b.Write(t)
+		case xml.StartElement:
+			return "", fmt.Errorf("unexpected <%s> in text element", t.Name.Local)
+		case xml.EndElement:
+			return b.String(), nil
+		}
+	}
+}
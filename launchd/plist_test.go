@@ -0,0 +1,97 @@
+package launchd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFile(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, path := range []string{"testdata/job.xml.plist", "testdata/job.binary.plist"} {
+		dict, err := ParseFile(path)
+		assert.NoError(err, path)
+
+		assert.Equal("com.example.worker", dict["Label"], path)
+		assert.Equal([]interface{}{"/usr/local/bin/worker", "--queue", "emails"}, dict["ProgramArguments"], path)
+		assert.Equal(Dict{"LEVEL": "debug", "NAME": "café"}, dict["EnvironmentVariables"], path)
+		assert.Equal(true, dict["RunAtLoad"], path)
+		assert.Equal(Dict{"SuccessfulExit": false}, dict["KeepAlive"], path)
+		assert.Equal(int64(5), dict["Nice"], path)
+		assert.Equal(uint64(1<<63+5), dict["Big"], path)
+		assert.Equal(0.5, dict["Ratio"], path)
+		assert.Equal(time.Date(2024, time.May, 1, 12, 30, 0, 0, time.UTC), dict["Updated"].(time.Time).UTC(), path)
+		assert.Equal([]byte("\x00\x01binary"), dict["Blob"], path)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, content := range []string{
+		"",
+		"<plist><array><string>a</string></array></plist>",
+		"<plist><dict><key>a</key></dict></plist>",
+		"<plist><dict><string>a</string></dict></plist>",
+		"<plist><dict><key>a</key><integer>x</integer></dict></plist>",
+		"<plist><dict><key>a</key><unknown/></dict></plist>",
+		"bplist00",
+		"bplist00" + strings.Repeat("\x00", 32),
+		// A dictionary with 2^63 entries, one offset and the trailer
+		"bplist00\xdf\x13\x80\x00\x00\x00\x00\x00\x00\x00" + "\x08" +
+			"\x00\x00\x00\x00\x00\x00\x01\x01" +
+			"\x00\x00\x00\x00\x00\x00\x00\x01" +
+			"\x00\x00\x00\x00\x00\x00\x00\x00" +
+			"\x00\x00\x00\x00\x00\x00\x00\x12",
+	} {
+		_, err := Parse([]byte(content))
+		assert.Error(err, content)
+	}
+}
+
+func TestParseBinaryCycle(t *testing.T) {
+	assert := assert.New(t)
+
+	// An array (object 0) containing itself
+	data := []byte("bplist00")
+	data = append(data, 0xa1, 0x00)
+	tableOffset := byte(len(data))
+	data = append(data, 0x08)
+	data = append(data, 0, 0, 0, 0, 0, 0, 1, 1)
+	data = append(data, 0, 0, 0, 0, 0, 0, 0, 1)
+	data = append(data, 0, 0, 0, 0, 0, 0, 0, 0)
+	data = append(data, 0, 0, 0, 0, 0, 0, 0, tableOffset)
+
+	_, err := parseBinary(data)
+	assert.Error(err)
+}
+
+func TestParseJobFile(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, path := range []string{"testdata/job.xml.plist", "testdata/job.binary.plist"} {
+		job, err := ParseJobFile(path)
+		assert.NoError(err, path)
+
+		assert.Equal("com.example.worker", job.Label)
+		assert.Equal([]string{"/usr/local/bin/worker", "--queue", "emails"}, job.ProgramArguments)
+		assert.Equal(map[string]string{"LEVEL": "debug", "NAME": "café"}, job.EnvironmentVariables)
+		assert.True(job.RunAtLoad)
+		assert.True(job.KeepAlive)
+		assert.Equal(Dict{"SuccessfulExit": false}, job.KeepAliveConditions)
+		assert.Equal(30, job.ExitTimeOut)
+		assert.Equal([]map[string]int{{"Hour": 3, "Minute": 0}, {"Weekday": 1, "Hour": 9, "Minute": 30}}, job.StartCalendarInterval)
+		assert.Equal([]string{"/etc/worker.conf"}, job.WatchPaths)
+		assert.Equal(map[string][]Dict{"Listeners": {{"SockServiceName": "8080", "SockType": "stream"}}}, job.Sockets)
+		assert.Contains(job.Keys(), "Nice")
+	}
+
+	_, err := NewJob(Dict{"RunAtLoad": "yes"})
+	assert.Error(err)
+
+	_, err = NewJob(Dict{"ProgramArguments": []interface{}{"/bin/app", int64(1)}})
+	assert.Error(err)
+}
@@ -0,0 +1,752 @@
+package systemservice
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/danawoodman/systemservice/unit"
+)
+
+/*
+Severity is how serious a lint issue is
+*/
+type Severity string
+
+const (
+	// SeverityError issues make systemd ignore a directive or refuse
+	// to load the unit. Install refuses units with errors.
+	SeverityError Severity = "error"
+
+	// SeverityWarning issues are likely mistakes which do not stop
+	// the unit from loading.
+	SeverityWarning Severity = "warning"
+)
+
+/*
+LintIssue is a problem found in a unit file by Lint
+*/
+type LintIssue struct {
+	// The name of the unit, e.g. "app.service"
+	Unit string
+
+	// The section and directive the issue was found in. Key is empty
+	// for issues with a whole section, both are empty for issues with
+	// the whole unit.
+	Section string
+	Key     string
+
+	Severity Severity
+	Message  string
+}
+
+/*
+String returns the issue as "unit: [Section] Key=: message"
+*/
+func (i LintIssue) String() string {
+	location := i.Unit
+	if i.Section != "" {
+		location += ": [" + i.Section + "]"
+	}
+	if i.Key != "" {
+		location += " " + i.Key + "="
+	}
+	return fmt.Sprintf("%s: %s: %s", location, i.Severity, i.Message)
+}
+
+/*
+valueGrammar checks the value of a directive
+*/
+type valueGrammar func(value string) error
+
+/*
+Lint checks a unit file without systemd: section and directive names
+are checked against the known directives, values against their
+grammar (booleans, time spans, sizes, calendar events, paths and unit
+names) and settings which cannot work in the given scope, such as
+User= in user units, are flagged. The type of the unit is taken from
+the file name; a file without a name may use the sections of any type.
+
+Sections and directives starting with "X-" are ignored, as systemd
+does. Empty values reset a directive and are always valid.
+*/
+func Lint(f *unit.File, scope Scope) []LintIssue {
+	l := &linter{file: f, scope: scope}
+	l.lint()
+	return l.issues
+}
+
+/*
+linter collects the issues of a single unit file
+*/
+type linter struct {
+	file   *unit.File
+	scope  Scope
+	issues []LintIssue
+}
+
+func (l *linter) report(section string, key string, severity Severity, format string, args ...interface{}) {
+	l.issues = append(l.issues, LintIssue{
+		Unit:     l.file.Name,
+		Section:  section,
+		Key:      key,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *linter) lint() {
+	unitType := strings.TrimPrefix(path.Ext(l.file.Name), ".")
+	if _, ok := typeSections[unitType]; l.file.Name != "" && !ok {
+		l.report("", "", SeverityError, "unknown unit type %q", unitType)
+		return
+	}
+
+	seen := map[string]bool{}
+
+	for _, s := range l.file.Sections {
+		if strings.HasPrefix(s.Name, "X-") {
+			continue
+		}
+
+		directives, ok := lintDirectives[s.Name]
+		if !ok || !sectionAllowed(unitType, s.Name) {
+			l.report(s.Name, "", SeverityError, "unknown section for a %s unit", unitTypeName(unitType))
+			continue
+		}
+
+		if seen[s.Name] {
+			l.report(s.Name, "", SeverityWarning, "section appears more than once")
+		}
+		seen[s.Name] = true
+
+		assigned := map[string]bool{}
+
+		for _, d := range s.Directives {
+			if strings.HasPrefix(d.Key, "X-") {
+				continue
+			}
+
+			grammar, ok := directives[d.Key]
+			if !ok && s.Name == "Unit" && (strings.HasPrefix(d.Key, "Condition") || strings.HasPrefix(d.Key, "Assert")) {
+				grammar, ok = checkString, true
+			}
+			if !ok {
+				// systemd ignores unknown directives, they may be newer
+				// than the table
+				l.report(s.Name, d.Key, SeverityWarning, "unknown directive, ignored by systemd versions without it")
+				continue
+			}
+
+			if d.Value == "" {
+				delete(assigned, d.Key)
+				continue
+			}
+
+			if err := grammar(d.Value); err != nil {
+				severity := SeverityError
+				if _, ok := err.(lintWarning); ok {
+					severity = SeverityWarning
+				}
+				l.report(s.Name, d.Key, severity, "%v", err)
+			}
+
+			if assigned[d.Key] && !isListDirective(d.Key) {
+				l.report(s.Name, d.Key, SeverityWarning, "assigned more than once, only the last value is used")
+			}
+			assigned[d.Key] = true
+		}
+	}
+
+	if !seen["Install"] {
+		l.report("", "", SeverityWarning, "no [Install] section, the unit cannot be enabled")
+	}
+
+	l.lintScope()
+
+	switch unitType {
+	case "service":
+		l.lintService()
+	case "socket":
+		l.requireOne("Socket", "a listening address", "ListenStream", "ListenDatagram", "ListenSequentialPacket",
+			"ListenFIFO", "ListenSpecial", "ListenNetlink", "ListenMessageQueue", "ListenUSBFunction")
+	case "timer":
+		l.requireOne("Timer", "a trigger", "OnCalendar", "OnActiveSec", "OnBootSec", "OnStartupSec",
+			"OnUnitActiveSec", "OnUnitInactiveSec", "OnClockChange", "OnTimezoneChange")
+	case "path":
+		l.requireOne("Path", "a path to watch", "PathExists", "PathExistsGlob", "PathChanged",
+			"PathModified", "DirectoryNotEmpty")
+	}
+}
+
+/*
+lintService checks the command lines of a service
+*/
+func (l *linter) lintService() {
+	serviceType, _ := l.get("Service", "Type")
+	starts := l.values("Service", "ExecStart")
+
+	if len(starts) == 0 && serviceType != "oneshot" {
+		l.report("Service", "", SeverityError, "no ExecStart=, only oneshot services may omit it")
+	}
+	if len(starts) > 1 && serviceType != "oneshot" {
+		l.report("Service", "ExecStart", SeverityError, "assigned more than once, only oneshot services may have several commands")
+	}
+
+	for _, s := range l.file.Sections {
+		if s.Name != "Service" {
+			continue
+		}
+		for _, d := range s.Directives {
+			if !strings.HasPrefix(d.Key, "Exec") || d.Value == "" {
+				continue
+			}
+			if program := commandProgram(d.Value); program != "" && !isAbsolute(program) {
+				l.report(s.Name, d.Key, SeverityWarning, "%q is not an absolute path, systemd before version 239 refuses it", program)
+			}
+		}
+	}
+}
+
+/*
+lintScope flags settings which cannot work in the scope of the linter
+*/
+func (l *linter) lintScope() {
+	if l.scope != ScopeUser {
+		return
+	}
+
+	for _, s := range l.file.Sections {
+		for _, d := range s.Directives {
+			if d.Value == "" {
+				continue
+			}
+
+			switch {
+			case s.Name == "Service" && userScopeForbidden[d.Key]:
+				l.report(s.Name, d.Key, SeverityError, "cannot be used in user units, the user service manager cannot change users")
+
+			case s.Name == "Install" && (d.Key == "WantedBy" || d.Key == "RequiredBy"):
+				for _, target := range strings.Fields(d.Value) {
+					if systemOnlyTargets[target] {
+						l.report(s.Name, d.Key, SeverityError, "%s does not exist in the user service manager, use default.target", target)
+					}
+				}
+			}
+		}
+	}
+}
+
+/*
+requireOne reports an error if none of the keys is assigned in the
+section
+*/
+func (l *linter) requireOne(section string, what string, keys ...string) {
+	for _, key := range keys {
+		if len(l.values(section, key)) > 0 {
+			return
+		}
+	}
+	l.report(section, "", SeverityError, "no %s, one of %s= is required", what, strings.Join(keys, "=, "))
+}
+
+/*
+values returns the effective values of a list directive across all
+sections with the given name
+*/
+func (l *linter) values(section string, key string) []string {
+	var values []string
+	for _, s := range l.file.Sections {
+		if s.Name != section {
+			continue
+		}
+		for _, d := range s.Directives {
+			if d.Key == key {
+				values = appendListValue(values, d.Value)
+			}
+		}
+	}
+	return values
+}
+
+/*
+get returns the last value of a directive across all sections with
+the given name
+*/
+func (l *linter) get(section string, key string) (string, bool) {
+	values := l.values(section, key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+/*
+typeSections are the sections each unit type may have besides [Unit]
+and [Install]
+*/
+var typeSections = map[string]string{
+	"service":   "Service",
+	"socket":    "Socket",
+	"timer":     "Timer",
+	"path":      "Path",
+	"target":    "",
+	"slice":     "Slice",
+	"mount":     "Mount",
+	"automount": "Automount",
+	"swap":      "Swap",
+	"scope":     "Scope",
+	"device":    "",
+}
+
+/*
+sectionAllowed returns whether or not a unit of the given type may
+have the section. Units of unknown type may have any known section.
+*/
+func sectionAllowed(unitType string, section string) bool {
+	if unitType == "" || section == "Unit" || section == "Install" {
+		return true
+	}
+	return typeSections[unitType] == section
+}
+
+func unitTypeName(unitType string) string {
+	if unitType == "" {
+		return "systemd"
+	}
+	return "." + unitType
+}
+
+/*
+userScopeForbidden are the [Service] directives which need the
+service manager to change the user of the service
+*/
+var userScopeForbidden = map[string]bool{
+	"User": true, "Group": true, "DynamicUser": true, "SupplementaryGroups": true,
+}
+
+/*
+systemOnlyTargets are the targets which only exist in the system
+service manager
+*/
+var systemOnlyTargets = map[string]bool{
+	"multi-user.target": true, "graphical.target": true, "rescue.target": true,
+	"emergency.target": true, "sysinit.target": true, "network.target": true,
+	"network-online.target": true,
+}
+
+/*
+commandProgram returns the program of a command line, without the
+"@-:+!" prefixes which change how it is run
+*/
+func commandProgram(value string) string {
+	value = strings.TrimLeft(value, "@-:+!")
+	words, err := unit.SplitWords(value)
+	if err != nil || len(words) == 0 {
+		return ""
+	}
+	return words[0]
+}
+
+/*
+isAbsolute returns whether or not a path is absolute or starts with a
+specifier which expands to an absolute path, e.g. "%h"
+*/
+func isAbsolute(p string) bool {
+	return strings.HasPrefix(p, "/") || (strings.HasPrefix(p, "%") && !strings.HasPrefix(p, "%%"))
+}
+
+func checkString(string) error {
+	return nil
+}
+
+func checkBool(value string) error {
+	_, err := parseBool(value)
+	return err
+}
+
+func checkTimespan(value string) error {
+	if value == "infinity" {
+		return nil
+	}
+	_, err := parseTimespan(value)
+	return err
+}
+
+var sizePattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?\s*[KMGTPE]?$`)
+var percentPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?%$`)
+
+/*
+checkSize checks a size in bytes with an optional base 1024 suffix,
+a percentage or "infinity"
+*/
+func checkSize(value string) error {
+	if value == "infinity" || sizePattern.MatchString(value) || percentPattern.MatchString(value) {
+		return nil
+	}
+	return fmt.Errorf("invalid size %q, expected bytes with an optional K, M, G, T, P or E suffix", value)
+}
+
+func checkCalendar(value string) error {
+	_, err := ParseCalendar(value)
+	return err
+}
+
+func checkInteger(value string) error {
+	if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+		return fmt.Errorf("invalid number %q", value)
+	}
+	return nil
+}
+
+/*
+checkLimit checks a resource limit: a number, "infinity" or a soft and
+a hard limit separated by a colon
+*/
+func checkLimit(value string) error {
+	for _, part := range strings.SplitN(value, ":", 2) {
+		if part == "infinity" {
+			continue
+		}
+		if err := checkInteger(strings.TrimRight(part, "KMGTPE")); err != nil {
+			if checkTimespan(part) == nil {
+				continue
+			}
+			return fmt.Errorf("invalid limit %q", value)
+		}
+	}
+	return nil
+}
+
+func checkMode(value string) error {
+	if _, err := strconv.ParseUint(value, 8, 32); err != nil {
+		return fmt.Errorf("invalid file mode %q, expected an octal number", value)
+	}
+	return nil
+}
+
+/*
+checkPath checks an absolute path, optionally prefixed by "-" to
+ignore a missing file
+*/
+func checkPath(value string) error {
+	if !isAbsolute(strings.TrimPrefix(value, "-")) {
+		return fmt.Errorf("%q is not an absolute path", value)
+	}
+	return nil
+}
+
+/*
+checkPaths checks a space separated list of absolute paths, each
+optionally prefixed by "-" or "+"
+*/
+func checkPaths(value string) error {
+	words, err := unit.SplitWords(value)
+	if err != nil {
+		return err
+	}
+	for _, w := range words {
+		if err := checkPath(strings.TrimLeft(w, "-+")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+checkRelativePaths checks the space separated directory names below
+the base directories of StateDirectory= and friends
+*/
+func checkRelativePaths(value string) error {
+	for _, p := range strings.Fields(value) {
+		if strings.HasPrefix(p, "/") || strings.Contains("/"+p+"/", "/../") {
+			return fmt.Errorf("%q must be relative to the base directory and stay below it", p)
+		}
+	}
+	return nil
+}
+
+/*
+checkEnvironment checks a space separated list of "KEY=value"
+assignments
+*/
+func checkEnvironment(value string) error {
+	words, err := unit.SplitWords(value)
+	if err != nil {
+		return err
+	}
+	for _, w := range words {
+		if i := strings.Index(w, "="); i < 1 {
+			return fmt.Errorf("%q is not a KEY=value assignment", w)
+		}
+	}
+	return nil
+}
+
+func checkCommand(value string) error {
+	_, err := unit.SplitWords(strings.TrimLeft(value, "@-:+!"))
+	return err
+}
+
+/*
+checkUnits checks a space separated list of unit names
+*/
+func checkUnits(value string) error {
+	for _, name := range strings.Fields(value) {
+		if unitName(name) != name {
+			return fmt.Errorf("%q is not a unit name", name)
+		}
+	}
+	return nil
+}
+
+/*
+lintWarning is returned by grammars for values systemd only warns
+about and ignores
+*/
+type lintWarning struct {
+	error
+}
+
+/*
+checkURIs checks the space separated documentation URIs. systemd
+ignores the ones it does not know, so they are only warned about.
+*/
+func checkURIs(value string) error {
+	for _, uri := range strings.Fields(value) {
+		switch strings.SplitN(uri, ":", 2)[0] {
+		case "http", "https", "file", "info", "man":
+		default:
+			return lintWarning{fmt.Errorf("%q is not a http, https, file, info or man URI, systemd ignores it", uri)}
+		}
+	}
+	return nil
+}
+
+/*
+oneOf accepts the given values
+*/
+func oneOf(values ...string) valueGrammar {
+	return func(value string) error {
+		for _, v := range values {
+			if value == v {
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid value %q, expected one of %s", value, strings.Join(values, ", "))
+	}
+}
+
+/*
+boolOr accepts a boolean or one of the given values
+*/
+func boolOr(values ...string) valueGrammar {
+	enum := oneOf(values...)
+	return func(value string) error {
+		if checkBool(value) == nil || enum(value) == nil {
+			return nil
+		}
+		return fmt.Errorf("invalid value %q, expected a boolean or one of %s", value, strings.Join(values, ", "))
+	}
+}
+
+/*
+execDirectives are the directives shared by all units which run
+processes: services, sockets, mounts and swaps
+*/
+var execDirectives = map[string]valueGrammar{
+	"WorkingDirectory": func(value string) error {
+		if value == "~" {
+			return nil
+		}
+		return checkPath(value)
+	},
+	"RootDirectory": checkPath, "RootImage": checkPath,
+	"User": checkString, "Group": checkString, "DynamicUser": checkBool,
+	"SupplementaryGroups": checkString, "PAMName": checkString,
+	"Environment": checkEnvironment, "EnvironmentFile": checkPath, "PassEnvironment": checkString,
+	"UnsetEnvironment": checkString,
+	"StandardInput":    checkString, "StandardOutput": checkString, "StandardError": checkString,
+	"SyslogIdentifier": checkString, "SyslogFacility": checkString, "SyslogLevel": checkString,
+	"LogLevelMax": checkString, "LogExtraFields": checkString,
+	"TTYPath": checkPath, "UMask": checkMode, "Nice": checkString, "OOMScoreAdjust": checkString,
+	"CPUSchedulingPolicy":   oneOf("other", "batch", "idle", "fifo", "rr"),
+	"CPUSchedulingPriority": checkInteger, "CPUAffinity": checkString,
+	"IOSchedulingClass":    oneOf("realtime", "best-effort", "idle", "0", "1", "2", "3"),
+	"IOSchedulingPriority": checkInteger, "Personality": checkString,
+	"LimitCPU": checkLimit, "LimitFSIZE": checkLimit, "LimitDATA": checkLimit,
+	"LimitSTACK": checkLimit, "LimitCORE": checkLimit, "LimitRSS": checkLimit,
+	"LimitNOFILE": checkLimit, "LimitAS": checkLimit, "LimitNPROC": checkLimit,
+	"LimitMEMLOCK": checkLimit, "LimitLOCKS": checkLimit, "LimitSIGPENDING": checkLimit,
+	"LimitMSGQUEUE": checkLimit, "LimitNICE": checkLimit, "LimitRTPRIO": checkLimit,
+	"LimitRTTIME": checkLimit,
+
+	"NoNewPrivileges": checkBool, "ProtectSystem": boolOr("full", "strict"),
+	"ProtectHome": boolOr("read-only", "tmpfs"), "PrivateTmp": checkBool,
+	"PrivateDevices": checkBool, "PrivateNetwork": checkBool, "PrivateUsers": checkBool,
+	"PrivateIPC": checkBool, "PrivateMounts": checkBool,
+	"ProtectKernelTunables": checkBool, "ProtectKernelModules": checkBool,
+	"ProtectKernelLogs": checkBool, "ProtectControlGroups": checkBool,
+	"ProtectClock": checkBool, "ProtectHostname": checkBool,
+	"ProtectProc":        oneOf("noaccess", "invisible", "ptraceable", "default"),
+	"ProcSubset":         oneOf("all", "pid"),
+	"RestrictNamespaces": boolOr("cgroup", "ipc", "net", "mnt", "pid", "user", "uts"),
+	"RestrictSUIDSGID":   checkBool, "RestrictRealtime": checkBool, "LockPersonality": checkBool,
+	"MemoryDenyWriteExecute": checkBool, "RemoveIPC": checkBool,
+	"RestrictAddressFamilies": checkString, "SystemCallFilter": checkString,
+	"SystemCallErrorNumber": checkString, "SystemCallArchitectures": checkString,
+	"CapabilityBoundingSet": checkString, "AmbientCapabilities": checkString,
+	"SecureBits": checkString, "KeyringMode": oneOf("inherit", "private", "shared"),
+	"ReadWritePaths": checkPaths, "ReadOnlyPaths": checkPaths, "InaccessiblePaths": checkPaths,
+	"ExecPaths": checkPaths, "NoExecPaths": checkPaths,
+	"BindPaths": checkString, "BindReadOnlyPaths": checkString, "TemporaryFileSystem": checkString,
+
+	"StateDirectory": checkRelativePaths, "CacheDirectory": checkRelativePaths,
+	"LogsDirectory": checkRelativePaths, "RuntimeDirectory": checkRelativePaths,
+	"ConfigurationDirectory": checkRelativePaths,
+	"StateDirectoryMode":     checkMode, "CacheDirectoryMode": checkMode,
+	"LogsDirectoryMode": checkMode, "RuntimeDirectoryMode": checkMode,
+	"ConfigurationDirectoryMode": checkMode,
+	"RuntimeDirectoryPreserve":   boolOr("restart"),
+
+	"KillMode":   oneOf("control-group", "mixed", "process", "none"),
+	"KillSignal": checkString, "RestartKillSignal": checkString, "FinalKillSignal": checkString,
+	"SendSIGKILL": checkBool, "SendSIGHUP": checkBool, "WatchdogSignal": checkString,
+
+	"Slice": checkUnits, "Delegate": checkString,
+	"CPUAccounting": checkBool, "CPUWeight": checkString, "CPUQuota": checkString,
+	"MemoryAccounting": checkBool, "MemoryMin": checkSize, "MemoryLow": checkSize,
+	"MemoryHigh": checkSize, "MemoryMax": checkSize, "MemorySwapMax": checkSize,
+	"TasksAccounting": checkBool, "TasksMax": checkSize,
+	"IOAccounting": checkBool, "IOWeight": checkString,
+	"IPAccounting": checkBool, "IPAddressAllow": checkString, "IPAddressDeny": checkString,
+	"DeviceAllow": checkString, "DevicePolicy": oneOf("auto", "closed", "strict"),
+}
+
+/*
+lintDirectives are the known directives of each section with the
+grammar of their values
+*/
+var lintDirectives = map[string]map[string]valueGrammar{
+	"Unit": {
+		"Description": checkString, "Documentation": checkURIs,
+		"Wants": checkUnits, "Requires": checkUnits, "Requisite": checkUnits,
+		"BindsTo": checkUnits, "PartOf": checkUnits, "Upholds": checkUnits,
+		"Conflicts": checkUnits, "Before": checkUnits, "After": checkUnits,
+		"OnFailure": checkUnits, "OnSuccess": checkUnits,
+		"PropagatesReloadTo": checkUnits, "ReloadPropagatedFrom": checkUnits,
+		"JoinsNamespaceOf": checkUnits, "RequiresMountsFor": checkPaths,
+		"OnFailureJobMode":      checkString,
+		"IgnoreOnIsolate":       checkBool,
+		"StopWhenUnneeded":      checkBool,
+		"RefuseManualStart":     checkBool,
+		"RefuseManualStop":      checkBool,
+		"AllowIsolate":          checkBool,
+		"DefaultDependencies":   checkBool,
+		"CollectMode":           oneOf("inactive", "inactive-or-failed"),
+		"FailureAction":         checkString,
+		"SuccessAction":         checkString,
+		"JobTimeoutSec":         checkTimespan,
+		"JobRunningTimeoutSec":  checkTimespan,
+		"StartLimitIntervalSec": checkTimespan,
+		"StartLimitBurst":       checkInteger,
+		"StartLimitAction":      checkString,
+		"RebootArgument":        checkString,
+		"SourcePath":            checkPath,
+	},
+	"Install": {
+		"Alias": checkUnits, "WantedBy": checkUnits, "RequiredBy": checkUnits,
+		"Also": checkUnits, "DefaultInstance": checkString,
+	},
+	"Service": mergeGrammars(execDirectives, map[string]valueGrammar{
+		"Type":            oneOf("simple", "exec", "forking", "oneshot", "dbus", "notify", "notify-reload", "idle"),
+		"ExitType":        oneOf("main", "cgroup"),
+		"RemainAfterExit": checkBool,
+		"GuessMainPID":    checkBool,
+		"PIDFile":         checkPath,
+		"BusName":         checkString,
+		"ExecCondition":   checkCommand, "ExecStartPre": checkCommand, "ExecStart": checkCommand,
+		"ExecStartPost": checkCommand, "ExecReload": checkCommand, "ExecStop": checkCommand,
+		"ExecStopPost":             checkCommand,
+		"RestartSec":               checkTimespan,
+		"TimeoutStartSec":          checkTimespan,
+		"TimeoutStopSec":           checkTimespan,
+		"TimeoutAbortSec":          checkTimespan,
+		"TimeoutSec":               checkTimespan,
+		"RuntimeMaxSec":            checkTimespan,
+		"WatchdogSec":              checkTimespan,
+		"Restart":                  oneOf("no", "always", "on-success", "on-failure", "on-abnormal", "on-abort", "on-watchdog"),
+		"RestartMode":              oneOf("normal", "direct"),
+		"SuccessExitStatus":        checkString,
+		"RestartPreventExitStatus": checkString,
+		"RestartForceExitStatus":   checkString,
+		"RootDirectoryStartOnly":   checkBool,
+		"NonBlocking":              checkBool,
+		"NotifyAccess":             oneOf("none", "main", "exec", "all"),
+		"Sockets":                  checkUnits,
+		"FileDescriptorStoreMax":   checkInteger,
+		"OOMPolicy":                oneOf("continue", "stop", "kill"),
+	}),
+	"Socket": mergeGrammars(execDirectives, map[string]valueGrammar{
+		"ListenStream": checkString, "ListenDatagram": checkString,
+		"ListenSequentialPacket": checkString, "ListenFIFO": checkPath,
+		"ListenSpecial": checkPath, "ListenNetlink": checkString,
+		"ListenMessageQueue": checkString, "ListenUSBFunction": checkPath,
+		"Accept": checkBool, "FileDescriptorName": checkString, "Service": checkUnits,
+		"BindIPv6Only":   oneOf("default", "both", "ipv6-only"),
+		"Backlog":        checkInteger,
+		"BindToDevice":   checkString,
+		"SocketUser":     checkString,
+		"SocketGroup":    checkString,
+		"SocketMode":     checkMode,
+		"DirectoryMode":  checkMode,
+		"MaxConnections": checkInteger, "MaxConnectionsPerSource": checkInteger,
+		"KeepAlive": checkBool, "NoDelay": checkBool, "ReusePort": checkBool,
+		"FreeBind": checkBool, "Transparent": checkBool, "Broadcast": checkBool,
+		"PassCredentials": checkBool, "PassSecurity": checkBool,
+		"ReceiveBuffer": checkSize, "SendBuffer": checkSize,
+		"RemoveOnStop": checkBool, "Symlinks": checkPaths, "Writable": checkBool,
+		"TriggerLimitIntervalSec": checkTimespan, "TriggerLimitBurst": checkInteger,
+		"ExecStartPre": checkCommand, "ExecStartPost": checkCommand,
+		"ExecStopPre": checkCommand, "ExecStopPost": checkCommand,
+		"TimeoutSec": checkTimespan,
+	}),
+	"Timer": {
+		"OnActiveSec": checkTimespan, "OnBootSec": checkTimespan,
+		"OnStartupSec": checkTimespan, "OnUnitActiveSec": checkTimespan,
+		"OnUnitInactiveSec": checkTimespan, "OnCalendar": checkCalendar,
+		"AccuracySec": checkTimespan, "RandomizedDelaySec": checkTimespan,
+		"FixedRandomDelay": checkBool, "OnClockChange": checkBool,
+		"OnTimezoneChange": checkBool, "Unit": checkUnits,
+		"Persistent": checkBool, "WakeSystem": checkBool,
+		"RemainAfterElapse": checkBool,
+	},
+	"Path": {
+		"PathExists": checkPath, "PathExistsGlob": checkPath,
+		"PathChanged": checkPath, "PathModified": checkPath,
+		"DirectoryNotEmpty": checkPath, "Unit": checkUnits,
+		"MakeDirectory": checkBool, "DirectoryMode": checkMode,
+		"TriggerLimitIntervalSec": checkTimespan, "TriggerLimitBurst": checkInteger,
+	},
+	"Slice":     mergeGrammars(execDirectives, nil),
+	"Scope":     mergeGrammars(execDirectives, nil),
+	"Mount":     mergeGrammars(execDirectives, map[string]valueGrammar{"What": checkString, "Where": checkPath, "Type": checkString, "Options": checkString, "TimeoutSec": checkTimespan}),
+	"Automount": {"Where": checkPath, "DirectoryMode": checkMode, "TimeoutIdleSec": checkTimespan},
+	"Swap":      mergeGrammars(execDirectives, map[string]valueGrammar{"What": checkPath, "Priority": checkString, "Options": checkString, "TimeoutSec": checkTimespan}),
+}
+
+/*
+mergeGrammars returns the directives of both tables
+*/
+func mergeGrammars(a map[string]valueGrammar, b map[string]valueGrammar) map[string]valueGrammar {
+	out := make(map[string]valueGrammar, len(a)+len(b))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,123 @@
+package systemservice
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/danawoodman/systemservice/unit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	assert := assert.New(t)
+	tables := []struct {
+		name     string
+		content  string
+		scope    Scope
+		expected []string
+	}{
+		{
+			name:    "app.service",
+			content: "[Unit]\nDescription=App\nDocumentation=\nAfter=network.target\n\n[Service]\nExecStart=/bin/app\nRestartSec=5 seconds\nEnvironment=\"A=1\" B=2\nX-Custom=1\n\n[Install]\nWantedBy=multi-user.target\n",
+			scope:   ScopeSystem,
+		},
+		{
+			name:     "app.service",
+			content:  "[Service]\nExecStart=/bin/app\nRestartSec=5 secs\nRestart=sometimes\nNoNewPrivileges=maybe\nMemoryMax=1 gigabyte\nPIDFile=run/app.pid\nStateDirectory=/var/lib/app\nUMask=0999\n",
+			scope:    ScopeSystem,
+			expected: []string{"RestartSec", "Restart", "NoNewPrivileges", "MemoryMax", "PIDFile", "StateDirectory", "UMask", "[Install]"},
+		},
+		{
+			name:     "app.service",
+			content:  "[Unit]\nAfter=network\nDocumentation=www.example.com\n\n[Service]\nExecStart=app --verbose\nExecStart=/bin/app\nRestartSecs=5\n\n[Timer]\nOnCalendar=daily\n\n[Install]\nWantedBy=multi-user.target\n",
+			scope:    ScopeSystem,
+			expected: []string{"After", "Documentation", "RestartSecs", "[Timer]", "several commands", "not an absolute path"},
+		},
+		{
+			name:     "app.service",
+			content:  "[Service]\nType=simple\nUser=app\nDynamicUser=yes\n\n[Install]\nWantedBy=multi-user.target\n",
+			scope:    ScopeUser,
+			expected: []string{"User", "DynamicUser", "WantedBy", "no ExecStart"},
+		},
+		{
+			name:    "app.service",
+			content: "[Service]\nType=oneshot\nExecStart=/bin/a\nExecStart=/bin/b\n\n[Install]\nWantedBy=default.target\n",
+			scope:   ScopeUser,
+		},
+		{
+			name:     "backup.timer",
+			content:  "[Timer]\nOnCalendar=Mon..Fri 25:00\nPersistent=yes\n\n[Install]\nWantedBy=timers.target\n",
+			scope:    ScopeSystem,
+			expected: []string{"OnCalendar"},
+		},
+		{
+			name:     "app.socket",
+			content:  "[Socket]\nAccept=yes\n\n[Install]\nWantedBy=sockets.target\n",
+			scope:    ScopeSystem,
+			expected: []string{"listening address"},
+		},
+		{
+			name:     "app.unknown",
+			content:  "[Service]\nExecStart=/bin/app\n",
+			scope:    ScopeSystem,
+			expected: []string{"unknown unit type"},
+		},
+	}
+
+	for _, table := range tables {
+		f, err := unit.Parse(strings.NewReader(table.content))
+		assert.NoError(err)
+		f.Name = table.name
+
+		issues := Lint(f, table.scope)
+		if !assert.Len(issues, len(table.expected), table.content) {
+			for _, issue := range issues {
+				t.Log(issue)
+			}
+			continue
+		}
+		for i, issue := range issues {
+			assert.Contains(issue.String(), table.expected[i])
+		}
+	}
+}
+
+func TestLintUnknownDirective(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := unit.Parse(strings.NewReader("[Service]\nExecStart=/bin/app\nRestartSteps=5\n\n[Install]\nWantedBy=multi-user.target\n"))
+	assert.NoError(err)
+	f.Name = "app.service"
+
+	issues := Lint(f, ScopeSystem)
+	if assert.Len(issues, 1) {
+		assert.Equal("RestartSteps", issues[0].Key)
+		assert.Equal(SeverityWarning, issues[0].Severity)
+	}
+}
+
+func TestLintDocumentation(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := unit.Parse(strings.NewReader("[Unit]\nDocumentation=https://example.com www.example.com\n\n[Service]\nExecStart=/bin/app\n\n[Install]\nWantedBy=multi-user.target\n"))
+	assert.NoError(err)
+	f.Name = "app.service"
+
+	issues := Lint(f, ScopeSystem)
+	if assert.Len(issues, 1) {
+		assert.Equal("Documentation", issues[0].Key)
+		assert.Equal(SeverityWarning, issues[0].Severity)
+		assert.Equal(`"www.example.com" is not a http, https, file, info or man URI, systemd ignores it`, issues[0].Message)
+	}
+}
+
+func TestLintIssueString(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("app.service: [Service] RestartSec=: error: invalid time span", LintIssue{
+		Unit: "app.service", Section: "Service", Key: "RestartSec", Severity: SeverityError, Message: "invalid time span",
+	}.String())
+	assert.Equal("app.service: warning: no [Install] section", LintIssue{
+		Unit: "app.service", Severity: SeverityWarning, Message: "no [Install] section",
+	}.String())
+}
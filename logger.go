@@ -1,37 +1,226 @@
 package systemservice
 
-import "log"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
 
 /*
-Logger implements a basic, overridable logging interface
+Level is the severity of a log event. The values are the ones of the
+levels of log/slog.
+*/
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+}
+
+/*
+Field is a key-value pair attached to a log event. All events of a
+service carry its "label" and "backend"; operations add "operation"
+and "duration", commands run add "command".
+*/
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+/*
+LeveledLogger receives the structured log events of the package
+*/
+type LeveledLogger interface {
+	// Enabled returns whether or not events of the level are logged,
+	// events of disabled levels are not built at all.
+	Enabled(level Level) bool
+
+	// Emit logs an event with its fields
+	Emit(level Level, msg string, fields []Field)
+}
+
+/*
+Logger implements a basic, overridable logging interface. Use FromLogger
+to turn it into a LeveledLogger.
 */
 type Logger interface {
 	Log(v ...interface{})
 	Logf(format string, v ...interface{})
 }
 
-type lgr struct{}
+/*
+DiscardLogger drops all events, it is the default logger
+*/
+var DiscardLogger LeveledLogger = discardLogger{}
+
+type discardLogger struct{}
+
+func (discardLogger) Enabled(Level) bool          { return false }
+func (discardLogger) Emit(Level, string, []Field) {}
 
 /*
-Log implements the log.Println interface
+FromLogger adapts a Logger to the LeveledLogger interface. Events of at
+least the given level are logged as a single logfmt line, e.g.
+`level=INFO msg="installing service" label=app backend=systemd`.
 */
-func (lgr) Log(v ...interface{}) {
-	log.Println(v...)
+func FromLogger(l Logger, min Level) LeveledLogger {
+	return &legacyLogger{logger: l, min: min}
+}
+
+type legacyLogger struct {
+	logger Logger
+	min    Level
+}
+
+func (l *legacyLogger) Enabled(level Level) bool {
+	return level >= l.min
+}
+
+func (l *legacyLogger) Emit(level Level, msg string, fields []Field) {
+	l.logger.Log(formatEvent(level, msg, fields))
 }
 
 /*
-Logf implements the log.Printf interface
+formatEvent formats an event as logfmt
 */
-func (lgr) Logf(format string, v ...interface{}) {
-	log.Printf(format, v...)
+func formatEvent(level Level, msg string, fields []Field) string {
+	var b strings.Builder
+	b.WriteString("level=" + level.String())
+	b.WriteString(" msg=" + formatValue(msg))
+	for _, f := range fields {
+		b.WriteString(" " + f.Key + "=" + formatValue(fmt.Sprint(f.Value)))
+	}
+	return b.String()
 }
 
-var logger Logger = lgr{}
+func formatValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+var (
+	defaultLoggerMu sync.RWMutex
+	defaultLogger   = DiscardLogger
+)
 
 /*
 SetLogger allows the consumer of this package (that's you!) configure your
-own customer logger. As long as it implements the "Logger" interface
+own customer logger. As long as it implements the "Logger" interface. It
+is used by services created without WithLogger and receives the events
+of all levels.
 */
 func SetLogger(customLogger Logger) {
-	logger = customLogger
+	SetLeveledLogger(FromLogger(customLogger, LevelDebug))
+}
+
+/*
+SetLeveledLogger sets the logger used by services created without
+WithLogger or WithLeveledLogger
+*/
+func SetLeveledLogger(l LeveledLogger) {
+	defaultLoggerMu.Lock()
+	defer defaultLoggerMu.Unlock()
+	defaultLogger = l
+}
+
+/*
+currentLogger returns the logger set with SetLogger or SetLeveledLogger
+*/
+func currentLogger() LeveledLogger {
+	defaultLoggerMu.RLock()
+	defer defaultLoggerMu.RUnlock()
+	return defaultLogger
+}
+
+/*
+fieldLogger logs events with a set of fields attached to all of them
+*/
+type fieldLogger struct {
+	out    LeveledLogger
+	fields []Field
+}
+
+/*
+defaultLog returns the logger for events not related to a service
+*/
+func defaultLog() fieldLogger {
+	return fieldLogger{out: currentLogger()}
+}
+
+/*
+with returns a logger adding the key-value pairs to the fields
+*/
+func (l fieldLogger) with(keysAndValues ...interface{}) fieldLogger {
+	fields := make([]Field, len(l.fields), len(l.fields)+len(keysAndValues)/2)
+	copy(fields, l.fields)
+	return fieldLogger{out: l.out, fields: appendFields(fields, keysAndValues)}
+}
+
+func (l fieldLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.log(LevelDebug, msg, keysAndValues)
+}
+
+func (l fieldLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.log(LevelInfo, msg, keysAndValues)
+}
+
+func (l fieldLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.log(LevelWarn, msg, keysAndValues)
+}
+
+func (l fieldLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.log(LevelError, msg, keysAndValues)
+}
+
+func (l fieldLogger) log(level Level, msg string, keysAndValues []interface{}) {
+	if !l.out.Enabled(level) {
+		return
+	}
+
+	fields := make([]Field, len(l.fields), len(l.fields)+len(keysAndValues)/2)
+	copy(fields, l.fields)
+
+	l.out.Emit(level, msg, appendFields(fields, keysAndValues))
+}
+
+/*
+appendFields appends alternating keys and values to the fields, a
+trailing key without value is logged under "!BADKEY" like log/slog does
+*/
+func appendFields(fields []Field, keysAndValues []interface{}) []Field {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 == len(keysAndValues) {
+			fields = append(fields, Field{Key: "!BADKEY", Value: keysAndValues[i]})
+			break
+		}
+		fields = append(fields, Field{Key: fmt.Sprint(keysAndValues[i]), Value: keysAndValues[i+1]})
+	}
+	return fields
+}
+
+/*
+since returns the duration since start, rounded for logging
+*/
+func since(start, now time.Time) time.Duration {
+	return now.Sub(start).Round(time.Microsecond)
 }
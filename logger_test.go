@@ -0,0 +1,111 @@
+package systemservice
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+recordingLeveledLogger keeps the events of at least min level
+*/
+type recordingLeveledLogger struct {
+	min    Level
+	events []string
+}
+
+func (l *recordingLeveledLogger) Enabled(level Level) bool {
+	return level >= l.min
+}
+
+func (l *recordingLeveledLogger) Emit(level Level, msg string, fields []Field) {
+	l.events = append(l.events, formatEvent(level, msg, fields))
+}
+
+func TestFormatEvent(t *testing.T) {
+	assert := assert.New(t)
+	tables := []struct {
+		level    Level
+		msg      string
+		fields   []Field
+		expected string
+	}{
+		{LevelInfo, "started", nil, "level=INFO msg=started"},
+		{LevelWarn, "error stopping unit", []Field{
+			{Key: "unit", Value: "app.service"},
+			{Key: "error", Value: errors.New("exit status 1")},
+		}, `level=WARN msg="error stopping unit" unit=app.service error="exit status 1"`},
+		{LevelDebug, "wrote file", []Field{
+			{Key: "content", Value: "[Unit]\n"},
+			{Key: "empty", Value: ""},
+			{Key: "duration", Value: 1500 * time.Millisecond},
+		}, `level=DEBUG msg="wrote file" content="[Unit]\n" empty="" duration=1.5s`},
+		{Level(2), "custom", nil, "level=LEVEL(2) msg=custom"},
+	}
+
+	for _, table := range tables {
+		assert.Equal(table.expected, formatEvent(table.level, table.msg, table.fields))
+	}
+}
+
+func TestFieldLogger(t *testing.T) {
+	assert := assert.New(t)
+
+	out := &recordingLeveledLogger{min: LevelInfo}
+	log := fieldLogger{out: out}.with("label", "app")
+
+	log.Debug("dropped")
+	log.Info("installing", "unit", "app.service")
+	log.with("operation", "stop").Warn("odd", "key")
+	log.Error("failed", "error", "boom")
+
+	assert.Equal([]string{
+		"level=INFO msg=installing label=app unit=app.service",
+		"level=WARN msg=odd label=app operation=stop !BADKEY=key",
+		"level=ERROR msg=failed label=app error=boom",
+	}, out.events)
+
+	// A derived logger does not change the fields of its parent
+	a := log.with("a", 1)
+	b := log.with("b", 2)
+	assert.Equal([]Field{{Key: "label", Value: "app"}, {Key: "a", Value: 1}}, a.fields)
+	assert.Equal([]Field{{Key: "label", Value: "app"}, {Key: "b", Value: 2}}, b.fields)
+}
+
+func TestLoggerDefaults(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(DiscardLogger.Enabled(LevelError))
+	assert.Equal(DiscardLogger, currentLogger())
+
+	legacy := &recordingLogger{}
+	SetLogger(legacy)
+	defer SetLeveledLogger(DiscardLogger)
+
+	defaultLog().Debug("hello", "to", "you")
+	assert.Equal([]string{"level=DEBUG msg=hello to=you"}, legacy.lines)
+
+	assert.False(FromLogger(legacy, LevelWarn).Enabled(LevelInfo))
+	assert.True(FromLogger(legacy, LevelWarn).Enabled(LevelError))
+}
+
+func TestLockLogsOperation(t *testing.T) {
+	assert := assert.New(t)
+
+	out := &recordingLeveledLogger{min: LevelDebug}
+	clock := &fakeClock{}
+	serv := New(ServiceCommand{Label: "app"}, WithLeveledLogger(out), WithClock(clock), WithBackend(platformBackend))
+
+	unlock, err := serv.lock("install")
+	assert.NoError(err)
+	clock.Sleep(2 * time.Second)
+	unlock()
+
+	backend := string(platformBackend)
+	assert.Equal([]string{
+		"level=DEBUG msg=\"operation started\" label=app backend=" + backend + " operation=install",
+		"level=INFO msg=\"operation finished\" label=app backend=" + backend + " operation=install duration=2s",
+	}, out.events)
+}
@@ -0,0 +1,176 @@
+package systemservice
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Notifier sends service state notifications to systemd over the socket
+in $NOTIFY_SOCKET, see sd_notify(3).
+
+When the service is not run by systemd (or the unit does not accept
+notifications) all methods are no-ops, so it is safe to use the
+notifier unconditionally.
+*/
+type Notifier struct {
+	socket string
+}
+
+/*
+NewNotifier creates a notifier for the socket passed to the service
+in $NOTIFY_SOCKET
+*/
+func NewNotifier() *Notifier {
+	return &Notifier{socket: os.Getenv("NOTIFY_SOCKET")}
+}
+
+/*
+Enabled returns whether or not systemd expects notifications
+*/
+func (n *Notifier) Enabled() bool {
+	return n.socket != ""
+}
+
+/*
+Notify sends the given raw "KEY=VALUE" assignments in a single
+datagram
+*/
+func (n *Notifier) Notify(state ...string) error {
+	if !n.Enabled() {
+		return nil
+	}
+
+	// Go maps a leading "@" to the abstract socket namespace
+	addr := &net.UnixAddr{Name: n.socket, Net: "unixgram"}
+
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return fmt.Errorf("could not connect to notify socket: %v", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(strings.Join(state, "\n")))
+	if err != nil {
+		return fmt.Errorf("could not send notification: %v", err)
+	}
+
+	return nil
+}
+
+/*
+Ready tells systemd the service finished starting up (READY=1)
+*/
+func (n *Notifier) Ready() error {
+	return n.Notify("READY=1")
+}
+
+/*
+Reloading tells systemd the service is reloading its configuration
+(RELOADING=1). Send Ready once the reload is complete.
+*/
+func (n *Notifier) Reloading() error {
+	state := []string{"RELOADING=1"}
+
+	// Required by Type=notify-reload to match the notification to
+	// the reload request.
+	if usec, ok := monotonicUsec(); ok {
+		state = append(state, "MONOTONIC_USEC="+strconv.FormatInt(usec, 10))
+	}
+
+	return n.Notify(state...)
+}
+
+/*
+Stopping tells systemd the service is shutting down (STOPPING=1)
+*/
+func (n *Notifier) Stopping() error {
+	return n.Notify("STOPPING=1")
+}
+
+/*
+Status sets the free-form status shown by "systemctl status" (STATUS=)
+*/
+func (n *Notifier) Status(status string) error {
+	return n.Notify("STATUS=" + strings.Replace(status, "\n", " ", -1))
+}
+
+/*
+Errno reports the errno-style error code the service failed with
+(ERRNO=)
+*/
+func (n *Notifier) Errno(errno int) error {
+	return n.Notify("ERRNO=" + strconv.Itoa(errno))
+}
+
+/*
+ExtendTimeout asks systemd to extend the current start, stop or
+reload timeout by the given duration (EXTEND_TIMEOUT_USEC=)
+*/
+func (n *Notifier) ExtendTimeout(d time.Duration) error {
+	return n.Notify("EXTEND_TIMEOUT_USEC=" + strconv.FormatInt(int64(d/time.Microsecond), 10))
+}
+
+/*
+MainPID tells systemd the main process of the service (MAINPID=)
+*/
+func (n *Notifier) MainPID(pid int) error {
+	return n.Notify("MAINPID=" + strconv.Itoa(pid))
+}
+
+/*
+Watchdog pings the service watchdog (WATCHDOG=1)
+*/
+func (n *Notifier) Watchdog() error {
+	return n.Notify("WATCHDOG=1")
+}
+
+/*
+WatchdogInterval returns the watchdog timeout systemd expects pings
+within, as configured with WatchdogSec=. It returns false if the
+watchdog is disabled or meant for another process.
+*/
+func (n *Notifier) WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+/*
+RunWatchdog pings the watchdog at half the configured interval until
+the context is done. It returns immediately if the watchdog is
+disabled.
+*/
+func (n *Notifier) RunWatchdog(ctx context.Context) error {
+	interval, ok := n.WatchdogInterval()
+	if !ok || !n.Enabled() {
+		return nil
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		if err := n.Watchdog(); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,16 @@
+// +build linux
+
+package systemservice
+
+import "golang.org/x/sys/unix"
+
+/*
+monotonicUsec returns the current CLOCK_MONOTONIC time in microseconds
+*/
+func monotonicUsec() (int64, bool) {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0, false
+	}
+	return ts.Nano() / 1000, true
+}
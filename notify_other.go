@@ -0,0 +1,10 @@
+// +build !linux
+
+package systemservice
+
+/*
+monotonicUsec is only needed for systemd, which only runs on Linux
+*/
+func monotonicUsec() (int64, bool) {
+	return 0, false
+}
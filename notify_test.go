@@ -0,0 +1,121 @@
+// +build linux
+
+package systemservice
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+listenNotifySocket starts a stand-in for the systemd notify socket
+*/
+func listenNotifySocket(t *testing.T) (*net.UnixConn, func()) {
+	dir, err := ioutil.TempDir("", "systemservice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("NOTIFY_SOCKET", path)
+
+	return conn, func() {
+		os.Unsetenv("NOTIFY_SOCKET")
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func readNotification(t *testing.T, conn *net.UnixConn) string {
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotifier(t *testing.T) {
+	assert := assert.New(t)
+	conn, cleanup := listenNotifySocket(t)
+	defer cleanup()
+
+	n := NewNotifier()
+	assert.True(n.Enabled())
+
+	tables := []struct {
+		send     func() error
+		expected string
+	}{
+		{n.Ready, "READY=1"},
+		{n.Stopping, "STOPPING=1"},
+		{func() error { return n.Status("migrating\ndatabase") }, "STATUS=migrating database"},
+		{func() error { return n.Errno(5) }, "ERRNO=5"},
+		{func() error { return n.ExtendTimeout(2 * time.Second) }, "EXTEND_TIMEOUT_USEC=2000000"},
+		{func() error { return n.MainPID(42) }, "MAINPID=42"},
+		{func() error { return n.Notify("READY=1", "STATUS=ok") }, "READY=1\nSTATUS=ok"},
+	}
+
+	for _, table := range tables {
+		assert.NoError(table.send())
+		assert.Equal(table.expected, readNotification(t, conn))
+	}
+
+	assert.NoError(n.Reloading())
+	assert.True(strings.HasPrefix(readNotification(t, conn), "RELOADING=1\nMONOTONIC_USEC="))
+}
+
+func TestNotifierDisabled(t *testing.T) {
+	os.Unsetenv("NOTIFY_SOCKET")
+
+	n := NewNotifier()
+	assert.False(t, n.Enabled())
+	assert.NoError(t, n.Ready())
+}
+
+func TestRunWatchdog(t *testing.T) {
+	assert := assert.New(t)
+	conn, cleanup := listenNotifySocket(t)
+	defer cleanup()
+
+	os.Setenv("WATCHDOG_USEC", "20000")
+	os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer os.Unsetenv("WATCHDOG_PID")
+
+	n := NewNotifier()
+	interval, ok := n.WatchdogInterval()
+	assert.True(ok)
+	assert.Equal(20*time.Millisecond, interval)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- n.RunWatchdog(ctx) }()
+
+	// Pings are sent right away and then every 10ms
+	for i := 0; i < 3; i++ {
+		assert.Equal("WATCHDOG=1", readNotification(t, conn))
+	}
+
+	cancel()
+	assert.NoError(<-done)
+
+	os.Setenv("WATCHDOG_PID", "1")
+	_, ok = n.WatchdogInterval()
+	assert.False(ok, "watchdog meant for another process")
+}
@@ -0,0 +1,297 @@
+// +build linux
+
+package systemservice
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+/*
+openrcScript represents the openrc-run script of a service managed by
+OpenRC
+*/
+type openrcScript struct {
+	Dir         string
+	Label       string
+	Name        string
+	Description string
+	Supervisor  string
+	Command     string
+	CommandArgs string
+	CommandUser string
+	Directory   string
+	PIDFile     string
+	Retry       string
+	Environment []string
+	Depend      []string
+	Paths       []string
+	Start       string
+}
+
+func newOpenRCScript(serv *SystemService) openrcScript {
+	cmd := serv.Command
+
+	name := cmd.Name
+	if name == "" {
+		name = cmd.Label
+	}
+
+	var env []string
+	for _, e := range cmd.environment() {
+		kv := strings.SplitN(e, "=", 2)
+		env = append(env, kv[0]+"="+shellQuote(kv[1]))
+	}
+
+	script := openrcScript{
+		Dir:         filepath.Join(cmd.Root, "/etc/init.d"),
+		Label:       cmd.Label,
+		Name:        name,
+		Description: oneLine(cmd.Description),
+		Command:     cmd.Program,
+		CommandArgs: shellQuoteAll(cmd.Args),
+		CommandUser: cmd.openrcUser(),
+		Retry:       fmt.Sprintf("TERM/%d/KILL/5", int(cmd.stopTimeout().Seconds())),
+		Environment: env,
+		Depend:      cmd.Dependencies.openrcDepend(),
+		Paths:       cmd.openrcPaths(),
+	}
+
+	if len(cmd.Directories.State.Paths) > 0 {
+		script.Directory = filepath.Join("/var/lib", cmd.Directories.State.Paths[0])
+	}
+
+	switch cmd.serviceType() {
+	case ServiceTypeOneshot:
+		// A custom start function runs the program in the foreground,
+		// OpenRC considers the service started once it returns. Without
+		// a command there is nothing to stop.
+		commandLine := shellQuote(cmd.Program)
+		if script.CommandArgs != "" {
+			commandLine += " " + script.CommandArgs
+		}
+		if cmd.User != "" {
+			commandLine = "su -m -s /bin/sh -c " + shellQuote(commandLine) + " " + shellQuote(cmd.User)
+		}
+		script.Start = commandLine
+		script.Command, script.CommandArgs, script.CommandUser = "", "", ""
+	case ServiceTypeForking:
+		// start-stop-daemon, the default supervisor, expects the
+		// program to daemonize and tracks it through its PID file
+		script.PIDFile = cmd.PIDFile
+	default:
+		script.Supervisor = "supervise-daemon"
+	}
+
+	return script
+}
+
+/*
+openrcUser returns the user and group the program runs as, in the
+"user:group" form of command_user
+*/
+func (c *ServiceCommand) openrcUser() string {
+	if c.User == "" {
+		return ""
+	}
+	if c.Group == "" {
+		return c.User
+	}
+	return c.User + ":" + c.Group
+}
+
+/*
+openrcPaths returns the checkpath commands creating the managed
+directories, owned by the user of the service
+*/
+func (c *ServiceCommand) openrcPaths() []string {
+	var out []string
+	for _, k := range c.Directories.kinds() {
+		mode := k.Mode.Perm()
+		if mode == 0 {
+			mode = 0755
+		}
+		for _, p := range k.Paths {
+			path := "checkpath --directory"
+			if user := c.openrcUser(); user != "" {
+				path += " --owner " + shellQuote(user)
+			}
+			path += fmt.Sprintf(" --mode %04o %s", mode, shellQuote(filepath.Join(k.systemBase, p)))
+			out = append(out, path)
+		}
+	}
+	return out
+}
+
+/*
+openrcDepend returns the lines of the depend function. The network
+targets of systemd map to the net service, other targets are skipped.
+Without dependencies the service starts after the network, like with
+systemd.
+*/
+func (d *Dependencies) openrcDepend() []string {
+	names := func(lists ...[]string) string {
+		var out []string
+		seen := map[string]bool{}
+		for _, list := range lists {
+			for _, name := range list {
+				switch name {
+				case "network.target", "network-online.target":
+					name = "net"
+				default:
+					if strings.HasSuffix(name, ".target") {
+						continue
+					}
+					name = strings.TrimSuffix(name, ".service")
+				}
+				if !seen[name] {
+					seen[name] = true
+					out = append(out, name)
+				}
+			}
+		}
+		return strings.Join(out, " ")
+	}
+
+	need := d.Requires
+	if d.NetworkOnline {
+		need = append([]string{"net"}, need...)
+	}
+
+	after := d.After
+	if len(d.After) == 0 && len(d.Before) == 0 && !d.NetworkOnline {
+		after = []string{"net"}
+	}
+
+	var out []string
+	for _, line := range []struct {
+		keyword string
+		names   string
+	}{
+		{"need", names(need, d.BindsTo)},
+		{"use", names(d.Wants)},
+		{"after", names(after)},
+		{"before", names(d.Before)},
+	} {
+		if line.names != "" {
+			out = append(out, line.keyword+" "+line.names)
+		}
+	}
+	return out
+}
+
+/*
+validateOpenRC returns an error if the command uses an option OpenRC
+has no equivalent for
+*/
+func validateOpenRC(serv *SystemService) error {
+	cmd := serv.Command
+
+	unsupported := func(option, reason string) error {
+		return &UnsupportedOptionError{Backend: string(BackendOpenRC), Option: option, Reason: reason}
+	}
+
+	if serv.scope() == ScopeUser {
+		return unsupported("user scope", "services are system wide, install the service as root")
+	}
+
+	if !filepath.IsAbs(cmd.Program) {
+		return unsupported("relative Program", "services run with a minimal PATH, use an absolute path")
+	}
+
+	if err := cmd.validateShellEnvironment(); err != nil {
+		return err
+	}
+
+	switch t := cmd.serviceType(); t {
+	case ServiceTypeNotify, ServiceTypeNotifyReload:
+		return unsupported(fmt.Sprintf("service type %q", t), "there is no readiness notification")
+	case ServiceTypeForking:
+		if cmd.PIDFile == "" {
+			return unsupported("forking service without PIDFile", "start-stop-daemon tracks the program through its PID file")
+		}
+	}
+
+	switch {
+	case cmd.Sockets.enabled():
+		return unsupported("Sockets", "OpenRC cannot start services on demand")
+	case cmd.Schedule.enabled():
+		return unsupported("Schedule", "use cron for periodic jobs")
+	case len(cmd.Triggers) > 0:
+		return unsupported("Triggers", "OpenRC cannot watch paths")
+	case cmd.WatchdogSec > 0:
+		return unsupported("WatchdogSec", "supervise-daemon only restarts programs which exit")
+	case len(cmd.Hardening.directives()) > 0:
+		return unsupported("Hardening", "OpenRC cannot sandbox services")
+	case cmd.DynamicUser:
+		return unsupported("DynamicUser", "OpenRC cannot allocate users")
+	case cmd.CreateUser:
+		return unsupported("CreateUser", "create the account when provisioning the machine")
+	case len(cmd.Extra) > 0:
+		return unsupported("Extra", "raw directives are systemd unit directives")
+	}
+
+	return nil
+}
+
+func (o *openrcScript) Generate() (string, error) {
+	var out bytes.Buffer
+	t := template.Must(template.New("openrcScript").Parse(openrcScriptTemplate))
+	if err := t.Execute(&out, o); err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
+
+func (o *openrcScript) Path() string {
+	return filepath.Join(o.Dir, o.Label)
+}
+
+/*
+Quote returns a shell quoted value for the template
+*/
+func (o *openrcScript) Quote(s string) string {
+	return shellQuote(s)
+}
+
+/*
+openrcScriptTemplate is the openrc-run script. openrc-run evaluates
+command_args again when starting the program, so the quoted arguments
+are quoted once more.
+*/
+const openrcScriptTemplate = `#!/sbin/openrc-run
+
+# Generated by systemservice, changes are overwritten on install
+
+name={{ .Quote .Name }}{{ if .Description }}
+description={{ .Quote .Description }}{{ end }}{{ if .Supervisor }}
+supervisor={{ .Supervisor }}{{ end }}{{ if .Command }}
+command={{ .Quote .Command }}{{ end }}{{ if .CommandArgs }}
+command_args={{ .Quote .CommandArgs }}{{ end }}{{ if .CommandUser }}
+command_user={{ .Quote .CommandUser }}{{ end }}{{ if .Directory }}
+directory={{ .Quote .Directory }}{{ end }}{{ if .PIDFile }}
+pidfile={{ .Quote .PIDFile }}{{ end }}
+retry={{ .Retry }}{{ if .Environment }}
+{{ range .Environment }}
+export {{ . }}{{ end }}{{ end }}
+{{ if .Depend }}
+depend() {
+{{- range .Depend }}
+	{{ . }}{{ end }}
+}
+{{ end }}{{ if .Paths }}
+start_pre() {
+{{- range .Paths }}
+	{{ . }}{{ end }}
+}
+{{ end }}{{ if .Start }}
+start() {
+	ebegin "Running $name"
+	{{ .Start }}
+	eend $?
+}
+{{ end }}`
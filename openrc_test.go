@@ -0,0 +1,291 @@
+// +build linux
+
+package systemservice
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenRCScript(t *testing.T) {
+	assert := assert.New(t)
+
+	serv := New(ServiceCommand{
+		Label:       "app",
+		Description: "My\napp",
+		Program:     "/usr/bin/app",
+		Args:        []string{"--name", "it's me"},
+		Environment: map[string]string{"GREETING": "hello world"},
+		StopTimeout: 5 * time.Second,
+		User:        "app",
+		Group:       "staff",
+		Directories: Directories{State: ManagedDirectory{Paths: []string{"app"}, Mode: 0750}},
+		Dependencies: Dependencies{
+			After:    []string{"network-online.target", "postgresql.service"},
+			Requires: []string{"redis"},
+			Wants:    []string{"multi-user.target"},
+		},
+	}, WithBackend(BackendOpenRC))
+
+	script := newOpenRCScript(&serv)
+	content, err := script.Generate()
+	assert.NoError(err)
+
+	assert.Equal("/etc/init.d/app", script.Path())
+	assert.Equal(`#!/sbin/openrc-run
+
+# Generated by systemservice, changes are overwritten on install
+
+name=app
+description='My app'
+supervisor=supervise-daemon
+command=/usr/bin/app
+command_args='--name '\''it'\''\'\'''\''s me'\'''
+command_user=app:staff
+directory=/var/lib/app
+retry=TERM/5/KILL/5
+
+export GREETING='hello world'
+
+depend() {
+	need redis
+	after net postgresql
+}
+
+start_pre() {
+	checkpath --directory --owner app:staff --mode 0750 /var/lib/app
+}
+`, content)
+}
+
+func TestOpenRCServiceTypes(t *testing.T) {
+	tests := []struct {
+		name        string
+		cmd         ServiceCommand
+		contains    []string
+		notContains []string
+	}{
+		{
+			name:        "forking",
+			cmd:         ServiceCommand{Label: "app", Program: "/usr/sbin/appd", Type: ServiceTypeForking, PIDFile: "/run/appd.pid"},
+			contains:    []string{"command=/usr/sbin/appd\n", "pidfile=/run/appd.pid\n"},
+			notContains: []string{"supervisor="},
+		},
+		{
+			name:        "oneshot",
+			cmd:         ServiceCommand{Label: "app", Program: "/usr/bin/migrate", Args: []string{"up"}, Type: ServiceTypeOneshot, User: "app"},
+			contains:    []string{"start() {\n\tebegin \"Running $name\"\n\tsu -m -s /bin/sh -c '/usr/bin/migrate up' app\n\teend $?\n}\n"},
+			notContains: []string{"supervisor=", "command="},
+		},
+		{
+			name:     "network online",
+			cmd:      ServiceCommand{Label: "app", Program: "/usr/bin/app", Dependencies: Dependencies{NetworkOnline: true, BindsTo: []string{"db"}}},
+			contains: []string{"depend() {\n\tneed net db\n}\n"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serv := New(test.cmd, WithBackend(BackendOpenRC))
+			script := newOpenRCScript(&serv)
+			content, err := script.Generate()
+			assert.NoError(t, err)
+
+			for _, s := range test.contains {
+				assert.Contains(t, content, s)
+			}
+			for _, s := range test.notContains {
+				assert.NotContains(t, content, s)
+			}
+		})
+	}
+}
+
+func TestValidateOpenRC(t *testing.T) {
+	tests := []struct {
+		name   string
+		cmd    ServiceCommand
+		option string
+	}{
+		{"valid", ServiceCommand{Label: "app", Program: "/usr/bin/app", Directories: Directories{State: ManagedDirectory{Paths: []string{"app"}}}}, ""},
+		{"relative program", ServiceCommand{Label: "app", Program: "app"}, "relative Program"},
+		{"notify", ServiceCommand{Label: "app", Program: "/usr/bin/app", Type: ServiceTypeNotify}, `service type "notify"`},
+		{"forking without pid file", ServiceCommand{Label: "app", Program: "/usr/bin/app", Type: ServiceTypeForking}, "forking service without PIDFile"},
+		{"dynamic user", ServiceCommand{Label: "app", Program: "/usr/bin/app", DynamicUser: true}, "DynamicUser"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serv := New(test.cmd, WithBackend(BackendOpenRC), WithScope(ScopeSystem))
+
+			err := validateOpenRC(&serv)
+			if test.option == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			var unsupported *UnsupportedOptionError
+			if assert.True(t, errors.As(err, &unsupported)) {
+				assert.Equal(t, "openrc", unsupported.Backend)
+				assert.Equal(t, test.option, unsupported.Option)
+			}
+		})
+	}
+}
+
+func TestOpenRCEnvironmentNames(t *testing.T) {
+	assert := assert.New(t)
+
+	fs := newLinkMemFs()
+	serv := New(ServiceCommand{
+		Label:       "app",
+		Program:     "/usr/bin/app",
+		Environment: map[string]string{"A;rm -rf /;B": "x"},
+	}, WithFS(fs), WithScope(ScopeSystem), WithBackend(BackendOpenRC))
+
+	err := serv.Install(false)
+	assert.EqualError(err, `environment variable "A;rm -rf /;B" is not a valid name, it must match ^[A-Za-z_][A-Za-z0-9_]*$`)
+	assert.False(fileExists(fs, "/etc/init.d/app"))
+
+	_, err = serv.Reconcile(context.Background())
+	assert.Error(err)
+	assert.False(fileExists(fs, "/etc/init.d/app"))
+}
+
+func TestParseOpenRCStatus(t *testing.T) {
+	tests := map[string]string{
+		" * status: started\n":                     "started",
+		" * status: stopped\n":                     "stopped",
+		"status: crashed":                          "crashed",
+		" * WARNING: app is already starting\n":    "",
+		"rc-service: service `app' does not exist": "",
+	}
+
+	for out, state := range tests {
+		assert.Equal(t, state, parseOpenRCStatus(out), out)
+	}
+}
+
+func TestInstallOpenRC(t *testing.T) {
+	assert := assert.New(t)
+
+	fs := newLinkMemFs()
+	state := "stopped"
+
+	var calls []string
+	runner := RunnerFunc(func(name string, args ...string) (string, error) {
+		calls = append(calls, name+" "+strings.Join(args, " "))
+		if name != "rc-service" {
+			return "", nil
+		}
+		switch args[1] {
+		case "start":
+			state = "started"
+			assert.NoError(afero.WriteFile(fs, "/run/openrc/options/app/child_pid", []byte("42\n"), 0644))
+		case "stop":
+			state = "stopped"
+		case "status":
+			if state != "started" {
+				return " * status: " + state + "\n", errors.New("exit status 3")
+			}
+			return " * status: started\n", nil
+		}
+		return "", nil
+	})
+
+	serv := New(ServiceCommand{Label: "app", Program: "/usr/bin/app"},
+		WithFS(fs),
+		WithRunner(runner),
+		WithClock(&fakeClock{}),
+		WithScope(ScopeSystem),
+		WithBackend(BackendOpenRC),
+	)
+
+	assert.NoError(serv.Install(true))
+	assert.True(serv.Exists())
+
+	status, err := serv.Status()
+	assert.NoError(err)
+	assert.Equal(&ServiceStatus{Running: true, PID: 42}, status)
+
+	assert.NoError(serv.Stop())
+	running, err := serv.Running()
+	assert.NoError(err)
+	assert.False(running)
+
+	assert.NoError(serv.Uninstall())
+	assert.False(serv.Exists())
+
+	assert.Equal([]string{
+		"rc-update add app default",
+		"rc-service app start",
+		"rc-service app status",
+		"rc-service app status",
+		"rc-service app stop",
+		"rc-service app status",
+		"rc-service app stop",
+	}, calls)
+
+	var unsupported *UnsupportedOptionError
+	_, err = serv.ListOverrides()
+	assert.True(errors.As(err, &unsupported))
+	assert.Equal("overrides", unsupported.Option)
+}
+
+func TestInstallOpenRCFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	fs := newLinkMemFs()
+	runner := RunnerFunc(func(name string, args ...string) (string, error) {
+		if name == "rc-service" && args[1] == "status" {
+			return " * status: crashed\n", errors.New("exit status 32")
+		}
+		return "", nil
+	})
+
+	serv := New(ServiceCommand{Label: "app", Program: "/usr/bin/app"},
+		WithFS(fs),
+		WithRunner(runner),
+		WithClock(&fakeClock{}),
+		WithScope(ScopeSystem),
+		WithBackend(BackendOpenRC),
+	)
+
+	err := serv.Install(true)
+	var installErr *InstallError
+	assert.True(errors.As(err, &installErr))
+	assert.Contains(err.Error(), "app is crashed after starting it")
+	assert.False(serv.Exists(), "the script is removed again")
+}
+
+func TestInstallOpenRCBelowRoot(t *testing.T) {
+	assert := assert.New(t)
+
+	fs := newLinkMemFs()
+	serv := New(ServiceCommand{Label: "app", Program: "/usr/bin/app", Root: "/image"},
+		WithFS(fs),
+		WithRunner(RunnerFunc(func(name string, args ...string) (string, error) {
+			t.Errorf("unexpected command %s %v", name, args)
+			return "", nil
+		})),
+		WithBackend(BackendOpenRC),
+	)
+
+	assert.NoError(serv.Install(true))
+	assert.True(fileExists(fs, "/image/etc/init.d/app"))
+	assert.Equal("/etc/init.d/app", fs.links["/image/etc/runlevels/default/app"])
+
+	diff, err := serv.Diff()
+	assert.NoError(err)
+	assert.False(diff.Changed())
+
+	assert.NoError(serv.Uninstall())
+	assert.False(fileExists(fs, "/image/etc/runlevels/default/app"))
+	assert.False(fileExists(fs, "/image/etc/init.d/app"))
+}
@@ -0,0 +1,298 @@
+package systemservice
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+/*
+Option configures a SystemService created with New or Open
+*/
+type Option func(*SystemService)
+
+/*
+WithLogger sets the logger of the service instead of the one set with
+SetLogger. It receives the events of all levels, see FromLogger.
+*/
+func WithLogger(l Logger) Option {
+	return WithLeveledLogger(FromLogger(l, LevelDebug))
+}
+
+/*
+WithLeveledLogger sets the logger of the service instead of the one set
+with SetLeveledLogger
+*/
+func WithLeveledLogger(l LeveledLogger) Option {
+	return func(s *SystemService) {
+		s.logger = l
+	}
+}
+
+/*
+WithFS sets the file system the files of the service are read from and
+written to, see SystemService.FS
+*/
+func WithFS(fs afero.Fs) Option {
+	return func(s *SystemService) {
+		s.FS = fs
+	}
+}
+
+/*
+WithRunner sets how the command line tools of the service manager are
+run. With a runner the systemd backend runs systemctl instead of using
+systemd's D-Bus API, so all operations go through the runner.
+*/
+func WithRunner(r Runner) Option {
+	return func(s *SystemService) {
+		s.runner = r
+	}
+}
+
+/*
+WithClock sets the clock used to wait for services and to compute when
+scheduled services run next
+*/
+func WithClock(c Clock) Option {
+	return func(s *SystemService) {
+		s.clock = c
+	}
+}
+
+/*
+WithScope installs the service for the given scope instead of the one
+of the current user: ScopeSystem when running as root, ScopeUser
+otherwise. Only used by systemd and launchd.
+*/
+func WithScope(scope Scope) Option {
+	return func(s *SystemService) {
+		s.scopeOption = scope
+	}
+}
+
+/*
+WithBackend selects the service manager instead of the default one of
+the platform. Operations fail with an UnsupportedOptionError if the
+backend is not available on the platform.
+*/
+func WithBackend(b Backend) Option {
+	return func(s *SystemService) {
+		s.backendOption = b
+	}
+}
+
+/*
+WithDetectionFS sets the file system the init system is detected on
+instead of the one of the machine, e.g. a fake root in tests. WithFS
+does not change the detection: the service files can be kept in memory
+while the machine runs systemd.
+*/
+func WithDetectionFS(fs afero.Fs) Option {
+	return func(s *SystemService) {
+		s.detectionFS = fs
+	}
+}
+
+/*
+Runner runs a command line tool, returning what it printed on stdout
+*/
+type Runner interface {
+	Run(name string, args ...string) (string, error)
+}
+
+/*
+RunnerFunc adapts a function to the Runner interface
+*/
+type RunnerFunc func(name string, args ...string) (string, error)
+
+/*
+Run calls the function
+*/
+func (f RunnerFunc) Run(name string, args ...string) (string, error) {
+	return f(name, args...)
+}
+
+/*
+execRunner runs commands with os/exec
+*/
+type execRunner struct{}
+
+func (execRunner) Run(name string, args ...string) (string, error) {
+	stdout, err := exec.Command(name, args...).Output()
+	return string(stdout), err
+}
+
+/*
+Clock tells the time and waits
+*/
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+/*
+realClock is the clock of the time package
+*/
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+/*
+Backend is a service manager the package can drive
+*/
+type Backend string
+
+const (
+	BackendSystemd Backend = "systemd"
+	BackendLaunchd Backend = "launchd"
+	BackendWindows Backend = "windows"
+
+	// BackendSysV manages services with LSB init scripts, on Linux
+	// machines running SysV init
+	BackendSysV Backend = "sysv"
+
+	// BackendOpenRC manages services with openrc-run scripts, on
+	// Linux machines running OpenRC
+	BackendOpenRC Backend = "openrc"
+)
+
+/*
+log returns the logger of the service, adding the label and the
+backend of the service to all events
+*/
+func (s *SystemService) log() fieldLogger {
+	out := s.logger
+	if out == nil {
+		out = currentLogger()
+	}
+	return fieldLogger{out: out}.with("label", s.Command.Label, "backend", string(s.backend()))
+}
+
+/*
+run runs a command line tool with the runner of the service
+*/
+func (s *SystemService) run(name string, args ...string) (string, error) {
+	runner := s.runner
+	if runner == nil {
+		runner = execRunner{}
+	}
+
+	start := s.now()
+	out, err := runner.Run(name, args...)
+
+	log := s.log().with("command", name+" "+strings.Join(args, " "), "duration", since(start, s.now()))
+	if err != nil {
+		// Failing commands are often expected, e.g. systemctl is-enabled
+		log.Debug("command failed", "error", err)
+	} else {
+		log.Debug("ran command")
+	}
+
+	return out, err
+}
+
+/*
+now returns the current time of the clock of the service
+*/
+func (s *SystemService) now() time.Time {
+	return s.clockOrDefault().Now()
+}
+
+/*
+sleep waits using the clock of the service
+*/
+func (s *SystemService) sleep(d time.Duration) {
+	s.clockOrDefault().Sleep(d)
+}
+
+func (s *SystemService) clockOrDefault() Clock {
+	if s.clock == nil {
+		return realClock{}
+	}
+	return s.clock
+}
+
+/*
+backend returns the service manager of the service: the one set with
+WithBackend or the one detected. Services installed below a Root use
+the default backend of the platform.
+*/
+func (s *SystemService) backend() Backend {
+	if s.backendOption != "" {
+		return s.backendOption
+	}
+	if s.Command.Root != "" {
+		return platformBackend
+	}
+	if d, err := s.detect(); err == nil {
+		return d.Backend
+	}
+	return platformBackend
+}
+
+/*
+checkBackend returns an UnsupportedOptionError if the backend of the
+service is not available on this platform, and an error wrapping
+ErrNoSupportedInitSystem if the machine does not run it
+*/
+func (s *SystemService) checkBackend() error {
+	if b := s.backend(); !platformSupports(b) {
+		return &UnsupportedOptionError{
+			Backend: string(platformBackend),
+			Option:  "Backend",
+			Reason:  string(b) + " is not available on " + runtime.GOOS,
+		}
+	}
+	return s.checkInitSystem()
+}
+
+/*
+serviceLocks holds a lock per installed service. The locks are keyed
+by service instead of being part of SystemService, so copies of a
+SystemService share them.
+*/
+var serviceLocks = struct {
+	sync.Mutex
+	locks map[string]*sync.Mutex
+}{locks: map[string]*sync.Mutex{}}
+
+/*
+lock checks the backend and serializes the changes to the service,
+returning the function releasing the lock. Changes to different
+services run concurrently. The operation is logged with its duration
+when the lock is released.
+*/
+func (s *SystemService) lock(operation string) (func(), error) {
+	if err := s.checkBackend(); err != nil {
+		return nil, err
+	}
+
+	key := s.Command.Root + "\x00" + s.Command.Label
+
+	serviceLocks.Lock()
+	l, ok := serviceLocks.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		serviceLocks.locks[key] = l
+	}
+	serviceLocks.Unlock()
+
+	l.Lock()
+
+	log := s.log().with("operation", operation)
+	log.Debug("operation started")
+	start := s.now()
+
+	return func() {
+		log.Info("operation finished", "duration", since(start, s.now()))
+		l.Unlock()
+	}, nil
+}
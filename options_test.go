@@ -0,0 +1,159 @@
+package systemservice
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+recordingLogger keeps the lines it logs
+*/
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordingLogger) Log(v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprint(v...))
+}
+
+func (l *recordingLogger) Logf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+/*
+fakeClock is a clock whose time only moves when it sleeps
+*/
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.Sleep(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.Now()
+	return ch
+}
+
+func TestOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	serv := New(ServiceCommand{Label: "app"})
+	assert.Equal(DiscardLogger, serv.log().out)
+	assert.Equal(afero.NewOsFs(), serv.fs())
+	assert.Equal(realClock{}, serv.clockOrDefault())
+	assert.Equal(platformBackend, serv.backend())
+	assert.NoError(serv.checkBackend())
+
+	logger := &recordingLogger{}
+	fs := afero.NewMemMapFs()
+	clock := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	var calls []string
+	runner := RunnerFunc(func(name string, args ...string) (string, error) {
+		calls = append(calls, name+" "+strings.Join(args, " "))
+		return "done", nil
+	})
+
+	serv = New(ServiceCommand{Label: "app"},
+		WithLogger(logger),
+		WithFS(fs),
+		WithRunner(runner),
+		WithClock(clock),
+		WithScope(ScopeUser),
+	)
+	assert.Equal(fs, serv.fs())
+	assert.Equal(ScopeUser, serv.scope())
+
+	out, err := serv.run("tool", "a", "b")
+	assert.NoError(err)
+	assert.Equal("done", out)
+	assert.Equal([]string{"tool a b"}, calls)
+	assert.Equal([]string{
+		"level=DEBUG msg=\"ran command\" label=app backend=" + string(platformBackend) + " command=\"tool a b\" duration=0s",
+	}, logger.lines)
+
+	serv.sleep(time.Minute)
+	assert.Equal(time.Date(2020, 1, 1, 0, 1, 0, 0, time.UTC), serv.now())
+
+	opened := Open("app.service", WithFS(fs))
+	assert.Equal("app", opened.Command.Label)
+	assert.Equal(fs, opened.fs())
+	assert.Error(opened.owned())
+}
+
+func TestWithBackend(t *testing.T) {
+	assert := assert.New(t)
+
+	other := BackendWindows
+	if platformBackend == BackendWindows {
+		other = BackendLaunchd
+	}
+
+	serv := New(ServiceCommand{Label: "app"}, WithBackend(other))
+
+	var unsupported *UnsupportedOptionError
+	assert.True(errors.As(serv.Start(), &unsupported))
+	assert.Equal("Backend", unsupported.Option)
+
+	_, err := serv.Status()
+	assert.True(errors.As(err, &unsupported))
+
+	serv = New(ServiceCommand{Label: "app"}, WithBackend(platformBackend))
+	assert.NoError(serv.checkBackend())
+}
+
+func TestLock(t *testing.T) {
+	assert := assert.New(t)
+
+	serv := New(ServiceCommand{Label: "app"}, WithBackend(platformBackend))
+	copied := serv
+
+	unlock, err := serv.lock("test")
+	assert.NoError(err)
+
+	locked := make(chan struct{})
+	go func() {
+		unlockCopy, err := copied.lock("test")
+		assert.NoError(err)
+		close(locked)
+		unlockCopy()
+	}()
+
+	other := New(ServiceCommand{Label: "other"}, WithBackend(platformBackend))
+	unlockOther, err := other.lock("test")
+	assert.NoError(err)
+	unlockOther()
+
+	select {
+	case <-locked:
+		t.Fatal("a copy of a locked service must wait for the lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	<-locked
+}
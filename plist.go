@@ -4,6 +4,8 @@ package systemservice
 
 import (
 	"bytes"
+	"encoding/xml"
+	"fmt"
 	"path/filepath"
 	"text/template"
 )
@@ -19,13 +21,29 @@ type plist struct {
 	RunAtLoad        bool
 	StdOutPath       string
 	StdErrPath       string
+	ExitTimeOut      int
+	SocketsName      string
+	Sockets          []socketListener
+	Accept           bool
+
+	EnvironmentVariables map[string]string
+
+	StartCalendarInterval []map[string]int
+	StartInterval         int
+	WatchPaths            []string
+	QueueDirectories      []string
+
+	// Whether or not the job is a system wide daemon instead of an
+	// agent of the user
+	daemon bool
 }
 
 func newPlist(serv *SystemService) plist {
 	label := serv.Command.Label
 	name := serv.Command.Name
+	daemon := serv.scope() == ScopeSystem
 	logDir := filepath.Join(homeDir(), "Library/Logs", name)
-	if isRoot() {
+	if daemon {
 		logDir = filepath.Join("/Library/Logs", name)
 	}
 	args := []string{serv.Command.Program}
@@ -33,22 +51,86 @@ func newPlist(serv *SystemService) plist {
 		args = append(args, serv.Command.Args...)
 	}
 
+	cmd := serv.Command
+
+	// Oneshot programs run to completion once loaded, programs started
+	// on demand are started by launchd when needed, everything else is
+	// kept alive.
+	keepAlive := cmd.serviceType() != ServiceTypeOneshot && !cmd.activated()
+	runAtLoad := !cmd.activated() || cmd.Schedule.OnBootSec > 0
+
+	socketsName := cmd.Sockets.Name
+	if socketsName == "" {
+		socketsName = "Listeners"
+	}
+
+	intervals, _ := cmd.Schedule.launchdIntervals()
+	watchPaths, queueDirectories := cmd.launchdTriggers()
+
 	pl := plist{
 		Label:            label,
 		ProgramArguments: args,
-		KeepAlive:        true,
-		RunAtLoad:        true,
+		KeepAlive:        keepAlive,
+		RunAtLoad:        runAtLoad,
 		StdOutPath:       filepath.Join(logDir, name+".stdout.log"),
 		StdErrPath:       filepath.Join(logDir, name+".stderr.log"),
+		ExitTimeOut:      int(serv.Command.StopTimeout.Seconds()),
+		SocketsName:      socketsName,
+		Sockets:          cmd.Sockets.listeners(),
+		Accept:           cmd.Sockets.Accept,
+
+		EnvironmentVariables: cmd.Environment,
+
+		StartCalendarInterval: intervals,
+		StartInterval:         int(cmd.Schedule.OnUnitActiveSec.Seconds()),
+		WatchPaths:            watchPaths,
+		QueueDirectories:      queueDirectories,
+
+		daemon: daemon,
 	}
 
 	return pl
 }
 
+/*
+validatePlist returns an error if the command uses an option launchd
+has no equivalent for
+*/
+func validatePlist(cmd ServiceCommand) error {
+	if _, err := cmd.Schedule.launchdIntervals(); err != nil {
+		return err
+	}
+
+	if cmd.Root != "" {
+		return &UnsupportedOptionError{
+			Backend: "launchd",
+			Option:  "Root",
+			Reason:  "jobs can only be installed into the running system",
+		}
+	}
+
+	switch t := cmd.serviceType(); t {
+	case ServiceTypeForking:
+		return &UnsupportedOptionError{
+			Backend: "launchd",
+			Option:  fmt.Sprintf("service type %q", t),
+			Reason:  "programs must stay in the foreground",
+		}
+	case ServiceTypeNotify, ServiceTypeNotifyReload:
+		return &UnsupportedOptionError{
+			Backend: "launchd",
+			Option:  fmt.Sprintf("service type %q", t),
+			Reason:  "there is no readiness notification",
+		}
+	}
+
+	return nil
+}
+
 // TODO: Convert to io.Writer?
 func (p *plist) Generate() (string, error) {
 	var tmpl bytes.Buffer
-	t := template.Must(template.New("launchdConfig").Parse(plistTemplate()))
+	t := template.Must(template.New("launchdConfig").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(plistTemplate()))
 	if err := t.Execute(&tmpl, p); err != nil {
 		return "", err
 	}
@@ -58,13 +140,25 @@ func (p *plist) Generate() (string, error) {
 
 func (p *plist) Path() string {
 	label := p.Label + ".plist"
-	if isRoot() {
+	if p.daemon {
 		return filepath.Join("/Library/LaunchDaemons/", label)
 	}
 
 	return filepath.Join(homeDir(), "Library/LaunchAgents/", label)
 }
 
+/*
+xmlEscape escapes s for the character data of the plist, so values
+containing e.g. "&" or "<" do not break the document
+*/
+func xmlEscape(s string) string {
+	var out bytes.Buffer
+	if err := xml.EscapeText(&out, []byte(s)); err != nil {
+		return ""
+	}
+	return out.String()
+}
+
 // func (p *plist) String() string {
 // 	encoded, _ := xml.MarshalIndent(p, "", "  ")
 // 	return string(encoded)
@@ -78,18 +172,54 @@ func plistTemplate() string {
 <!DOCTYPE plist PUBLIC \"-//Apple Computer//DTD PLIST 1.0//EN\" \"http://www.apple.com/DTDs/PropertyList-1.0.dtd\" >
 <plist version='1.0'>
   <dict>
-    <key>Label</key><string>{{ .Label }}</string>{{ if .Program }}
-    <key>Program</key><string>{{ .Program }}</string>{{ end }}
+    <key>Label</key><string>{{ .Label | xml }}</string>{{ if .Program }}
+    <key>Program</key><string>{{ .Program | xml }}</string>{{ end }}
     {{ if .ProgramArguments }}<key>ProgramArguments</key>
     <array>{{ range $arg := .ProgramArguments }}
-      <string>{{ $arg }}</string>{{ end }}
+      <string>{{ $arg | xml }}</string>{{ end }}
     </array>{{ end }}
     <key>StandardOutPath</key>
-    <string>{{ .StdOutPath }}</string>
+    <string>{{ .StdOutPath | xml }}</string>
     <key>StandardErrorPath</key>
-    <string>{{ .StdErrPath }}</string>
+    <string>{{ .StdErrPath | xml }}</string>
     <key>KeepAlive</key> <{{ .KeepAlive }}/>
-    <key>RunAtLoad</key> <{{ .RunAtLoad }}/>
+    <key>RunAtLoad</key> <{{ .RunAtLoad }}/>{{ if .EnvironmentVariables }}
+    <key>EnvironmentVariables</key>
+    <dict>{{ range $key, $value := .EnvironmentVariables }}
+      <key>{{ $key | xml }}</key><string>{{ $value | xml }}</string>{{ end }}
+    </dict>{{ end }}{{ if .ExitTimeOut }}
+    <key>ExitTimeOut</key><integer>{{ .ExitTimeOut }}</integer>{{ end }}{{ if .Sockets }}
+    <key>Sockets</key>
+    <dict>
+      <key>{{ .SocketsName | xml }}</key>
+      <array>{{ range .Sockets }}
+        <dict>
+          <key>SockType</key><string>{{ .Type | xml }}</string>{{ if .SockPathName }}
+          <key>SockPathName</key><string>{{ .SockPathName | xml }}</string>{{ end }}{{ if .SockNodeName }}
+          <key>SockNodeName</key><string>{{ .SockNodeName | xml }}</string>{{ end }}{{ if .SockServiceName }}
+          <key>SockServiceName</key><string>{{ .SockServiceName | xml }}</string>{{ end }}
+        </dict>{{ end }}
+      </array>
+    </dict>{{ end }}{{ if .Accept }}
+    <key>inetdCompatibility</key>
+    <dict>
+      <key>Wait</key><false/>
+    </dict>{{ end }}{{ if .StartCalendarInterval }}
+    <key>StartCalendarInterval</key>
+    <array>{{ range .StartCalendarInterval }}
+      <dict>{{ range $key, $value := . }}
+        <key>{{ $key | xml }}</key><integer>{{ $value }}</integer>{{ end }}
+      </dict>{{ end }}
+    </array>{{ end }}{{ if .StartInterval }}
+    <key>StartInterval</key><integer>{{ .StartInterval }}</integer>{{ end }}{{ if .WatchPaths }}
+    <key>WatchPaths</key>
+    <array>{{ range .WatchPaths }}
+      <string>{{ . | xml }}</string>{{ end }}
+    </array>{{ end }}{{ if .QueueDirectories }}
+    <key>QueueDirectories</key>
+    <array>{{ range .QueueDirectories }}
+      <string>{{ . | xml }}</string>{{ end }}
+    </array>{{ end }}
   </dict>
 </plist>
 `
@@ -0,0 +1,56 @@
+// +build darwin
+
+package systemservice
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlistEscaping(t *testing.T) {
+	assert := assert.New(t)
+
+	serv := New(ServiceCommand{
+		Label:       "app",
+		Name:        "app",
+		Program:     "/usr/bin/app",
+		Args:        []string{"--query", "a<b && c>d"},
+		Environment: map[string]string{"DSN": "host=db&user=<app>"},
+		Triggers:    []Trigger{{Type: TriggerPathChanged, Path: "/tmp/R&D"}},
+	})
+
+	p := newPlist(&serv)
+	content, err := p.Generate()
+	assert.NoError(err)
+	assert.Contains(content, "<key>DSN</key><string>host=db&amp;user=&lt;app&gt;</string>")
+
+	// The document parses and the values survive
+	var values []string
+	dec := xml.NewDecoder(strings.NewReader(content))
+	inString := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(err) {
+			return
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			inString = tok.Name.Local == "string"
+		case xml.CharData:
+			if inString {
+				values = append(values, string(tok))
+			}
+		case xml.EndElement:
+			inString = false
+		}
+	}
+
+	assert.Subset(values, []string{"a<b && c>d", "host=db&user=<app>", "/tmp/R&D"})
+}
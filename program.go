@@ -0,0 +1,70 @@
+package systemservice
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+/*
+defaultStopTimeout is how long Run waits for Program.Stop when no
+StopTimeout is configured
+*/
+const defaultStopTimeout = 20 * time.Second
+
+/*
+Program is the code run by the service, see SystemService.Run.
+*/
+type Program interface {
+	// Start is called when the service starts. It must not block:
+	// start the work in goroutines and return once the program is
+	// ready to serve, readiness is reported to the service manager
+	// when Start returns. The context is cancelled as soon as the
+	// service is asked to stop, right before Stop is called.
+	Start(ctx context.Context) error
+
+	// Stop is called when the service manager asks the service to
+	// stop and should wait for the work started by Start to finish.
+	// The context expires after the StopTimeout of the service
+	// command.
+	Stop(ctx context.Context) error
+}
+
+/*
+Reloader can optionally be implemented by a Program to reload its
+configuration on SIGHUP (or a parameter change request on Windows).
+*/
+type Reloader interface {
+	Reload(ctx context.Context) error
+}
+
+/*
+stopTimeout returns the configured stop timeout or the default
+*/
+func (c *ServiceCommand) stopTimeout() time.Duration {
+	if c.StopTimeout > 0 {
+		return c.StopTimeout
+	}
+	return defaultStopTimeout
+}
+
+/*
+stopProgram calls Stop on the program and waits at most the given
+timeout for it to return
+*/
+func stopProgram(p Program, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Stop(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("program did not stop within %s", timeout)
+	}
+}
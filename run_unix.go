@@ -0,0 +1,86 @@
+// +build linux darwin
+
+package systemservice
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+/*
+Run starts the program and blocks until the service is asked to stop
+with SIGTERM or SIGINT, then stops the program within the StopTimeout
+of the service command. SIGHUP reloads the program if it implements
+Reloader.
+
+When started by systemd, readiness, reloads and shutdown are reported
+over the notify socket and the watchdog is pinged while the program
+runs.
+
+Run returns nil if the program started and stopped cleanly, the
+caller should exit with a non-zero status otherwise.
+*/
+func (s *SystemService) Run(p Program) error {
+	notifier := NewNotifier()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s.log().Info("starting program", "name", s.Command.Name)
+
+	if err := p.Start(ctx); err != nil {
+		notifier.Status(fmt.Sprintf("failed to start: %v", err))
+		return err
+	}
+
+	if err := notifier.Ready(); err != nil {
+		s.log().Warn("error notifying readiness", "error", err)
+	}
+
+	go func() {
+		if err := notifier.RunWatchdog(ctx); err != nil {
+			s.log().Warn("error pinging watchdog", "error", err)
+		}
+	}()
+
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			s.log().Info("received signal, stopping program", "signal", sig)
+			break
+		}
+
+		reloader, ok := p.(Reloader)
+		if !ok {
+			s.log().Warn("program does not support reloading, ignoring", "signal", sig)
+			continue
+		}
+
+		s.log().Debug("reloading program")
+
+		notifier.Reloading()
+		if err := reloader.Reload(ctx); err != nil {
+			s.log().Warn("error reloading program", "error", err)
+			notifier.Status(fmt.Sprintf("failed to reload: %v", err))
+		}
+		notifier.Ready()
+	}
+
+	notifier.Stopping()
+	cancel()
+
+	if err := stopProgram(p, s.Command.stopTimeout()); err != nil {
+		s.log().Warn("error stopping program", "error", err)
+		return err
+	}
+
+	s.log().Debug("program stopped", "name", s.Command.Name)
+
+	return nil
+}
@@ -0,0 +1,87 @@
+// +build linux
+
+package systemservice
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testProgram struct {
+	started  chan struct{}
+	reloaded chan struct{}
+	stopErr  error
+	stopped  bool
+	ctx      context.Context
+}
+
+func (p *testProgram) Start(ctx context.Context) error {
+	p.ctx = ctx
+	close(p.started)
+	return nil
+}
+
+func (p *testProgram) Stop(ctx context.Context) error {
+	p.stopped = p.ctx.Err() != nil
+	return p.stopErr
+}
+
+func (p *testProgram) Reload(ctx context.Context) error {
+	p.reloaded <- struct{}{}
+	return nil
+}
+
+func runTestProgram(t *testing.T, p *testProgram) error {
+	serv := New(ServiceCommand{Name: "test"})
+
+	done := make(chan error)
+	go func() { done <- serv.Run(p) }()
+
+	<-p.started
+	syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+
+	select {
+	case <-p.reloaded:
+	case <-time.After(time.Second):
+		t.Fatal("program was not reloaded")
+	}
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("program was not stopped")
+	}
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	assert := assert.New(t)
+	conn, cleanup := listenNotifySocket(t)
+	defer cleanup()
+
+	p := &testProgram{started: make(chan struct{}), reloaded: make(chan struct{})}
+	assert.NoError(runTestProgram(t, p))
+	assert.True(p.stopped, "context should be cancelled before Stop")
+
+	assert.Equal("READY=1", readNotification(t, conn))
+	assert.Contains(readNotification(t, conn), "RELOADING=1")
+	assert.Equal("READY=1", readNotification(t, conn))
+	assert.Equal("STOPPING=1", readNotification(t, conn))
+}
+
+func TestRunStopError(t *testing.T) {
+	p := &testProgram{
+		started:  make(chan struct{}),
+		reloaded: make(chan struct{}),
+		stopErr:  errors.New("failed"),
+	}
+	assert.Error(t, runTestProgram(t, p))
+}
@@ -0,0 +1,137 @@
+package systemservice
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+Schedule runs the service periodically instead of keeping it running.
+A scheduled service defaults to ServiceTypeOneshot.
+
+With systemd this installs a companion <label>.timer unit, with launchd
+it sets StartCalendarInterval and StartInterval in the plist.
+*/
+type Schedule struct {
+	// Calendar event expressions, e.g. "daily" or "Mon..Fri 09:00",
+	// see ParseCalendar. launchd only supports expressions with
+	// minute precision which do not restrict the year.
+	OnCalendar []string
+
+	// Run the service this long after the system booted. launchd
+	// runs the service when it is loaded instead.
+	OnBootSec time.Duration
+
+	// Run the service this long after it was last started. With
+	// systemd, combine it with OnBootSec or OnCalendar so there is a
+	// first run to count from.
+	OnUnitActiveSec time.Duration
+
+	// Delay each run by a random time up to this duration to spread
+	// the load of many hosts. Only used by systemd.
+	RandomizedDelaySec time.Duration
+
+	// Whether or not to catch up on runs missed while the system was
+	// powered off. Only used by systemd, launchd always catches up.
+	Persistent bool
+}
+
+/*
+enabled returns whether or not the service runs on a schedule
+*/
+func (s Schedule) enabled() bool {
+	return len(s.OnCalendar) > 0 || s.OnBootSec > 0 || s.OnUnitActiveSec > 0
+}
+
+/*
+validate checks that the calendar expressions parse and the schedule
+has a trigger if any of its options are used
+*/
+func (s Schedule) validate() error {
+	for _, expr := range s.OnCalendar {
+		if _, err := ParseCalendar(expr); err != nil {
+			return err
+		}
+	}
+
+	if s.OnBootSec < 0 || s.OnUnitActiveSec < 0 || s.RandomizedDelaySec < 0 {
+		return fmt.Errorf("schedule durations must not be negative")
+	}
+
+	if !s.enabled() && (s.Persistent || s.RandomizedDelaySec > 0) {
+		return fmt.Errorf("schedule needs OnCalendar, OnBootSec or OnUnitActiveSec")
+	}
+
+	return nil
+}
+
+/*
+directives returns the [Timer] directives for systemd with the
+calendar expressions in their normalized form
+*/
+func (s Schedule) directives() ([]Directive, error) {
+	var out []Directive
+	for _, expr := range s.OnCalendar {
+		spec, err := ParseCalendar(expr)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Directive{Key: "OnCalendar", Value: spec.String()})
+	}
+	if s.OnBootSec > 0 {
+		out = append(out, Directive{Key: "OnBootSec", Value: timespan(s.OnBootSec)})
+	}
+	if s.OnUnitActiveSec > 0 {
+		out = append(out, Directive{Key: "OnUnitActiveSec", Value: timespan(s.OnUnitActiveSec)})
+	}
+	if s.RandomizedDelaySec > 0 {
+		out = append(out, Directive{Key: "RandomizedDelaySec", Value: timespan(s.RandomizedDelaySec)})
+	}
+	if s.Persistent {
+		out = append(out, Directive{Key: "Persistent", Value: "yes"})
+	}
+	return out, nil
+}
+
+/*
+launchdIntervals returns the StartCalendarInterval entries of all
+calendar expressions
+*/
+func (s Schedule) launchdIntervals() ([]map[string]int, error) {
+	var out []map[string]int
+	for _, expr := range s.OnCalendar {
+		spec, err := ParseCalendar(expr)
+		if err != nil {
+			return nil, err
+		}
+		intervals, err := spec.launchdIntervals()
+		if err != nil {
+			return nil, &UnsupportedOptionError{
+				Backend: "launchd",
+				Option:  fmt.Sprintf("OnCalendar=%s", expr),
+				Reason:  err.Error(),
+			}
+		}
+		out = append(out, intervals...)
+	}
+	return out, nil
+}
+
+/*
+next returns the next time one of the calendar expressions elapses,
+or the zero time if there is none
+*/
+func (s Schedule) next(after time.Time) time.Time {
+	var next time.Time
+	for _, expr := range s.OnCalendar {
+		spec, err := ParseCalendar(expr)
+		if err != nil {
+			continue
+		}
+		t := spec.Next(after)
+		if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next
+}
@@ -0,0 +1,54 @@
+package systemservice
+
+/*
+Scope is the service manager instance a service is installed to: the
+system wide one or the one of the current user
+*/
+type Scope string
+
+const (
+	// ScopeSystem services are managed by the system instance of the
+	// service manager and usually require root.
+	ScopeSystem Scope = "system"
+
+	// ScopeUser services are managed by the service manager instance
+	// of the current user.
+	ScopeUser Scope = "user"
+)
+
+/*
+currentScope returns the scope services are installed to by the
+current process
+*/
+func currentScope() Scope {
+	if isRoot() {
+		return ScopeSystem
+	}
+	return ScopeUser
+}
+
+/*
+scope returns the scope the service is installed to: the one set with
+WithScope or the one of the current process. Services installed below
+a Root are system services.
+*/
+func (s *SystemService) scope() Scope {
+	if s.Command.Root != "" {
+		return ScopeSystem
+	}
+	if s.scopeOption != "" {
+		return s.scopeOption
+	}
+	return currentScope()
+}
+
+/*
+defaultTarget returns the target services of the scope are enabled
+for, multi-user.target does not exist in user service managers
+*/
+func (s Scope) defaultTarget() string {
+	if s == ScopeUser {
+		return "default.target"
+	}
+	return "multi-user.target"
+}
@@ -0,0 +1,183 @@
+package systemservice
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/danawoodman/systemservice/unit"
+)
+
+/*
+Section is a "[Name]" section of a systemd unit file, see unit.Section
+*/
+type Section = unit.Section
+
+/*
+Directive is a single "Key=Value" assignment of a unit file section,
+see unit.Directive
+*/
+type Directive = unit.Directive
+
+/*
+companionSections are the sections of Extra which belong to a
+companion unit, keyed by section name, with the option that creates
+the unit
+*/
+var companionSections = map[string]string{
+	"Socket": "Sockets",
+	"Timer":  "Schedule",
+	"Path":   "Triggers",
+}
+
+/*
+validateExtra checks that the extra sections can be written and that
+the companion units they belong to are generated
+*/
+func (c *ServiceCommand) validateExtra() error {
+	for _, s := range c.Extra {
+		if err := s.Validate(); err != nil {
+			return fmt.Errorf("Extra: %v", err)
+		}
+
+		option, ok := companionSections[s.Name]
+		if !ok {
+			continue
+		}
+		if (s.Name == "Socket" && !c.Sockets.enabled()) ||
+			(s.Name == "Timer" && !c.Schedule.enabled()) ||
+			(s.Name == "Path" && len(c.Triggers) == 0) {
+			return fmt.Errorf("Extra section [%s] requires %s to be set", s.Name, option)
+		}
+	}
+	return nil
+}
+
+/*
+extraSections returns the extra sections of the companion unit with
+the given section, or those of the service unit if section is empty
+*/
+func (c *ServiceCommand) extraSections(section string) []Section {
+	var out []Section
+	for _, s := range c.Extra {
+		_, companion := companionSections[s.Name]
+		if (section == "" && !companion) || (section != "" && s.Name == section) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+/*
+parseSections parses the sections of a unit file
+*/
+func parseSections(content string) ([]Section, error) {
+	f, err := unit.Parse(strings.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	return f.Sections, nil
+}
+
+/*
+formatSections writes the sections in unit file syntax
+*/
+func formatSections(sections []Section) string {
+	f := &unit.File{Sections: sections}
+	return f.String()
+}
+
+/*
+listDirectives are the directives which may be assigned several
+times, each assignment adding to the list instead of replacing the
+previous value. Condition and Assert directives are lists as well.
+*/
+var listDirectives = map[string]bool{
+	"After": true, "Before": true, "Requires": true, "Requisite": true,
+	"Wants": true, "BindsTo": true, "PartOf": true, "Conflicts": true,
+	"OnFailure": true, "OnSuccess": true, "Documentation": true,
+	"WantedBy": true, "RequiredBy": true, "Also": true, "Alias": true,
+	"Environment": true, "EnvironmentFile": true, "PassEnvironment": true,
+	"ExecCondition": true, "ExecStartPre": true, "ExecStart": true,
+	"ExecStartPost": true, "ExecReload": true, "ExecStop": true,
+	"ExecStopPost": true, "ReadWritePaths": true, "ReadOnlyPaths": true,
+	"InaccessiblePaths": true, "BindPaths": true, "BindReadOnlyPaths": true,
+	"SystemCallFilter": true, "RestrictAddressFamilies": true,
+	"CapabilityBoundingSet": true, "AmbientCapabilities": true,
+	"DeviceAllow": true, "SupplementaryGroups": true,
+	"StateDirectory": true, "CacheDirectory": true, "LogsDirectory": true,
+	"RuntimeDirectory": true, "ConfigurationDirectory": true,
+	"ListenStream": true, "ListenDatagram": true,
+	"ListenSequentialPacket": true, "OnCalendar": true,
+	"OnBootSec": true, "OnUnitActiveSec": true, "PathExists": true,
+	"PathExistsGlob": true, "PathChanged": true, "PathModified": true,
+	"DirectoryNotEmpty": true,
+}
+
+/*
+isListDirective returns whether or not assignments of the key add to
+a list
+*/
+func isListDirective(key string) bool {
+	return listDirectives[key] || strings.HasPrefix(key, "Condition") || strings.HasPrefix(key, "Assert")
+}
+
+/*
+mergeSections applies drop-ins to a unit file the way systemd does:
+the files are applied in order, list directives are appended to,
+other directives are replaced and an empty assignment resets the
+directive.
+*/
+func mergeSections(files ...[]Section) []Section {
+	var merged []Section
+
+	find := func(name string) *Section {
+		for i := range merged {
+			if merged[i].Name == name {
+				return &merged[i]
+			}
+		}
+		merged = append(merged, Section{Name: name})
+		return &merged[len(merged)-1]
+	}
+
+	for _, sections := range files {
+		for _, s := range sections {
+			section := find(s.Name)
+
+			for _, d := range s.Directives {
+				if d.Value == "" || !isListDirective(d.Key) {
+					section.Directives = withoutDirective(section.Directives, d.Key)
+				}
+				if d.Value != "" {
+					section.Directives = append(section.Directives, d)
+				}
+			}
+		}
+	}
+
+	return merged
+}
+
+/*
+withoutDirective returns the directives without any assignment of key
+*/
+func withoutDirective(directives []Directive, key string) []Directive {
+	var out []Directive
+	for _, d := range directives {
+		if d.Key != key {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+/*
+validateOverrideName checks that the drop-in name can be used as a
+file name
+*/
+func validateOverrideName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid override name %q", name)
+	}
+	return nil
+}
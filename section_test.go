@@ -0,0 +1,90 @@
+package systemservice
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatSections(t *testing.T) {
+	assert := assert.New(t)
+
+	sections := []Section{
+		{Name: "Service", Directives: []Directive{{Key: "ExecStart", Value: ""}, {Key: "ExecStart", Value: "/bin/app --verbose"}}},
+		{Name: "Install", Directives: []Directive{{Key: "WantedBy", Value: "multi-user.target"}}},
+	}
+
+	content := formatSections(sections)
+
+	assert.Equal("[Service]\nExecStart=\nExecStart=/bin/app --verbose\n\n[Install]\nWantedBy=multi-user.target\n", content)
+
+	parsed, err := parseSections(content)
+	assert.NoError(err)
+	assert.Equal(content, formatSections(parsed))
+}
+
+func TestMergeSections(t *testing.T) {
+	assert := assert.New(t)
+
+	unit := []Section{
+		{Name: "Unit", Directives: []Directive{{Key: "After", Value: "network.target"}}},
+		{Name: "Service", Directives: []Directive{
+			{Key: "ExecStart", Value: "/bin/app"},
+			{Key: "Restart", Value: "on-failure"},
+			{Key: "Environment", Value: "A=1"},
+		}},
+	}
+
+	dropIn := []Section{
+		{Name: "Unit", Directives: []Directive{{Key: "After", Value: "postgresql.service"}}},
+		{Name: "Service", Directives: []Directive{
+			{Key: "ExecStart", Value: ""},
+			{Key: "ExecStart", Value: "/bin/app --verbose"},
+			{Key: "Restart", Value: "always"},
+			{Key: "Environment", Value: "B=2"},
+		}},
+		{Name: "Install", Directives: []Directive{{Key: "WantedBy", Value: "multi-user.target"}}},
+	}
+
+	assert.Equal([]Section{
+		{Name: "Unit", Directives: []Directive{
+			{Key: "After", Value: "network.target"},
+			{Key: "After", Value: "postgresql.service"},
+		}},
+		{Name: "Service", Directives: []Directive{
+			{Key: "Environment", Value: "A=1"},
+			{Key: "ExecStart", Value: "/bin/app --verbose"},
+			{Key: "Restart", Value: "always"},
+			{Key: "Environment", Value: "B=2"},
+		}},
+		{Name: "Install", Directives: []Directive{{Key: "WantedBy", Value: "multi-user.target"}}},
+	}, mergeSections(unit, dropIn))
+}
+
+func TestSectionValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(Section{Name: "Service", Directives: []Directive{{Key: "LimitNOFILE", Value: "65536"}}}.Validate())
+	assert.Error(Section{Directives: []Directive{{Key: "LimitNOFILE", Value: "65536"}}}.Validate())
+	assert.Error(Section{Name: "Service", Directives: []Directive{{Key: "Limit NOFILE", Value: "65536"}}}.Validate())
+	assert.Error(Section{Name: "Service", Directives: []Directive{{Key: "ExecStart", Value: "/bin/app\n[Install]"}}}.Validate())
+
+	assert.NoError(validateOverrideName("10-limits"))
+	assert.Error(validateOverrideName(""))
+	assert.Error(validateOverrideName("../escape"))
+	assert.Error(validateOverrideName(".hidden"))
+}
+
+func TestOpenIsNotOwned(t *testing.T) {
+	assert := assert.New(t)
+
+	serv := Open("nginx.service", WithBackend(platformBackend))
+
+	assert.Equal("nginx", serv.Command.Label)
+	assert.IsType(&ServiceNotOwnedError{}, serv.owned())
+	assert.IsType(&ServiceNotOwnedError{}, serv.Install(false))
+	assert.IsType(&ServiceNotOwnedError{}, serv.Uninstall())
+
+	owned := New(ServiceCommand{Label: "nginx"})
+	assert.NoError(owned.owned())
+}
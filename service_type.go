@@ -0,0 +1,83 @@
+package systemservice
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+/*
+ServiceType describes how the service process starts up and signals
+that it is ready, see Type= in systemd.service(5).
+*/
+type ServiceType string
+
+const (
+	// ServiceTypeSimple considers the service started as soon as the
+	// process has been forked. This is the default.
+	ServiceTypeSimple ServiceType = "simple"
+
+	// ServiceTypeExec considers the service started once the program
+	// has been executed successfully.
+	ServiceTypeExec ServiceType = "exec"
+
+	// ServiceTypeNotify waits for the service to send READY=1, see
+	// Notifier.
+	ServiceTypeNotify ServiceType = "notify"
+
+	// ServiceTypeNotifyReload is like ServiceTypeNotify but reloads
+	// the service with SIGHUP and waits for it to send RELOADING=1
+	// and READY=1 again.
+	ServiceTypeNotifyReload ServiceType = "notify-reload"
+
+	// ServiceTypeForking expects the program to fork into the
+	// background and exit. Set PIDFile so the main process can be
+	// tracked.
+	ServiceTypeForking ServiceType = "forking"
+
+	// ServiceTypeOneshot runs the program to completion, e.g. for
+	// migrations. It is not restarted on failure. Set RemainAfterExit
+	// to keep the service active after the program exits.
+	ServiceTypeOneshot ServiceType = "oneshot"
+
+	// ServiceTypeIdle is like ServiceTypeSimple but delays the start
+	// until all other jobs are dispatched.
+	ServiceTypeIdle ServiceType = "idle"
+)
+
+/*
+validateType checks that the type is known and the type specific options
+are used with the right type
+*/
+func (c *ServiceCommand) validateType() error {
+	switch c.Type {
+	case "", ServiceTypeSimple, ServiceTypeExec, ServiceTypeNotify, ServiceTypeNotifyReload,
+		ServiceTypeForking, ServiceTypeOneshot, ServiceTypeIdle:
+	default:
+		return fmt.Errorf("unknown service type %q", c.Type)
+	}
+
+	if c.PIDFile != "" {
+		if c.Type != ServiceTypeForking {
+			return fmt.Errorf("PIDFile can only be used with %s services", ServiceTypeForking)
+		}
+		if !filepath.IsAbs(c.PIDFile) {
+			return fmt.Errorf("PIDFile %q must be an absolute path", c.PIDFile)
+		}
+	}
+
+	return nil
+}
+
+/*
+serviceType returns the type of the service, defaulting to oneshot for
+scheduled and triggered services and simple otherwise
+*/
+func (c *ServiceCommand) serviceType() ServiceType {
+	if c.Type != "" {
+		return c.Type
+	}
+	if c.Schedule.enabled() || len(c.Triggers) > 0 {
+		return ServiceTypeOneshot
+	}
+	return ServiceTypeSimple
+}
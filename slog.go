@@ -0,0 +1,36 @@
+// +build go1.21
+
+package systemservice
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+/*
+NewSlogLogger adapts a log/slog handler to the LeveledLogger interface,
+e.g. slog.NewJSONHandler(os.Stderr, nil) for JSON output. The fields of
+an event become attributes of the record.
+*/
+func NewSlogLogger(h slog.Handler) LeveledLogger {
+	return &slogLogger{handler: h}
+}
+
+type slogLogger struct {
+	handler slog.Handler
+}
+
+func (l *slogLogger) Enabled(level Level) bool {
+	return l.handler.Enabled(context.Background(), slog.Level(level))
+}
+
+func (l *slogLogger) Emit(level Level, msg string, fields []Field) {
+	r := slog.NewRecord(time.Now(), slog.Level(level), msg, 0)
+	for _, f := range fields {
+		r.AddAttrs(slog.Any(f.Key, f.Value))
+	}
+
+	// Like slog.Logger, errors of the handler are dropped
+	_ = l.handler.Handle(context.Background(), r)
+}
@@ -0,0 +1,40 @@
+// +build go1.21
+
+package systemservice
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogLogger(t *testing.T) {
+	assert := assert.New(t)
+
+	var buf bytes.Buffer
+	out := NewSlogLogger(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	assert.False(out.Enabled(LevelDebug))
+	assert.True(out.Enabled(LevelWarn))
+
+	serv := New(ServiceCommand{Label: "app"}, WithLeveledLogger(out))
+	serv.log().Debug("dropped")
+	serv.log().Warn("error stopping unit", "unit", "app.service", "error", errors.New("exit status 1"))
+
+	var event map[string]interface{}
+	assert.NoError(json.Unmarshal(buf.Bytes(), &event))
+	delete(event, "time")
+
+	assert.Equal(map[string]interface{}{
+		"level":   "WARN",
+		"msg":     "error stopping unit",
+		"label":   "app",
+		"backend": string(platformBackend),
+		"unit":    "app.service",
+		"error":   "exit status 1",
+	}, event)
+}
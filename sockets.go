@@ -0,0 +1,139 @@
+package systemservice
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/*
+Sockets configures socket activation: the service manager listens on
+the sockets and starts the service on the first connection, passing
+the open sockets on to it (see Listeners). The sockets stay open
+while the service restarts, so no connection is refused.
+
+Every address is a port ("8080"), a host and port ("127.0.0.1:8080",
+"[::1]:8080") or the absolute path of a unix socket
+("/run/my-service.sock").
+
+Used by systemd, which installs a companion <label>.socket unit, and
+launchd, which gets a Sockets entry in the plist.
+*/
+type Sockets struct {
+	// Addresses to listen on for stream (TCP or unix stream)
+	// connections.
+	ListenStream []string
+
+	// Addresses to listen on for datagrams (UDP or unix datagram).
+	ListenDatagram []string
+
+	// Unix socket paths to listen on for sequential packets.
+	ListenSequentialPacket []string
+
+	// Whether or not a new instance of the service is started for
+	// each connection, with the connection as its standard input and
+	// output. With systemd this installs the service as a
+	// <label>@.service template.
+	Accept bool
+
+	// The name the sockets are passed to the service with, see
+	// ListenersWithNames. Optional, systemd defaults to the name of
+	// the socket unit.
+	Name string
+}
+
+/*
+enabled returns whether or not any socket is configured
+*/
+func (s Sockets) enabled() bool {
+	return len(s.ListenStream)+len(s.ListenDatagram)+len(s.ListenSequentialPacket) > 0
+}
+
+/*
+validate checks that all addresses can be parsed
+*/
+func (s Sockets) validate() error {
+	for _, l := range s.listeners() {
+		if l.SockPathName == "" && !isPort(l.SockServiceName) {
+			return fmt.Errorf("invalid socket address %q", l.Address)
+		}
+		if l.Type == "seqpacket" && l.SockPathName == "" {
+			return fmt.Errorf("sequential packet socket %q must be a unix socket path", l.Address)
+		}
+	}
+	if strings.ContainsAny(s.Name, ": \t\n") {
+		return fmt.Errorf("invalid socket name %q", s.Name)
+	}
+	return nil
+}
+
+/*
+directives returns the [Socket] directives for systemd
+*/
+func (s Sockets) directives() []Directive {
+	var out []Directive
+	for _, a := range s.ListenStream {
+		out = append(out, Directive{Key: "ListenStream", Value: a})
+	}
+	for _, a := range s.ListenDatagram {
+		out = append(out, Directive{Key: "ListenDatagram", Value: a})
+	}
+	for _, a := range s.ListenSequentialPacket {
+		out = append(out, Directive{Key: "ListenSequentialPacket", Value: a})
+	}
+	if s.Accept {
+		out = append(out, Directive{Key: "Accept", Value: "yes"})
+	}
+	if s.Name != "" {
+		out = append(out, Directive{Key: "FileDescriptorName", Value: s.Name})
+	}
+	return out
+}
+
+/*
+socketListener is a single listening socket in the terms of a launchd
+Sockets dictionary
+*/
+type socketListener struct {
+	Address         string
+	Type            string
+	SockPathName    string
+	SockNodeName    string
+	SockServiceName string
+}
+
+/*
+listeners splits the addresses into launchd socket dictionaries
+*/
+func (s Sockets) listeners() []socketListener {
+	var out []socketListener
+	add := func(sockType string, addresses []string) {
+		for _, a := range addresses {
+			l := socketListener{Address: a, Type: sockType}
+			if strings.HasPrefix(a, "/") {
+				l.SockPathName = a
+			} else if host, port, err := net.SplitHostPort(a); err == nil {
+				l.SockNodeName = host
+				l.SockServiceName = port
+			} else if isPort(a) {
+				l.SockServiceName = a
+			}
+			out = append(out, l)
+		}
+	}
+
+	add("stream", s.ListenStream)
+	add("dgram", s.ListenDatagram)
+	add("seqpacket", s.ListenSequentialPacket)
+
+	return out
+}
+
+/*
+isPort returns whether or not the string is a valid port number
+*/
+func isPort(s string) bool {
+	port, err := strconv.Atoi(s)
+	return err == nil && port > 0 && port < 65536
+}
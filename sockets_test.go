@@ -0,0 +1,56 @@
+package systemservice
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSocketListeners(t *testing.T) {
+	assert := assert.New(t)
+
+	sockets := Sockets{
+		ListenStream:           []string{"8080", "127.0.0.1:9090", "[::1]:9091", "/run/app.sock"},
+		ListenDatagram:         []string{"5353"},
+		ListenSequentialPacket: []string{"/run/app.seq"},
+	}
+
+	assert.NoError(sockets.validate())
+	assert.Equal([]socketListener{
+		{Address: "8080", Type: "stream", SockServiceName: "8080"},
+		{Address: "127.0.0.1:9090", Type: "stream", SockNodeName: "127.0.0.1", SockServiceName: "9090"},
+		{Address: "[::1]:9091", Type: "stream", SockNodeName: "::1", SockServiceName: "9091"},
+		{Address: "/run/app.sock", Type: "stream", SockPathName: "/run/app.sock"},
+		{Address: "5353", Type: "dgram", SockServiceName: "5353"},
+		{Address: "/run/app.seq", Type: "seqpacket", SockPathName: "/run/app.seq"},
+	}, sockets.listeners())
+}
+
+func TestSocketsValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Error(Sockets{ListenStream: []string{"relative.sock"}}.validate())
+	assert.Error(Sockets{ListenSequentialPacket: []string{"8080"}}.validate())
+	assert.Error(Sockets{ListenStream: []string{"8080"}, Name: "a:b"}.validate())
+}
+
+func TestListenFds(t *testing.T) {
+	assert := assert.New(t)
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	os.Setenv("LISTEN_FDS", "2")
+	os.Setenv("LISTEN_FDNAMES", "http:metrics")
+
+	count, names := listenFds()
+	assert.Equal(2, count)
+	assert.Equal([]string{"http", "metrics"}, names)
+
+	os.Setenv("LISTEN_PID", "1")
+	count, _ = listenFds()
+	assert.Equal(0, count, "sockets meant for another process")
+}
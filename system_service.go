@@ -2,24 +2,97 @@ package systemservice
 
 import (
 	"os"
-	"os/exec"
 	"os/user"
 	"strings"
+	"time"
+
+	"github.com/spf13/afero"
 )
 
 /*
 New creates a new system service manager instance.
 */
-func New(cmd ServiceCommand) SystemService {
+func New(cmd ServiceCommand, opts ...Option) SystemService {
 	serv := SystemService{Command: cmd}
+	for _, opt := range opts {
+		opt(&serv)
+	}
+	return serv
+}
+
+/*
+Open returns a system service manager instance for a service which
+is already installed on the system, e.g. by a distribution package.
+The service can be started, stopped and overridden but it is not
+owned: Install and Uninstall refuse to touch it. On Linux the label
+is the name of the unit, with or without the ".service" suffix.
+*/
+func Open(label string, opts ...Option) SystemService {
+	label = strings.TrimSuffix(label, ".service")
+	serv := New(ServiceCommand{Name: label, Label: label}, opts...)
+	serv.external = true
 	return serv
 }
 
 /*
-SystemService represents a generic system service configuration
+Service is the lifecycle of a system service. It is implemented by
+SystemService and, for tests of code managing services, by the
+in-memory fake of the systemservicetest package.
+*/
+type Service interface {
+	Install(start bool) error
+	Start() error
+	Restart() error
+	Stop() error
+	Uninstall() error
+	Status() (*ServiceStatus, error)
+	Running() (bool, error)
+	Exists() bool
+}
+
+var _ Service = &SystemService{}
+
+/*
+SystemService represents a generic system service configuration. It is
+safe for concurrent use, changes to the same service are serialized.
 */
 type SystemService struct {
 	Command ServiceCommand
+
+	// The file system the service files are read from and written
+	// to, e.g. afero.NewMemMapFs() in tests. Optional, defaults to
+	// the file system of the OS.
+	FS afero.Fs
+
+	logger        LeveledLogger
+	runner        Runner
+	clock         Clock
+	scopeOption   Scope
+	backendOption Backend
+	detectionFS   afero.Fs
+
+	// The unit manager of the systemd backend, e.g. a fake in tests.
+	// Optional, see newUnitManager.
+	units unitManager
+
+	// How long a started service has to keep running before Install
+	// considers it started. Optional, defaults to a second.
+	startCheckDelay time.Duration
+
+	// Whether or not the service was opened instead of created from
+	// a command
+	external bool
+}
+
+/*
+owned returns an error if the service was opened with Open and must
+not be installed or uninstalled
+*/
+func (s *SystemService) owned() error {
+	if s.external {
+		return &ServiceNotOwnedError{serviceName: s.Command.Label}
+	}
+	return nil
 }
 
 /*
@@ -40,6 +113,9 @@ type ServiceCommand struct {
 	// The arguments to pass to the command. Optional.
 	Args []string
 
+	// The environment variables to set for the command. Optional.
+	Environment map[string]string
+
 	// The description of your service. Optional.
 	Description string
 
@@ -48,6 +124,82 @@ type ServiceCommand struct {
 
 	// Whether or not to turn on debug behavior
 	Debug bool
+
+	// How the service starts up and signals readiness. Optional,
+	// defaults to ServiceTypeSimple.
+	Type ServiceType
+
+	// The file a ServiceTypeForking program writes its PID to.
+	PIDFile string
+
+	// Whether or not the service stays active after the program
+	// exited. Mostly useful with ServiceTypeOneshot.
+	RemainAfterExit bool
+
+	// The watchdog timeout. When set, systemd restarts the service if
+	// it does not ping the watchdog within this interval, see
+	// Notifier.RunWatchdog. Only used by systemd. Optional.
+	WatchdogSec time.Duration
+
+	// How long the program is given to stop before it is killed.
+	// Optional, defaults to 20 seconds.
+	StopTimeout time.Duration
+
+	// The sockets the service manager listens on on behalf of the
+	// service, starting it on the first connection. Optional.
+	Sockets Sockets
+
+	// Run the service periodically instead of keeping it running.
+	// Optional.
+	Schedule Schedule
+
+	// Run the service when files appear or change instead of keeping
+	// it running. Triggered services default to ServiceTypeOneshot.
+	// Optional.
+	Triggers []Trigger
+
+	// Sandboxing applied to the service. Only used by systemd.
+	// Optional, defaults to no sandboxing.
+	Hardening Hardening
+
+	// The system user and group to run the service as. Only used by
+	// systemd at system scope. Optional, defaults to root.
+	User  string
+	Group string
+
+	// Whether or not to create User as a system account at install
+	// time, using a sysusers.d fragment. The account is kept when the
+	// service is uninstalled.
+	CreateUser bool
+
+	// Whether or not systemd should allocate a transient user for
+	// each run of the service (DynamicUser=). Combine this with
+	// Directories to keep state between runs.
+	DynamicUser bool
+
+	// The directories systemd creates and owns on behalf of the
+	// service. OpenRC creates them before starting the service.
+	// Optional.
+	Directories Directories
+
+	// The ordering and requirement dependencies on other units, mapped
+	// to the dependencies of init scripts and OpenRC services.
+	// Optional, defaults to starting after the network.
+	Dependencies Dependencies
+
+	// Raw directives added to the generated systemd units, after the
+	// generated directives of the same section. Sections named Socket,
+	// Timer or Path go to the companion unit of Sockets, Schedule or
+	// Triggers, all others to the service unit. Only used by systemd.
+	// Optional.
+	Extra []Section
+
+	// Install below this folder instead of "/", e.g. into a container
+	// image or chroot being built. The service is installed as a
+	// system service and enabled offline by creating the symlinks of
+	// its [Install] section, no service manager is contacted. Only
+	// used by systemd. Optional.
+	Root string
 }
 
 func (c *ServiceCommand) String() string {
@@ -58,12 +210,43 @@ func (c *ServiceCommand) String() string {
 	return s
 }
 
+/*
+validate checks the parts of the command which are common to all
+platforms
+*/
+func (c *ServiceCommand) validate() error {
+	if err := c.validateType(); err != nil {
+		return err
+	}
+	if err := c.Hardening.validate(); err != nil {
+		return err
+	}
+	if err := c.Sockets.validate(); err != nil {
+		return err
+	}
+	if err := c.Schedule.validate(); err != nil {
+		return err
+	}
+	if err := c.validateTriggers(); err != nil {
+		return err
+	}
+	if err := c.validateExtra(); err != nil {
+		return err
+	}
+	return c.validateAccount()
+}
+
 /*
 ServiceStatus is a generic representation of the service running on the system
 */
 type ServiceStatus struct {
 	Running bool
 	PID     int
+
+	// The next and last time a scheduled service is triggered. Zero
+	// if unknown or the service has no Schedule.
+	NextTrigger time.Time
+	LastTrigger time.Time
 }
 
 /*
@@ -79,14 +262,6 @@ func (s *SystemService) Running() (bool, error) {
 	return status.Running, nil
 }
 
-/*
-runCommand is a lightweight wrapper around exec.Command
-*/
-func runCommand(name string, args ...string) (out string, err error) {
-	stdout, err := exec.Command(name, args...).Output()
-	return string(stdout), err
-}
-
 /*
 isRoot returns whether or not the program was run as root
 
@@ -113,7 +288,7 @@ func homeDir() string {
 	u, err := user.Current()
 
 	if err != nil {
-		logger.Log("User does not have a home directory!")
+		defaultLog().Warn("user does not have a home directory")
 		return "/"
 	}
 
@@ -137,8 +312,8 @@ func username() string {
 fileExists is a helper to return whether or not a give
 file exists
 */
-func fileExists(filename string) bool {
-	info, err := appFS.Stat(filename)
+func fileExists(fs afero.Fs, filename string) bool {
+	info, err := fs.Stat(filename)
 	if os.IsNotExist(err) {
 		return false
 	}
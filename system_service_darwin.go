@@ -3,35 +3,65 @@
 package systemservice
 
 import (
-	"io/ioutil"
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+
+	"github.com/spf13/afero"
 )
 
 /*
-Run is a no-op on Darwin based systems
+platformBackend is the service manager of the platform
 */
-func (s *SystemService) Run() error {
-	return nil
+const platformBackend = BackendLaunchd
+
+/*
+platformSupports returns whether or not the backend is available on
+the platform
+*/
+func platformSupports(b Backend) bool {
+	return b == platformBackend
 }
 
 /*
 Install the system service. If start is passed, also starts
-the service.
+the service. The plist is replaced atomically and if the service
+cannot be started, the previous plist is restored and an InstallError
+returned.
 */
 func (s *SystemService) Install(start bool) error {
-	plist := newPlist(s)
+	unlock, err := s.lock("install")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	path := plist.Path()
-	dir := filepath.Dir(path)
+	return s.install(start)
+}
 
-	logger.Log("making sure folder exists: ", dir)
+/*
+install is Install for callers holding the lock of the service
+*/
+func (s *SystemService) install(start bool) error {
+	if err := s.owned(); err != nil {
+		return err
+	}
 
-	os.MkdirAll(dir, os.ModePerm)
+	if err := s.Command.validate(); err != nil {
+		return err
+	}
 
-	logger.Log("generating plist file")
+	if err := validatePlist(s.Command); err != nil {
+		return err
+	}
+
+	plist := newPlist(s)
+	path := plist.Path()
+
+	s.log().Debug("generating plist file")
 
 	content, err := plist.Generate()
 
@@ -39,44 +69,115 @@ func (s *SystemService) Install(start bool) error {
 		return err
 	}
 
-	logger.Log("writing plist to: ", path)
+	wasRunning := false
+	if start {
+		if status, err := s.Status(); err == nil {
+			wasRunning = status.Running
+		}
+	}
 
-	err = ioutil.WriteFile(path, []byte(content), 0644)
+	tx := s.newTransaction()
 
-	if err != nil {
+	s.log().Debug("writing plist", "path", path)
+
+	if err := tx.writeFile(path, []byte(content), 0644); err != nil {
 		return err
 	}
 
-	logger.Log("wrote plist:\n", content)
+	s.log().Debug("wrote plist", "content", content)
 
 	if start {
-		err := s.Start()
+		err := s.start()
+
+		if err == nil {
+			err = s.verifyStarted()
+		}
+
 		if err != nil {
-			return err
+			s.log().Error("install failed, rolling back", "error", err)
+			return &InstallError{Err: err, RollbackErr: s.rollback(tx, wasRunning)}
 		}
 	}
 
 	return nil
 }
 
+/*
+verifyStarted returns an error if a service launchd keeps alive is not
+running shortly after it was loaded
+*/
+func (s *SystemService) verifyStarted() error {
+	cmd := s.Command
+	if cmd.serviceType() == ServiceTypeOneshot || cmd.activated() {
+		return nil
+	}
+
+	s.startCheck()
+
+	status, err := s.Status()
+
+	if err != nil {
+		return err
+	}
+
+	if !status.Running {
+		return fmt.Errorf("%s is not running after loading it", cmd.Label)
+	}
+
+	return nil
+}
+
+/*
+rollback unloads the new plist, restores the previous one and loads
+it again if the service was running before
+*/
+func (s *SystemService) rollback(tx *transaction, wasRunning bool) error {
+	if err := s.stop(); err != nil {
+		s.log().Warn("error unloading plist", "error", err)
+	}
+
+	if err := tx.rollback(); err != nil {
+		return err
+	}
+
+	if wasRunning {
+		return s.start()
+	}
+
+	return nil
+}
+
 /*
 Start the system service if it is installed
 */
 func (s *SystemService) Start() error {
+	unlock, err := s.lock("start")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.start()
+}
+
+/*
+start is Start for callers holding the lock of the service
+*/
+func (s *SystemService) start() error {
 	plist := newPlist(s)
 
-	logger.Log("loading plist with launchctl")
+	s.log().Info("loading plist with launchctl")
 
-	_, err := runLaunchCtlCommand("load", "-w", plist.Path())
+	_, err := s.launchctl("load", "-w", plist.Path())
 
 	if err != nil {
 		e := strings.ToLower(err.Error())
 
 		// If not installed, install the service and then run start again.
 		if strings.Contains(e, "no such file or directory") {
-			logger.Log("service not installed yet, installing...")
+			s.log().Info("service not installed yet, installing")
 
-			err = s.Install(true)
+			err = s.install(true)
 
 			if err != nil {
 				return err
@@ -86,7 +187,7 @@ func (s *SystemService) Start() error {
 		// We don't care if the process fails because it is already
 		// loaded
 		if strings.Contains(e, "service already loaded") {
-			logger.Log("service already loaded")
+			s.log().Debug("service already loaded")
 			return nil
 		}
 
@@ -100,13 +201,19 @@ func (s *SystemService) Start() error {
 Restart attempts to stop the service if running then starts it again
 */
 func (s *SystemService) Restart() error {
-	err := s.Stop()
+	unlock, err := s.lock("restart")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = s.stop()
 
 	if err != nil {
 		return err
 	}
 
-	err = s.Start()
+	err = s.start()
 
 	if err != nil {
 		return err
@@ -119,20 +226,33 @@ func (s *SystemService) Restart() error {
 Stop stops the system service by unloading the plist file
 */
 func (s *SystemService) Stop() error {
+	unlock, err := s.lock("stop")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.stop()
+}
+
+/*
+stop is Stop for callers holding the lock of the service
+*/
+func (s *SystemService) stop() error {
 	plist := newPlist(s)
 
-	_, err := runLaunchCtlCommand("unload", "-w", plist.Path())
+	_, err := s.launchctl("unload", "-w", plist.Path())
 
 	if err != nil {
 		e := strings.ToLower(err.Error())
 
 		if strings.Contains(e, "could not find specified service") {
-			logger.Log("no service matching plist running: ", plist.Label)
+			s.log().Debug("no service matching plist running")
 			return nil
 		}
 
 		if strings.Contains(e, "no such file or directory") {
-			logger.Log("plist file doesn't exist, nothing to stop: ", plist.Label)
+			s.log().Debug("plist file doesn't exist, nothing to stop")
 			return nil
 		}
 
@@ -147,7 +267,17 @@ Uninstall the system service by first stopping it then removing
 the plist file.
 */
 func (s *SystemService) Uninstall() error {
-	err := s.Stop()
+	unlock, err := s.lock("uninstall")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.owned(); err != nil {
+		return err
+	}
+
+	err = s.stop()
 
 	if err != nil {
 		// If there is no matching process, don't throw an error
@@ -159,15 +289,11 @@ func (s *SystemService) Uninstall() error {
 
 	plist := newPlist(s)
 
-	logger.Log("remove plist file")
-
-	err = os.Remove(plist.Path())
+	s.log().Info("removing plist file")
 
-	if err != nil {
-		if strings.Contains(strings.ToLower(err.Error()), "no such file or directory") {
-			return nil
-		}
+	err = s.fs().Remove(plist.Path())
 
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
 
@@ -178,14 +304,24 @@ func (s *SystemService) Uninstall() error {
 Status returns whether or not the system service is running
 */
 func (s *SystemService) Status() (status *ServiceStatus, err error) {
+	if err := s.checkBackend(); err != nil {
+		return nil, err
+	}
+
 	plist := newPlist(s)
 
-	list, err := runLaunchCtlCommand("list")
+	list, err := s.launchctl("list")
 
 	status = &ServiceStatus{}
 
+	// launchd does not report when a job is due, compute it from the
+	// calendar expressions instead
+	if s.Command.Schedule.enabled() {
+		status.NextTrigger = s.Command.Schedule.next(s.now())
+	}
+
 	if err != nil {
-		logger.Log("error getting launchctl status: ", err)
+		s.log().Warn("error getting launchctl status", "error", err)
 		return status, err
 	}
 
@@ -229,5 +365,232 @@ Return whether or not the plist file eixts
 func (s *SystemService) Exists() bool {
 	plist := newPlist(s)
 
-	return fileExists(plist.Path())
+	return fileExists(s.fs(), plist.Path())
+}
+
+/*
+InstallInstance installs an instance of the service. The instance is a
+copy of the service whose label and name are suffixed with
+"@<instance>" and where "%i" in the arguments and environment is
+replaced by the instance name. If start is passed, also starts the
+instance.
+*/
+func (s *SystemService) InstallInstance(instance string, start bool) error {
+	if err := validateInstance(instance); err != nil {
+		return err
+	}
+
+	if err := s.Command.validateInstanced(); err != nil {
+		return err
+	}
+
+	return s.instance(instance).Install(start)
+}
+
+/*
+StartInstance starts an instance of the service
+*/
+func (s *SystemService) StartInstance(instance string) error {
+	if err := validateInstance(instance); err != nil {
+		return err
+	}
+
+	return s.instance(instance).Start()
+}
+
+/*
+StopInstance stops an instance of the service
+*/
+func (s *SystemService) StopInstance(instance string) error {
+	if err := validateInstance(instance); err != nil {
+		return err
+	}
+
+	return s.instance(instance).Stop()
+}
+
+/*
+UninstallInstance stops and removes an instance of the service
+*/
+func (s *SystemService) UninstallInstance(instance string) error {
+	if err := validateInstance(instance); err != nil {
+		return err
+	}
+
+	return s.instance(instance).Uninstall()
+}
+
+/*
+instance returns the service of a single instance
+*/
+func (s *SystemService) instance(instance string) *SystemService {
+	inst := *s
+	inst.Command = s.Command.forInstance(instance)
+	return &inst
+}
+
+/*
+ListInstances returns the names of the installed instances of the
+service
+*/
+func (s *SystemService) ListInstances() ([]string, error) {
+	if err := s.checkBackend(); err != nil {
+		return nil, err
+	}
+
+	plist := newPlist(s)
+	dir := filepath.Dir(plist.Path())
+
+	paths, err := afero.Glob(s.fs(), filepath.Join(dir, plist.Label+"@*.plist"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, path := range paths {
+		names = append(names, strings.TrimSuffix(filepath.Base(path), ".plist"))
+	}
+
+	return instancesFromNames(plist.Label, names), nil
+}
+
+/*
+SetOverride is not supported, plists cannot be extended by drop-in files
+*/
+func (s *SystemService) SetOverride(name string, directives Section) error {
+	return s.overridesUnsupported()
+}
+
+/*
+ListOverrides is not supported, plists cannot be extended by drop-in files
+*/
+func (s *SystemService) ListOverrides() ([]string, error) {
+	return nil, s.overridesUnsupported()
+}
+
+/*
+RemoveOverride is not supported, plists cannot be extended by drop-in files
+*/
+func (s *SystemService) RemoveOverride(name string) error {
+	return s.overridesUnsupported()
+}
+
+/*
+EffectiveConfig is not supported, plists cannot be extended by drop-in files
+*/
+func (s *SystemService) EffectiveConfig() ([]Section, error) {
+	return nil, s.overridesUnsupported()
+}
+
+func (s *SystemService) overridesUnsupported() error {
+	return &UnsupportedOptionError{
+		Backend: "launchd",
+		Option:  "drop-in overrides",
+		Reason:  "plists cannot be extended by drop-in files",
+	}
+}
+
+/*
+Diff compares the installed plist of the service with the one Install
+would write
+*/
+func (s *SystemService) Diff() (*Diff, error) {
+	if err := s.checkBackend(); err != nil {
+		return nil, err
+	}
+
+	diff := &Diff{}
+	plist := newPlist(s)
+
+	content, err := plist.Generate()
+
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := compareFile(s.fs(), plist.Path(), content)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if f != nil {
+		diff.Files = append(diff.Files, *f)
+	}
+
+	return diff, nil
+}
+
+/*
+Reconcile installs or updates the service so it matches the command.
+The plist is only rewritten if it changed, in which case the job is
+reloaded if it was loaded. New services are loaded. Returns whether or
+not anything changed.
+*/
+func (s *SystemService) Reconcile(ctx context.Context) (bool, error) {
+	unlock, err := s.lock("reconcile")
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	if err := s.owned(); err != nil {
+		return false, err
+	}
+
+	if err := s.Command.validate(); err != nil {
+		return false, err
+	}
+
+	if err := validatePlist(s.Command); err != nil {
+		return false, err
+	}
+
+	diff, err := s.Diff()
+
+	if err != nil {
+		return false, err
+	}
+
+	if !diff.Changed() {
+		s.log().Debug("service is up to date")
+		return false, nil
+	}
+
+	s.log().Info("updating service", "diff", diff.String())
+
+	f := diff.Files[0]
+
+	// launchd only reads the plist when the job is loaded
+	reload := f.Missing || s.loaded()
+
+	if reload && !f.Missing {
+		if err := s.stop(); err != nil {
+			return true, err
+		}
+	}
+
+	if err := s.applyFile(f); err != nil {
+		return true, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return true, err
+	}
+
+	if reload {
+		return true, s.start()
+	}
+
+	return true, nil
+}
+
+/*
+loaded returns whether or not launchd knows about the job, whether it
+is running or waiting to be started
+*/
+func (s *SystemService) loaded() bool {
+	_, err := s.launchctl("list", s.Command.Label)
+	return err == nil
 }
@@ -3,57 +3,238 @@
 package systemservice
 
 import (
-	"io/ioutil"
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/danawoodman/systemservice/unit"
+	"github.com/spf13/afero"
 )
 
 /*
-Run is a no-op on Linux based systems
+platformBackend is the service manager of the platform
 */
-func (s *SystemService) Run() error {
-	return nil
+const platformBackend = BackendSystemd
+
+/*
+platformSupports returns whether or not the backend is available on
+the platform
+*/
+func platformSupports(b Backend) bool {
+	return b == BackendSystemd || b == BackendSysV || b == BackendOpenRC
 }
 
 /*
 Install the system service. If start is passed, also starts
-the service.
+the service. Services installed below a Root are only enabled, so
+they start when the system boots. Files are replaced atomically and
+if a step fails, including the service failing right after starting,
+the previous installation is restored and an InstallError returned.
 */
 func (s *SystemService) Install(start bool) error {
-	unit := newUnitFile(s)
-
-	path := unit.Path()
-	dir := filepath.Dir(path)
+	unlock, err := s.lock("install")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	logger.Log("making sure folder exists: ", dir)
+	switch s.backend() {
+	case BackendSysV:
+		return s.installSysV(start)
+	case BackendOpenRC:
+		return s.installOpenRC(start)
+	}
 
-	os.MkdirAll(dir, os.ModePerm)
+	if err := s.owned(); err != nil {
+		return err
+	}
 
-	logger.Log("generating unit file")
+	if err := s.Command.validate(); err != nil {
+		return err
+	}
 
-	content, err := unit.Generate()
+	if err := s.validateScope(); err != nil {
+		return err
+	}
 
-	if err != nil {
+	if err := s.lintUnits(); err != nil {
 		return err
 	}
 
-	logger.Log("writing unit to: ", path)
+	var target *SystemService
+	if start {
+		target = s
+	}
 
-	err = ioutil.WriteFile(path, []byte(content), 0644)
+	return s.install(s.unitFiles(), target)
+}
 
-	if err != nil {
-		return err
+/*
+install writes the files, reloads systemd and creates the user of
+the service. If target is passed, also starts and enables its units
+and checks they did not fail. If a step fails, the previous files and
+the previous state of the units are restored and an InstallError is
+returned. Accounts created by systemd-sysusers are kept.
+*/
+func (s *SystemService) install(files []generatedFile, target *SystemService) error {
+	m := s.manager()
+	tx := s.newTransaction()
+
+	var states []unitState
+	if target != nil {
+		states = s.unitStates(m, target.activationUnits())
 	}
 
-	logger.Log("wrote unit:\n", content)
+	started := false
+
+	err := func() error {
+		for _, file := range files {
+			if err := s.writeGeneratedFile(file, tx); err != nil {
+				return err
+			}
+		}
+
+		s.log().Debug("reloading daemon")
+
+		if err := m.Reload(); err != nil {
+			return err
+		}
+
+		if s.Command.CreateUser {
+			if err := s.createUser(tx); err != nil {
+				return err
+			}
+		}
+
+		if target == nil {
+			return nil
+		}
+
+		started = true
+
+		if err := target.start(m); err != nil {
+			return err
+		}
+
+		return target.verifyStarted(m)
+	}()
+
+	if err == nil {
+		return nil
+	}
+
+	s.log().Error("install failed, rolling back", "error", err)
+
+	return &InstallError{Err: err, RollbackErr: s.rollback(m, tx, states, started)}
+}
+
+/*
+unitState is whether or not a unit was enabled and running before an
+install
+*/
+type unitState struct {
+	name    string
+	enabled bool
+	active  bool
+}
+
+/*
+unitStates returns the current state of the units. Units which do not
+exist yet are neither enabled nor active.
+*/
+func (s *SystemService) unitStates(m unitManager, names []string) []unitState {
+	states := make([]unitState, len(names))
+
+	for i, name := range names {
+		states[i].name = name
+		states[i].enabled, _ = m.IsEnabled(name)
+
+		if props, err := m.Properties(name); err == nil {
+			states[i].active = props.ActiveState == "active" || props.ActiveState == "reloading"
+		}
+	}
+
+	return states
+}
+
+/*
+rollback restores the files of the transaction and, if the units were
+started, their previous state. The units are disabled while the new
+files are in place, so links for the new [Install] section are
+removed, and enabled again with the previous files.
+*/
+func (s *SystemService) rollback(m unitManager, tx *transaction, states []unitState, started bool) error {
+	var first error
+	keep := func(err error) {
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	if started {
+		for i := len(states) - 1; i >= 0; i-- {
+			state := states[i]
+
+			if !state.active {
+				if err := m.Stop(state.name); err != nil {
+					s.log().Warn("error stopping unit", "error", err)
+				}
+			}
+
+			if err := m.Disable(state.name); err != nil {
+				s.log().Warn("error disabling unit", "error", err)
+			}
+		}
+	}
+
+	keep(tx.rollback())
+
+	s.log().Debug("reloading daemon")
+
+	keep(m.Reload())
+
+	if started {
+		for _, state := range states {
+			if state.enabled {
+				keep(m.Enable(state.name))
+			}
+
+			if state.active {
+				keep(m.Restart(state.name))
+			}
+		}
+	}
+
+	return first
+}
+
+/*
+verifyStarted returns an error if a unit of the service failed after
+it was started
+*/
+func (s *SystemService) verifyStarted(m unitManager) error {
+	// Nothing was started
+	if s.Command.Root != "" {
+		return nil
+	}
+
+	s.startCheck()
+
+	for _, name := range s.activationUnits() {
+		props, err := m.Properties(name)
 
-	if start {
-		err := s.Start()
 		if err != nil {
 			return err
 		}
+
+		if props.ActiveState == "failed" {
+			return fmt.Errorf("%s failed after starting", unitName(name))
+		}
 	}
 
 	return nil
@@ -63,26 +244,42 @@ func (s *SystemService) Install(start bool) error {
 Start the system service if it is installed
 */
 func (s *SystemService) Start() error {
-	unit := newUnitFile(s)
-
-	logger.Log("loading unit file with systemd")
-
-	_, err := runSystemCtlCommand("start", unit.Label)
-
+	unlock, err := s.lock("start")
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	logger.Log("enabling unit file with systemd")
+	switch s.backend() {
+	case BackendSysV:
+		return s.runInitScript("start")
+	case BackendOpenRC:
+		return s.rcService("start")
+	}
 
-	_, err = runSystemCtlCommand("enable", unit.Label)
+	return s.start(s.manager())
+}
 
-	if err != nil {
-		e := err.Error()
-		if strings.Contains(e, "Created symlink") {
-			return nil
+/*
+start starts and enables the units of the service
+*/
+func (s *SystemService) start(m unitManager) error {
+	for _, name := range s.activationUnits() {
+		s.log().Info("starting unit with systemd", "unit", name)
+
+		err := m.Start(name)
+
+		if err != nil {
+			return err
+		}
+
+		s.log().Info("enabling unit with systemd", "unit", name)
+
+		err = m.Enable(name)
+
+		if err != nil {
+			return err
 		}
-		return err
 	}
 
 	return nil
@@ -92,13 +289,35 @@ func (s *SystemService) Start() error {
 Restart attempts to stop the service if running then starts it again
 */
 func (s *SystemService) Restart() error {
-	unit := newUnitFile(s)
-
-	_, err := runSystemCtlCommand("reload-or-restart", unit.Label)
-
+	unlock, err := s.lock("restart")
 	if err != nil {
 		return err
 	}
+	defer unlock()
+
+	switch s.backend() {
+	case BackendSysV:
+		return s.runInitScript("restart")
+	case BackendOpenRC:
+		return s.rcService("restart")
+	}
+
+	units := []string{s.Command.Label}
+
+	// Services started per connection have no unit of their own
+	if s.Command.Sockets.Accept {
+		units = s.activationUnits()
+	}
+
+	m := s.manager()
+
+	for _, name := range units {
+		err := m.Restart(name)
+
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -107,49 +326,79 @@ func (s *SystemService) Restart() error {
 Stop stops the system service by unloading the unit file
 */
 func (s *SystemService) Stop() error {
-	unit := newUnitFile(s)
+	unlock, err := s.lock("stop")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	switch s.backend() {
+	case BackendSysV:
+		return s.runInitScript("stop")
+	case BackendOpenRC:
+		return s.rcService("stop")
+	}
 
-	logger.Log("reloading daemon")
+	return s.stop()
+}
+
+/*
+stop is Stop for callers holding the lock of the service
+*/
+func (s *SystemService) stop() error {
+	m := s.manager()
+	activation := s.activationUnits()
 
-	_, err := runSystemCtlCommand("daemon-reload", "")
+	s.log().Debug("reloading daemon")
+
+	err := m.Reload()
 
 	if err != nil {
 		return err
 	}
 
-	logger.Log("stopping unit file with systemd")
+	// Stop the sockets, timers and paths first so they do not start
+	// the service again
+	units := activation
+	if s.Command.activated() {
+		service := s.Command.Label
+		if s.Command.Sockets.Accept {
+			service += "@*.service"
+		}
+		units = append(units, service)
+	}
 
-	_, err = runSystemCtlCommand("stop", unit.Label)
-	// --force
-	// --now
+	for _, name := range units {
+		s.log().Info("stopping unit with systemd", "unit", name)
 
-	if err != nil {
-		return err
+		err = m.Stop(name)
+
+		if err != nil {
+			return err
+		}
 	}
 
-	logger.Log("disabling unit file with systemd")
+	for _, name := range activation {
+		s.log().Info("disabling unit with systemd", "unit", name)
 
-	_, err = runSystemCtlCommand("disable", unit.Label)
+		err = m.Disable(name)
 
-	if err != nil {
-		if strings.Contains(err.Error(), "Removed") {
-			logger.Log("ignoring remove symlink error")
-			return nil
+		if err != nil {
+			return err
 		}
-		return err
 	}
 
-	logger.Log("reloading daemon")
+	s.log().Debug("reloading daemon")
 
-	_, err = runSystemCtlCommand("daemon-reload", "")
+	err = m.Reload()
 
 	if err != nil {
 		return err
 	}
 
-	logger.Log("running reset-failed")
+	s.log().Debug("running reset-failed")
 
-	_, err = runSystemCtlCommand("reset-failed", "")
+	err = m.ResetFailed()
 
 	if err != nil {
 		return err
@@ -163,61 +412,1028 @@ Uninstall the system service by first stopping it then removing
 the unit file.
 */
 func (s *SystemService) Uninstall() error {
-	err := s.Stop()
+	unlock, err := s.lock("uninstall")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	switch s.backend() {
+	case BackendSysV:
+		return s.uninstallSysV()
+	case BackendOpenRC:
+		return s.uninstallOpenRC()
+	}
+
+	if err := s.owned(); err != nil {
+		return err
+	}
+
+	err = s.stop()
 
 	if err != nil {
 		return err
 	}
 
-	logger.Log("remove unit file")
+	for _, file := range s.unitFiles() {
+		s.log().Info("removing unit file", "path", file.Path())
+
+		err = s.fs().Remove(file.Path())
+
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
 
 	unit := newUnitFile(s)
-	err = unit.Remove()
+
+	s.log().Info("removing drop-ins", "path", s.dropInDir(unit.Name()))
+
+	err = s.fs().RemoveAll(s.dropInDir(unit.Name()))
 
 	if err != nil {
 		return err
 	}
 
+	if s.Command.CreateUser {
+		s.log().Info("removing sysusers file")
+
+		users := newSysusersFile(s)
+		err = s.fs().Remove(users.Path())
+
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if s.Command.Directories.PurgeOnUninstall {
+		err = s.purgeDirectories()
+
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 /*
-Status returns whether or not the system service is running
+createUser writes the sysusers.d fragment for the service and has
+systemd-sysusers create the account right away
 */
-func (s *SystemService) Status() (status *ServiceStatus, err error) {
+func (s *SystemService) createUser(tx *transaction) error {
+	users := newSysusersFile(s)
+
+	if err := s.writeGeneratedFile(&users, tx); err != nil {
+		return err
+	}
+
+	if s.Command.Root != "" {
+		s.log().Debug("system user is created by systemd-sysusers on boot", "user", users.User)
+		return nil
+	}
+
+	s.log().Info("creating system user", "user", users.User)
+
+	_, err := s.sysusers(users.Path())
+
+	return err
+}
+
+/*
+unitFiles returns the units Install writes for the service
+*/
+func (s *SystemService) unitFiles() []generatedFile {
 	unit := newUnitFile(s)
-	active, _ := runSystemCtlCommand("is-active", unit.Label)
+	files := []generatedFile{&unit}
 
-	status = &ServiceStatus{}
+	if s.Command.Sockets.enabled() {
+		socket := newSocketUnitFile(s)
+		files = append(files, &socket)
+	}
 
-	// Check if service is running
-	if !strings.Contains(active, "active") {
-		return status, nil
+	if s.Command.Schedule.enabled() {
+		timer := newTimerUnitFile(s)
+		files = append(files, &timer)
 	}
 
-	stat, _ := runSystemCtlCommand("status", unit.Label)
+	if len(s.Command.Triggers) > 0 {
+		path := newPathUnitFile(s)
+		files = append(files, &path)
+	}
 
-	// Get the PID from the status output
-	lines := strings.Split(stat, "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "Main PID") {
-			parts := strings.Split(strings.TrimSpace(line), " ")
-			pid, _ := strconv.Atoi(parts[2])
-			if pid != 0 {
-				status.PID = pid
-			}
+	return files
+}
+
+/*
+activationUnits returns the units which are started and enabled to
+run the service. Socket activated, scheduled and triggered services
+are started through their socket, timer and path units.
+*/
+func (s *SystemService) activationUnits() []string {
+	var units []string
+
+	if s.Command.Sockets.enabled() {
+		units = append(units, s.Command.Label+".socket")
+	}
+
+	if s.Command.Schedule.enabled() {
+		units = append(units, s.Command.Label+".timer")
+	}
+
+	if len(s.Command.Triggers) > 0 {
+		units = append(units, s.Command.Label+".path")
+	}
+
+	if len(units) == 0 {
+		units = append(units, s.Command.Label)
+	}
+
+	return units
+}
+
+/*
+writeGeneratedFile generates the file and writes it atomically in the
+transaction, creating its folder if needed
+*/
+func (s *SystemService) writeGeneratedFile(file generatedFile, tx *transaction) error {
+	path := file.Path()
+
+	s.log().Debug("generating file", "path", path)
+
+	content, err := file.Generate()
+
+	if err != nil {
+		return err
+	}
+
+	s.log().Debug("writing file", "path", path)
+
+	if err := tx.writeFile(path, []byte(content), 0644); err != nil {
+		return err
+	}
+
+	s.log().Debug("wrote file", "content", content)
+
+	return nil
+}
+
+/*
+purgeDirectories removes the state, cache, logs, runtime and
+configuration directories of the service
+*/
+func (s *SystemService) purgeDirectories() error {
+	paths := s.Command.Directories.paths(s.scope() == ScopeUser, s.Command.DynamicUser)
+
+	for _, path := range paths {
+		path = filepath.Join(s.Command.Root, path)
+
+		s.log().Info("removing directory", "path", path)
+
+		if err := s.fs().RemoveAll(path); err != nil {
+			return err
 		}
 	}
 
-	status.Running = true
+	return nil
+}
 
-	return status, nil
+/*
+validateScope makes sure options which only apply to system services
+are not used when installing a user service
+*/
+func (s *SystemService) validateScope() error {
+	cmd := s.Command
+
+	if s.scope() == ScopeSystem {
+		return nil
+	}
+
+	if cmd.User != "" || cmd.Group != "" || cmd.CreateUser || cmd.DynamicUser {
+		return errors.New("User, Group, CreateUser and DynamicUser can only be used when installing as root")
+	}
+
+	return nil
 }
 
 /*
-Exists returns whether or not the unit file eixts
+lintUnits lints the generated units before they are written, logging
+warnings and returning an InvalidUnitError if a unit has errors
 */
-func (s *SystemService) Exists() bool {
-	unit := newUnitFile(s)
-	return fileExists(unit.Path())
+func (s *SystemService) lintUnits() error {
+	var errs []LintIssue
+
+	for _, file := range s.unitFiles() {
+		name := filepath.Base(file.Path())
+		if unitName(name) != name {
+			// sysusers.d fragments are not units
+			continue
+		}
+
+		content, err := file.Generate()
+		if err != nil {
+			return err
+		}
+
+		f, err := unit.Parse(strings.NewReader(content))
+		if err != nil {
+			return err
+		}
+		f.Name = name
+
+		for _, issue := range Lint(f, s.scope()) {
+			if issue.Severity == SeverityError {
+				errs = append(errs, issue)
+			} else {
+				s.log().Warn("lint issue", "issue", issue.String())
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return &InvalidUnitError{Issues: errs}
+	}
+
+	return nil
+}
+
+/*
+Status returns whether or not the system service is running
+*/
+func (s *SystemService) Status() (status *ServiceStatus, err error) {
+	if err := s.checkBackend(); err != nil {
+		return nil, err
+	}
+
+	switch s.backend() {
+	case BackendSysV:
+		return s.statusSysV()
+	case BackendOpenRC:
+		return s.statusOpenRC()
+	}
+
+	if err := s.online("Status"); err != nil {
+		return nil, err
+	}
+
+	name := s.Command.Label
+
+	// Services started per connection are running while their
+	// socket is listening
+	if s.Command.Sockets.Accept {
+		name = s.activationUnits()[0]
+	}
+
+	m := s.manager()
+
+	status = &ServiceStatus{}
+
+	if s.Command.Schedule.enabled() {
+		status.NextTrigger, status.LastTrigger = s.timerTriggers(m)
+	}
+
+	props, err := m.Properties(name)
+	if err != nil {
+		s.log().Warn("error getting unit properties", "error", err)
+		return status, nil
+	}
+
+	// Check if service is running
+	if props.ActiveState != "active" && props.ActiveState != "reloading" {
+		return status, nil
+	}
+
+	status.PID = props.MainPID
+	status.Running = true
+
+	return status, nil
+}
+
+/*
+unitSearchPaths are the directories systemd loads system units from,
+in order of precedence
+*/
+var unitSearchPaths = []string{
+	"/etc/systemd/system",
+	"/run/systemd/system",
+	"/usr/local/lib/systemd/system",
+	"/usr/lib/systemd/system",
+	"/lib/systemd/system",
+}
+
+/*
+Exists returns whether or not the unit file eixts. For services
+returned by Open, that is any unit file systemd loads, e.g. one
+installed by a package below /usr/lib/systemd/system.
+*/
+func (s *SystemService) Exists() bool {
+	switch s.backend() {
+	case BackendSysV:
+		script := newInitScript(s)
+		return fileExists(s.fs(), script.Path())
+	case BackendOpenRC:
+		script := newOpenRCScript(s)
+		return fileExists(s.fs(), script.Path())
+	}
+
+	unit := newUnitFile(s)
+
+	if s.external {
+		return s.unitLoaded(unit.Name())
+	}
+
+	return fileExists(s.fs(), unit.Path())
+}
+
+/*
+unitLoaded returns whether or not systemd has a unit file for the unit.
+Below a Root the unit search paths are checked instead.
+*/
+func (s *SystemService) unitLoaded(name string) bool {
+	if s.Command.Root != "" {
+		for _, dir := range unitSearchPaths {
+			if fileExists(s.fs(), filepath.Join(s.Command.Root, dir, name)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	props, err := s.manager().Properties(name)
+	if err != nil {
+		s.log().Warn("error getting unit properties", "error", err)
+		return false
+	}
+
+	return props.FragmentPath != ""
+}
+
+/*
+timerTriggers returns the next and last time the timer of a scheduled
+service elapses
+*/
+func (s *SystemService) timerTriggers(m unitManager) (next time.Time, last time.Time) {
+	props, err := m.Properties(s.Command.Label + ".timer")
+
+	if err != nil {
+		s.log().Warn("error getting timer status", "error", err)
+		return next, last
+	}
+
+	return props.NextElapse, props.LastTrigger
+}
+
+/*
+parseSystemdTimestamp parses a timestamp as printed by "systemctl show",
+returning the zero time for "n/a" or an empty value
+*/
+func parseSystemdTimestamp(value string) time.Time {
+	value = strings.TrimSpace(value)
+
+	if strings.HasPrefix(value, "@") {
+		sec, err := strconv.ParseInt(value[1:], 10, 64)
+		if err == nil {
+			return time.Unix(sec, 0)
+		}
+	}
+
+	t, err := time.ParseInLocation("Mon 2006-01-02 15:04:05 MST", value, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+/*
+InstallInstance installs the service as a template unit
+(<label>@.service) and enables the given instance of it. If start is
+passed, also starts the instance. systemd replaces "%i" in the
+arguments and environment with the instance name.
+*/
+func (s *SystemService) InstallInstance(instance string, start bool) error {
+	unlock, err := s.lock("install_instance")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.systemdOnly("instances"); err != nil {
+		return err
+	}
+
+	if err := s.owned(); err != nil {
+		return err
+	}
+
+	if err := validateInstance(instance); err != nil {
+		return err
+	}
+
+	if err := s.Command.validate(); err != nil {
+		return err
+	}
+
+	if err := s.Command.validateInstanced(); err != nil {
+		return err
+	}
+
+	if err := s.validateScope(); err != nil {
+		return err
+	}
+
+	if err := s.lintUnits(); err != nil {
+		return err
+	}
+
+	unit := newUnitFile(s)
+	unit.Template = true
+
+	var target *SystemService
+	if start {
+		target = s.instance(instance)
+	}
+
+	return s.install([]generatedFile{&unit}, target)
+}
+
+/*
+StartInstance starts and enables an instance of the template service
+*/
+func (s *SystemService) StartInstance(instance string) error {
+	if err := s.systemdOnly("instances"); err != nil {
+		return err
+	}
+
+	if err := validateInstance(instance); err != nil {
+		return err
+	}
+
+	return s.instance(instance).Start()
+}
+
+/*
+StopInstance stops and disables an instance of the template service
+*/
+func (s *SystemService) StopInstance(instance string) error {
+	if err := s.systemdOnly("instances"); err != nil {
+		return err
+	}
+
+	if err := validateInstance(instance); err != nil {
+		return err
+	}
+
+	return s.instance(instance).Stop()
+}
+
+/*
+UninstallInstance stops and disables an instance of the template
+service. The template unit is removed with the last instance.
+*/
+func (s *SystemService) UninstallInstance(instance string) error {
+	unlock, err := s.lock("uninstall_instance")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.systemdOnly("instances"); err != nil {
+		return err
+	}
+
+	if err := s.owned(); err != nil {
+		return err
+	}
+
+	err = s.StopInstance(instance)
+
+	if err != nil {
+		return err
+	}
+
+	instances, err := s.ListInstances()
+
+	if err != nil {
+		return err
+	}
+
+	for _, i := range instances {
+		if i != instance {
+			return nil
+		}
+	}
+
+	s.log().Info("removing template unit, no instances left")
+
+	unit := newUnitFile(s)
+	unit.Template = true
+	err = s.fs().Remove(unit.Path())
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+/*
+ListInstances returns the names of the instances of the template
+service which are loaded or enabled
+*/
+func (s *SystemService) ListInstances() ([]string, error) {
+	if err := s.checkBackend(); err != nil {
+		return nil, err
+	}
+
+	if err := s.systemdOnly("instances"); err != nil {
+		return nil, err
+	}
+
+	label := s.Command.Label
+
+	var names []string
+
+	if s.Command.Root == "" {
+		loaded, err := s.manager().ListUnits(label + "@*.service")
+
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, loaded...)
+	}
+
+	// Enabled instances which are not loaded only show up as symlinks
+	links, _ := afero.Glob(s.fs(), filepath.Join(s.unitDir(), "*.wants", label+"@*.service"))
+	for _, link := range links {
+		names = append(names, filepath.Base(link))
+	}
+
+	return instancesFromNames(label, names), nil
+}
+
+/*
+instance returns the service of a single instance of the template
+*/
+func (s *SystemService) instance(instance string) *SystemService {
+	inst := *s
+	inst.Command.Label = s.Command.Label + "@" + instance
+	return &inst
+}
+
+/*
+SetOverride writes the drop-in <unit>.d/<name>.conf, overriding the
+directives of one section of the unit, and reloads systemd. Calling
+it again with the same name replaces the drop-in. Assign an empty
+value to reset a directive before setting it, e.g. ExecStart.
+*/
+func (s *SystemService) SetOverride(name string, directives Section) error {
+	unlock, err := s.lock("set_override")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.systemdOnly("overrides"); err != nil {
+		return err
+	}
+
+	if err := validateOverrideName(name); err != nil {
+		return err
+	}
+
+	if err := directives.Validate(); err != nil {
+		return err
+	}
+
+	dropIn := newDropInFile(s, name, directives)
+
+	if err := s.writeGeneratedFile(&dropIn, s.newTransaction()); err != nil {
+		return err
+	}
+
+	s.log().Debug("reloading daemon")
+
+	return s.manager().Reload()
+}
+
+/*
+ListOverrides returns the names of the drop-ins written with
+SetOverride
+*/
+func (s *SystemService) ListOverrides() ([]string, error) {
+	if err := s.checkBackend(); err != nil {
+		return nil, err
+	}
+
+	if err := s.systemdOnly("overrides"); err != nil {
+		return nil, err
+	}
+
+	unit := newUnitFile(s)
+
+	files, err := afero.ReadDir(s.fs(), s.dropInDir(unit.Name()))
+
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".conf") {
+			names = append(names, strings.TrimSuffix(file.Name(), ".conf"))
+		}
+	}
+
+	return names, nil
+}
+
+/*
+RemoveOverride removes a drop-in written with SetOverride and reloads
+systemd
+*/
+func (s *SystemService) RemoveOverride(name string) error {
+	unlock, err := s.lock("remove_override")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.systemdOnly("overrides"); err != nil {
+		return err
+	}
+
+	if err := validateOverrideName(name); err != nil {
+		return err
+	}
+
+	dropIn := newDropInFile(s, name, Section{})
+
+	s.log().Info("removing drop-in", "path", dropIn.Path())
+
+	err = s.fs().Remove(dropIn.Path())
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	removeEmptyDir(s.fs(), filepath.Dir(dropIn.Path()))
+
+	s.log().Debug("reloading daemon")
+
+	return s.manager().Reload()
+}
+
+/*
+EffectiveConfig returns the configuration systemd uses for the
+service: the unit file with all of its drop-ins applied, including
+the ones not written by SetOverride
+*/
+func (s *SystemService) EffectiveConfig() ([]Section, error) {
+	if err := s.checkBackend(); err != nil {
+		return nil, err
+	}
+
+	if err := s.systemdOnly("overrides"); err != nil {
+		return nil, err
+	}
+
+	unit := newUnitFile(s)
+
+	props, err := s.manager().Properties(unit.Name())
+
+	if err != nil {
+		return nil, err
+	}
+
+	fragment, dropIns := props.FragmentPath, props.DropInPaths
+
+	if fragment == "" {
+		return nil, &ServiceDoesNotExistError{serviceName: unit.Name()}
+	}
+
+	var files [][]Section
+	for _, path := range append([]string{fragment}, dropIns...) {
+		content, err := afero.ReadFile(s.fs(), path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sections, err := parseSections(string(content))
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		files = append(files, sections)
+	}
+
+	return mergeSections(files...), nil
+}
+
+/*
+Diff compares the installed unit files of the service with the ones
+Install would write
+*/
+func (s *SystemService) Diff() (*Diff, error) {
+	if err := s.checkBackend(); err != nil {
+		return nil, err
+	}
+
+	switch s.backend() {
+	case BackendSysV:
+		return s.diffSysV()
+	case BackendOpenRC:
+		return s.diffOpenRC()
+	}
+
+	diff := &Diff{}
+	desired := map[string]bool{}
+
+	files := s.unitFiles()
+
+	if s.instanced() {
+		template := newUnitFile(s)
+		template.Template = true
+		files = []generatedFile{&template}
+	}
+
+	if s.Command.CreateUser {
+		users := newSysusersFile(s)
+		files = append(files, &users)
+	}
+
+	for _, file := range files {
+		content, err := file.Generate()
+
+		if err != nil {
+			return nil, err
+		}
+
+		desired[file.Path()] = true
+
+		f, err := compareFile(s.fs(), file.Path(), content)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if f != nil {
+			diff.Files = append(diff.Files, *f)
+		}
+	}
+
+	// Units written for options the command no longer uses
+	label := s.Command.Label
+	for _, name := range []string{label + ".service", label + "@.service", label + ".socket", label + ".timer", label + ".path"} {
+		path := filepath.Join(s.unitDir(), name)
+
+		if desired[path] {
+			continue
+		}
+
+		f, err := staleFile(s.fs(), path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if f != nil {
+			diff.Files = append(diff.Files, *f)
+		}
+	}
+
+	return diff, nil
+}
+
+/*
+instanced returns whether or not the service was installed with
+InstallInstance: its template unit exists, the plain unit does not
+*/
+func (s *SystemService) instanced() bool {
+	if s.Command.validateInstanced() != nil {
+		return false
+	}
+
+	template := newUnitFile(s)
+	template.Template = true
+
+	return fileExists(s.fs(), template.Path()) && !fileExists(s.fs(), s.unitPath(s.Command.Label))
+}
+
+/*
+Reconcile installs or updates the service so it matches the command.
+Unit files are only rewritten if they changed, in which case systemd
+is reloaded and the units whose changes need it are restarted if they
+were running. New units are started and enabled. Returns whether or
+not anything changed. A service installed with InstallInstance keeps
+its template, instances which are running are restarted.
+*/
+func (s *SystemService) Reconcile(ctx context.Context) (bool, error) {
+	unlock, err := s.lock("reconcile")
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	switch s.backend() {
+	case BackendSysV:
+		return s.reconcileSysV(ctx)
+	case BackendOpenRC:
+		return s.reconcileOpenRC(ctx)
+	}
+
+	if err := s.owned(); err != nil {
+		return false, err
+	}
+
+	if err := s.Command.validate(); err != nil {
+		return false, err
+	}
+
+	if err := s.validateScope(); err != nil {
+		return false, err
+	}
+
+	if err := s.lintUnits(); err != nil {
+		return false, err
+	}
+
+	instanced := s.instanced()
+
+	diff, err := s.Diff()
+
+	if err != nil {
+		return false, err
+	}
+
+	if !diff.Changed() {
+		s.log().Debug("service is up to date")
+		return false, nil
+	}
+
+	s.log().Info("updating service", "diff", diff.String())
+
+	m := s.manager()
+	users := newSysusersFile(s)
+	activationChanged := false
+
+	for _, f := range diff.Files {
+		if err := ctx.Err(); err != nil {
+			return true, err
+		}
+
+		if f.Stale {
+			name := filepath.Base(f.Path)
+
+			s.log().Info("disabling unit which is no longer needed", "unit", name)
+
+			if err := m.Stop(name); err != nil {
+				s.log().Warn("error stopping unit", "error", err)
+			}
+
+			if err := m.Disable(name); err != nil {
+				s.log().Warn("error disabling unit", "error", err)
+			}
+
+			activationChanged = true
+		}
+
+		if err := s.applyFile(f); err != nil {
+			return true, err
+		}
+
+		if f.Path == users.Path() && s.Command.Root == "" {
+			s.log().Info("creating system user", "user", users.User)
+
+			if _, err := s.sysusers(users.Path()); err != nil {
+				return true, err
+			}
+		}
+	}
+
+	s.log().Debug("reloading daemon")
+
+	if err := m.Reload(); err != nil {
+		return true, err
+	}
+
+	for _, f := range diff.Files {
+		name := filepath.Base(f.Path)
+
+		if f.Stale || f.Missing || f.Path == users.Path() {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return true, err
+		}
+
+		sections := changedSections(f.Installed, f.Desired)
+
+		// Templates have no unit of their own to restart, their
+		// instances are restarted instead
+		if needsRestart(sections) && instanced && s.Command.Root == "" {
+			if err := s.restartInstances(m); err != nil {
+				return true, err
+			}
+		} else if needsRestart(sections) && !strings.HasSuffix(name, "@.service") {
+			s.log().Info("restarting unit if running", "unit", name)
+
+			if err := m.TryRestart(name); err != nil {
+				return true, err
+			}
+		}
+
+		for _, section := range sections {
+			if section != "Install" {
+				continue
+			}
+
+			if enabled, err := m.IsEnabled(name); err != nil || !enabled {
+				continue
+			}
+
+			s.log().Debug("re-enabling unit", "unit", name)
+
+			if err := m.Disable(name); err != nil {
+				return true, err
+			}
+
+			if err := m.Enable(name); err != nil {
+				return true, err
+			}
+		}
+	}
+
+	if instanced {
+		return true, nil
+	}
+
+	for _, name := range s.activationUnits() {
+		path := s.unitPath(name)
+
+		missing := false
+		for _, f := range diff.Files {
+			if f.Path == path && f.Missing {
+				missing = true
+			}
+		}
+
+		if !missing && !activationChanged {
+			continue
+		}
+
+		if err := ctx.Err(); err != nil {
+			return true, err
+		}
+
+		s.log().Info("starting and enabling unit", "unit", name)
+
+		if err := m.Enable(name); err != nil {
+			return true, err
+		}
+
+		if err := m.Start(name); err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+/*
+restartInstances restarts the loaded instances of the template service
+which are running
+*/
+func (s *SystemService) restartInstances(m unitManager) error {
+	names, err := m.ListUnits(s.Command.Label + "@*.service")
+
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		s.log().Info("restarting instance if running", "unit", name)
+
+		if err := m.TryRestart(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+unitPath returns the path of an installed unit, names without a type
+suffix are services
+*/
+func (s *SystemService) unitPath(name string) string {
+	return filepath.Join(s.unitDir(), unitName(name))
 }
@@ -0,0 +1,418 @@
+// +build linux
+
+package systemservice
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+/*
+openrcRunlevel is the runlevel services are added to, the one OpenRC
+boots into
+*/
+const openrcRunlevel = "default"
+
+/*
+installOpenRC writes the openrc-run script, adds it to the default
+runlevel and, if start is passed, (re)starts the service. On failure
+the previous script and state are restored and an InstallError
+returned.
+*/
+func (s *SystemService) installOpenRC(start bool) error {
+	if err := s.owned(); err != nil {
+		return err
+	}
+
+	if err := s.Command.validate(); err != nil {
+		return err
+	}
+
+	if err := validateOpenRC(s); err != nil {
+		return err
+	}
+
+	script := newOpenRCScript(s)
+
+	content, err := script.Generate()
+	if err != nil {
+		return err
+	}
+
+	wasInstalled := fileExists(s.fs(), script.Path())
+	wasRunning := false
+	if wasInstalled && s.Command.Root == "" {
+		if status, err := s.statusOpenRC(); err == nil {
+			wasRunning = status.Running
+		}
+	}
+
+	tx := s.newTransaction()
+	started := false
+
+	err = func() error {
+		s.log().Debug("writing openrc script", "path", script.Path())
+
+		if err := tx.writeFile(script.Path(), []byte(content), 0755); err != nil {
+			return err
+		}
+
+		s.log().Debug("wrote openrc script", "content", content)
+
+		if err := s.enableOpenRC(); err != nil {
+			return err
+		}
+
+		if !start || s.Command.Root != "" {
+			return nil
+		}
+
+		started = true
+
+		action := "start"
+		if wasRunning {
+			action = "restart"
+		}
+		if err := s.rcService(action); err != nil {
+			return err
+		}
+
+		return s.verifyStartedOpenRC()
+	}()
+
+	if err == nil {
+		return nil
+	}
+
+	s.log().Error("install failed, rolling back", "error", err)
+
+	return &InstallError{Err: err, RollbackErr: s.rollbackOpenRC(tx, started, wasInstalled, wasRunning)}
+}
+
+/*
+rollbackOpenRC stops the new service if it was started, restores the
+previous script and starts it again if it was running before
+*/
+func (s *SystemService) rollbackOpenRC(tx *transaction, started, wasInstalled, wasRunning bool) error {
+	if started {
+		if err := s.rcService("stop"); err != nil {
+			s.log().Warn("error stopping service", "error", err)
+		}
+	}
+
+	if !wasInstalled {
+		if err := s.disableOpenRC(); err != nil {
+			s.log().Warn("error disabling service", "error", err)
+		}
+	}
+
+	if err := tx.rollback(); err != nil {
+		return err
+	}
+
+	if wasRunning {
+		return s.rcService("start")
+	}
+
+	return nil
+}
+
+/*
+verifyStartedOpenRC returns an error if the service is not started
+shortly after it was started, e.g. because supervise-daemon gave up
+restarting the program
+*/
+func (s *SystemService) verifyStartedOpenRC() error {
+	s.startCheck()
+
+	state, err := s.openrcState()
+	if err != nil {
+		return err
+	}
+
+	if state != "started" {
+		return fmt.Errorf("%s is %s after starting it", s.Command.Label, state)
+	}
+
+	return nil
+}
+
+/*
+uninstallOpenRC stops the service, removes it from its runlevel and
+removes its script
+*/
+func (s *SystemService) uninstallOpenRC() error {
+	if err := s.owned(); err != nil {
+		return err
+	}
+
+	script := newOpenRCScript(s)
+
+	if fileExists(s.fs(), script.Path()) {
+		if err := s.rcService("stop"); err != nil {
+			return err
+		}
+	}
+
+	if err := s.disableOpenRC(); err != nil {
+		return err
+	}
+
+	s.log().Info("removing openrc script", "path", script.Path())
+
+	if err := s.fs().Remove(script.Path()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if s.Command.Directories.PurgeOnUninstall {
+		return s.purgeDirectories()
+	}
+
+	return nil
+}
+
+/*
+rcService runs an action of the service with rc-service. Below a Root
+there is nothing running, the action is skipped.
+*/
+func (s *SystemService) rcService(action string) error {
+	if s.Command.Root != "" {
+		s.log().Debug("skipping action below root", "action", action, "root", s.Command.Root)
+		return nil
+	}
+
+	script := newOpenRCScript(s)
+
+	if !fileExists(s.fs(), script.Path()) {
+		return &ServiceDoesNotExistError{serviceName: s.Command.Label}
+	}
+
+	s.log().Info("running rc-service", "action", action)
+
+	out, err := s.run("rc-service", s.Command.Label, action)
+	if err != nil {
+		return fmt.Errorf("rc-service %s %s failed: %v: %s", s.Command.Label, action, err, strings.TrimSpace(out))
+	}
+
+	return nil
+}
+
+/*
+runlevelLink returns the symlink adding the service to the default
+runlevel, as created by rc-update
+*/
+func (s *SystemService) runlevelLink() string {
+	return filepath.Join(s.Command.Root, "/etc/runlevels", openrcRunlevel, s.Command.Label)
+}
+
+/*
+enableOpenRC adds the service to the default runlevel, with rc-update
+or, below a Root, by creating its symlink
+*/
+func (s *SystemService) enableOpenRC() error {
+	link := s.runlevelLink()
+
+	if _, err := readlink(s.fs(), link); err == nil {
+		return nil
+	}
+
+	if s.Command.Root == "" {
+		s.log().Info("adding service to runlevel", "runlevel", openrcRunlevel)
+		_, err := s.run("rc-update", "add", s.Command.Label, openrcRunlevel)
+		return err
+	}
+
+	target := filepath.Join("/etc/init.d", s.Command.Label)
+
+	s.log().Info("creating symlink", "path", link, "target", target)
+
+	if err := s.fs().MkdirAll(filepath.Dir(link), 0755); err != nil {
+		return err
+	}
+
+	return symlink(s.fs(), target, link)
+}
+
+/*
+disableOpenRC removes the service from the default runlevel
+*/
+func (s *SystemService) disableOpenRC() error {
+	link := s.runlevelLink()
+
+	if _, err := readlink(s.fs(), link); err != nil {
+		return nil
+	}
+
+	if s.Command.Root == "" {
+		s.log().Info("removing service from runlevel", "runlevel", openrcRunlevel)
+		_, err := s.run("rc-update", "del", s.Command.Label, openrcRunlevel)
+		return err
+	}
+
+	s.log().Info("removing symlink", "path", link)
+
+	if err := s.fs().Remove(link); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+/*
+openrcState returns the state "rc-service <label> status" reports,
+e.g. "started", "stopped" or "crashed". rc-service exits with an
+error unless the service is started, the output is parsed regardless.
+*/
+func (s *SystemService) openrcState() (string, error) {
+	script := newOpenRCScript(s)
+
+	if !fileExists(s.fs(), script.Path()) {
+		return "", &ServiceDoesNotExistError{serviceName: s.Command.Label}
+	}
+
+	out, err := s.run("rc-service", s.Command.Label, "status")
+
+	if state := parseOpenRCStatus(out); state != "" {
+		return state, nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("rc-service %s status failed: %v: %s", s.Command.Label, err, strings.TrimSpace(out))
+	}
+
+	return "", fmt.Errorf("cannot parse the output of rc-service %s status: %q", s.Command.Label, out)
+}
+
+/*
+parseOpenRCStatus returns the state of an output like
+" * status: started", empty if there is none
+*/
+func parseOpenRCStatus(out string) string {
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "*"))
+		if strings.HasPrefix(line, "status:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "status:"))
+		}
+	}
+	return ""
+}
+
+/*
+statusOpenRC returns whether or not the service is started. The PID is
+the one of the program supervise-daemon runs or, for forking services,
+the one in their PID file.
+*/
+func (s *SystemService) statusOpenRC() (*ServiceStatus, error) {
+	if err := s.online("Status"); err != nil {
+		return nil, err
+	}
+
+	state, err := s.openrcState()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &ServiceStatus{Running: state == "started"}
+	if !status.Running {
+		return status, nil
+	}
+
+	pidFile := filepath.Join("/run/openrc/options", s.Command.Label, "child_pid")
+	if s.Command.serviceType() == ServiceTypeForking {
+		pidFile = s.Command.PIDFile
+	}
+
+	if content, err := afero.ReadFile(s.fs(), pidFile); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(content))); err == nil {
+			status.PID = pid
+		}
+	}
+
+	return status, nil
+}
+
+/*
+diffOpenRC compares the installed openrc-run script with the one
+Install would write
+*/
+func (s *SystemService) diffOpenRC() (*Diff, error) {
+	script := newOpenRCScript(s)
+
+	content, err := script.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &Diff{}
+
+	f, err := compareFile(s.fs(), script.Path(), content)
+	if err != nil {
+		return nil, err
+	}
+
+	if f != nil {
+		diff.Files = append(diff.Files, *f)
+	}
+
+	return diff, nil
+}
+
+/*
+reconcileOpenRC rewrites the openrc-run script if it changed and
+restarts the service if it was started, installs and starts it if it
+is missing
+*/
+func (s *SystemService) reconcileOpenRC(ctx context.Context) (bool, error) {
+	if err := s.owned(); err != nil {
+		return false, err
+	}
+
+	if err := s.Command.validate(); err != nil {
+		return false, err
+	}
+
+	if err := validateOpenRC(s); err != nil {
+		return false, err
+	}
+
+	diff, err := s.diffOpenRC()
+	if err != nil {
+		return false, err
+	}
+
+	if !diff.Changed() {
+		s.log().Debug("service is up to date")
+		return false, nil
+	}
+
+	s.log().Info("updating service", "diff", diff.String())
+
+	if diff.Files[0].Missing {
+		return true, s.installOpenRC(true)
+	}
+
+	if err := writeFileAtomic(s.fs(), diff.Files[0].Path, []byte(diff.Files[0].Desired), 0755); err != nil {
+		return true, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return true, err
+	}
+
+	if s.Command.Root != "" {
+		return true, nil
+	}
+
+	status, err := s.statusOpenRC()
+	if err != nil || !status.Running {
+		return true, err
+	}
+
+	return true, s.rcService("restart")
+}
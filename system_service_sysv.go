@@ -0,0 +1,448 @@
+// +build linux
+
+package systemservice
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+/*
+rcTools are the tools enabling init scripts, by distribution family.
+Without them the runlevel symlinks are managed directly.
+*/
+var rcTools = []struct {
+	paths   []string
+	enable  []string
+	disable []string
+}{
+	{[]string{"/usr/sbin/update-rc.d", "/sbin/update-rc.d"}, []string{"%s", "defaults"}, []string{"-f", "%s", "remove"}},
+	{[]string{"/sbin/chkconfig", "/usr/sbin/chkconfig"}, []string{"--add", "%s"}, []string{"--del", "%s"}},
+}
+
+/*
+installSysV writes the init script, enables it and, if start is
+passed, (re)starts the service. On failure the previous script and
+state are restored and an InstallError returned.
+*/
+func (s *SystemService) installSysV(start bool) error {
+	if err := s.owned(); err != nil {
+		return err
+	}
+
+	if err := s.Command.validate(); err != nil {
+		return err
+	}
+
+	if err := validateSysV(s); err != nil {
+		return err
+	}
+
+	script := newInitScript(s)
+
+	content, err := script.Generate()
+	if err != nil {
+		return err
+	}
+
+	wasInstalled := fileExists(s.fs(), script.Path())
+	wasRunning := false
+	if s.Command.Root == "" {
+		if status, err := s.statusSysV(); err == nil {
+			wasRunning = status.Running
+		}
+	}
+
+	tx := s.newTransaction()
+	started := false
+
+	err = func() error {
+		s.log().Debug("writing init script", "path", script.Path())
+
+		if err := tx.writeFile(script.Path(), []byte(content), 0755); err != nil {
+			return err
+		}
+
+		s.log().Debug("wrote init script", "content", content)
+
+		if err := s.enableSysV(); err != nil {
+			return err
+		}
+
+		if !start || s.Command.Root != "" {
+			return nil
+		}
+
+		started = true
+
+		action := "start"
+		if wasRunning {
+			action = "restart"
+		}
+		if err := s.runInitScript(action); err != nil {
+			return err
+		}
+
+		return s.verifyStartedSysV()
+	}()
+
+	if err == nil {
+		return nil
+	}
+
+	s.log().Error("install failed, rolling back", "error", err)
+
+	return &InstallError{Err: err, RollbackErr: s.rollbackSysV(tx, started, wasInstalled, wasRunning)}
+}
+
+/*
+rollbackSysV stops the new service if it was started, restores the
+previous script and starts it again if it was running before
+*/
+func (s *SystemService) rollbackSysV(tx *transaction, started, wasInstalled, wasRunning bool) error {
+	if started {
+		if err := s.runInitScript("stop"); err != nil {
+			s.log().Warn("error stopping service", "error", err)
+		}
+	}
+
+	if !wasInstalled {
+		if err := s.disableSysV(); err != nil {
+			s.log().Warn("error disabling service", "error", err)
+		}
+	}
+
+	if err := tx.rollback(); err != nil {
+		return err
+	}
+
+	if wasRunning {
+		return s.runInitScript("start")
+	}
+
+	return nil
+}
+
+/*
+verifyStartedSysV returns an error if the program is not running
+shortly after it was started
+*/
+func (s *SystemService) verifyStartedSysV() error {
+	if s.Command.serviceType() == ServiceTypeOneshot {
+		return nil
+	}
+
+	s.startCheck()
+
+	status, err := s.statusSysV()
+	if err != nil {
+		return err
+	}
+
+	if !status.Running {
+		return fmt.Errorf("%s is not running after starting it", s.Command.Label)
+	}
+
+	return nil
+}
+
+/*
+uninstallSysV stops the service, disables it and removes its script
+*/
+func (s *SystemService) uninstallSysV() error {
+	if err := s.owned(); err != nil {
+		return err
+	}
+
+	if err := s.runInitScript("stop"); err != nil {
+		return err
+	}
+
+	if err := s.disableSysV(); err != nil {
+		return err
+	}
+
+	script := newInitScript(s)
+
+	s.log().Info("removing init script", "path", script.Path())
+
+	if err := s.fs().Remove(script.Path()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+/*
+runInitScript runs an action of the init script of the service. Below a
+Root there is nothing running, the action is skipped.
+*/
+func (s *SystemService) runInitScript(action string) error {
+	if s.Command.Root != "" {
+		s.log().Debug("skipping action below root", "action", action, "root", s.Command.Root)
+		return nil
+	}
+
+	script := newInitScript(s)
+
+	if !fileExists(s.fs(), script.Path()) {
+		if action == "stop" {
+			return nil
+		}
+		return &ServiceDoesNotExistError{serviceName: s.Command.Label}
+	}
+
+	s.log().Info("running init script", "action", action)
+
+	out, err := s.run(script.Path(), action)
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %v: %s", script.Path(), action, err, strings.TrimSpace(out))
+	}
+
+	return nil
+}
+
+/*
+rcTool returns the tool enabling init scripts on the machine and its
+arguments, nil if the runlevel symlinks are managed directly
+*/
+func (s *SystemService) rcTool(enable bool) []string {
+	if s.Command.Root != "" {
+		return nil
+	}
+
+	for _, tool := range rcTools {
+		for _, path := range tool.paths {
+			if !fileExists(s.fs(), path) {
+				continue
+			}
+
+			args := tool.disable
+			if enable {
+				args = tool.enable
+			}
+
+			cmd := []string{path}
+			for _, arg := range args {
+				cmd = append(cmd, strings.Replace(arg, "%s", s.Command.Label, 1))
+			}
+			return cmd
+		}
+	}
+
+	return nil
+}
+
+/*
+enableSysV has the init script run when entering the default runlevels
+*/
+func (s *SystemService) enableSysV() error {
+	if tool := s.rcTool(true); tool != nil {
+		s.log().Info("enabling init script", "command", strings.Join(tool, " "))
+		_, err := s.run(tool[0], tool[1:]...)
+		return err
+	}
+
+	if err := s.disableLinks(); err != nil {
+		return err
+	}
+
+	for _, link := range s.rcLinks() {
+		s.log().Info("creating symlink", "path", link, "target", "../init.d/"+s.Command.Label)
+
+		if err := s.fs().MkdirAll(filepath.Dir(link), 0755); err != nil {
+			return err
+		}
+
+		if err := symlink(s.fs(), "../init.d/"+s.Command.Label, link); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+disableSysV removes the init script from all runlevels
+*/
+func (s *SystemService) disableSysV() error {
+	if tool := s.rcTool(false); tool != nil {
+		s.log().Info("disabling init script", "command", strings.Join(tool, " "))
+		_, err := s.run(tool[0], tool[1:]...)
+		return err
+	}
+
+	return s.disableLinks()
+}
+
+/*
+rcLinks returns the runlevel symlinks of the init script: started in
+runlevels 2 to 5 and killed in 0, 1 and 6, like "update-rc.d defaults"
+*/
+func (s *SystemService) rcLinks() []string {
+	var links []string
+	for _, level := range "0123456" {
+		name := "K80" + s.Command.Label
+		if level >= '2' && level <= '5' {
+			name = "S20" + s.Command.Label
+		}
+		links = append(links, filepath.Join(s.Command.Root, "/etc", "rc"+string(level)+".d", name))
+	}
+	return links
+}
+
+/*
+disableLinks removes the runlevel symlinks of the init script,
+whatever their order
+*/
+func (s *SystemService) disableLinks() error {
+	pattern := filepath.Join(s.Command.Root, "/etc/rc[0-6].d/[SK][0-9][0-9]"+s.Command.Label)
+
+	links, err := afero.Glob(s.fs(), pattern)
+	if err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		s.log().Info("removing symlink", "path", link)
+
+		if err := s.fs().Remove(link); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+statusSysV reads the PID file of the service, it is running if the
+process it names exists
+*/
+func (s *SystemService) statusSysV() (*ServiceStatus, error) {
+	if err := s.online("Status"); err != nil {
+		return nil, err
+	}
+
+	status := &ServiceStatus{}
+
+	content, err := afero.ReadFile(s.fs(), s.Command.sysvPIDFile())
+	if os.IsNotExist(err) {
+		return status, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || pid <= 0 {
+		s.log().Warn("ignoring invalid PID file", "path", s.Command.sysvPIDFile())
+		return status, nil
+	}
+
+	if _, err := s.fs().Stat("/proc/" + strconv.Itoa(pid)); err == nil {
+		status.Running = true
+		status.PID = pid
+	}
+
+	return status, nil
+}
+
+/*
+diffSysV compares the installed init script with the one Install
+would write
+*/
+func (s *SystemService) diffSysV() (*Diff, error) {
+	script := newInitScript(s)
+
+	content, err := script.Generate()
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &Diff{}
+
+	f, err := compareFile(s.fs(), script.Path(), content)
+	if err != nil {
+		return nil, err
+	}
+
+	if f != nil {
+		diff.Files = append(diff.Files, *f)
+	}
+
+	return diff, nil
+}
+
+/*
+reconcileSysV rewrites the init script if it changed and restarts the
+service if it was running, installs and starts it if it is missing
+*/
+func (s *SystemService) reconcileSysV(ctx context.Context) (bool, error) {
+	if err := s.owned(); err != nil {
+		return false, err
+	}
+
+	if err := s.Command.validate(); err != nil {
+		return false, err
+	}
+
+	if err := validateSysV(s); err != nil {
+		return false, err
+	}
+
+	diff, err := s.diffSysV()
+	if err != nil {
+		return false, err
+	}
+
+	if !diff.Changed() {
+		s.log().Debug("service is up to date")
+		return false, nil
+	}
+
+	s.log().Info("updating service", "diff", diff.String())
+
+	if diff.Files[0].Missing {
+		return true, s.installSysV(true)
+	}
+
+	if err := writeFileAtomic(s.fs(), diff.Files[0].Path, []byte(diff.Files[0].Desired), 0755); err != nil {
+		return true, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return true, err
+	}
+
+	if s.Command.Root != "" {
+		return true, nil
+	}
+
+	status, err := s.statusSysV()
+	if err != nil || !status.Running {
+		return true, err
+	}
+
+	return true, s.runInitScript("restart")
+}
+
+/*
+systemdOnly returns an UnsupportedOptionError if the service is not
+managed by systemd
+*/
+func (s *SystemService) systemdOnly(option string) error {
+	if b := s.backend(); b != BackendSystemd {
+		return &UnsupportedOptionError{
+			Backend: string(b),
+			Option:  option,
+			Reason:  "only systemd supports it",
+		}
+	}
+	return nil
+}
@@ -8,11 +8,6 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func init() {
-	// Mock the file system
-	appFS = afero.NewMemMapFs()
-}
-
 func TestServiceStringer(t *testing.T) {
 	assert := assert.New(t)
 	tables := []struct {
@@ -41,6 +36,7 @@ func TestServiceStringer(t *testing.T) {
 
 func TestFileExists(t *testing.T) {
 	assert := assert.New(t)
+	fs := afero.NewMemMapFs()
 	tables := []struct {
 		fileName string
 		setup    func()
@@ -49,7 +45,7 @@ func TestFileExists(t *testing.T) {
 		{
 			fileName: "exists.json",
 			setup: func() {
-				afero.WriteFile(appFS, "exists.json", []byte("[]"), 0644)
+				afero.WriteFile(fs, "exists.json", []byte("[]"), 0644)
 			},
 			expected: true,
 		},
@@ -64,7 +60,16 @@ func TestFileExists(t *testing.T) {
 		e := table.expected
 		fn := table.fileName
 		table.setup()
-		a := fileExists(fn)
+		a := fileExists(fs, fn)
 		assert.Equal(e, a, fmt.Sprintf("file %s should exist: %t", fn, e))
 	}
 }
+
+func TestServiceTypeValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError((&ServiceCommand{Type: ServiceTypeNotifyReload}).validate())
+	assert.Error((&ServiceCommand{Type: "daemon"}).validate())
+	assert.Error((&ServiceCommand{PIDFile: "/run/app.pid"}).validate())
+	assert.Error((&ServiceCommand{Type: ServiceTypeForking, PIDFile: "app.pid"}).validate())
+}
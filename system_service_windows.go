@@ -3,9 +3,11 @@
 package systemservice
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
+	"syscall"
 	"time"
 
 	"golang.org/x/sys/windows/svc"
@@ -14,12 +16,27 @@ import (
 	"golang.org/x/sys/windows/svc/mgr"
 )
 
+/*
+platformBackend is the service manager of the platform
+*/
+const platformBackend = BackendWindows
+
+/*
+platformSupports returns whether or not the backend is available on
+the platform
+*/
+func platformSupports(b Backend) bool {
+	return b == platformBackend
+}
+
 /*
 Run is the process which gets fired when the service starts up
-when the service is installed and started.
+when the service is installed and started. It starts the program and
+maps the stop, shutdown and parameter change requests of the service
+control manager to its callbacks, blocking until the service stops.
 */
-func (s *SystemService) Run() error {
-	logger.Log("running service")
+func (s *SystemService) Run(p Program) error {
+	s.log().Debug("running service")
 
 	name := s.Command.Name
 	debugOn := s.Command.Debug
@@ -30,13 +47,13 @@ func (s *SystemService) Run() error {
 	} else {
 		elog, err = eventlog.Open(name)
 		if err != nil {
-			logger.Log("error opening logs: ", err)
+			s.log().Warn("error opening logs", "error", err)
 			return err
 		}
 	}
 	defer elog.Close()
 
-	logger.Log("starting service: ", name)
+	s.log().Info("starting service", "service", name)
 	elog.Info(1, fmt.Sprintf("starting %s service", name))
 
 	run := svc.Run
@@ -44,14 +61,14 @@ func (s *SystemService) Run() error {
 		run = debug.Run
 	}
 
-	err = run(name, &windowsService{})
+	err = run(name, &windowsService{program: p, stopTimeout: s.Command.stopTimeout(), log: s.log()})
 	if err != nil {
-		logger.Log("error running service: ", err)
+		s.log().Warn("error running service", "error", err)
 		elog.Error(1, fmt.Sprintf("%s service failed: %v", name, err))
 		return err
 	}
 
-	logger.Log("service stopped: ", name)
+	s.log().Debug("service stopped", "service", name)
 	elog.Info(1, fmt.Sprintf("%s service stopped", name))
 
 	// if err := svc.Run(s.Command.Name, &windowsService{}); err != nil {
@@ -63,20 +80,46 @@ func (s *SystemService) Run() error {
 
 /*
 Install the system service. If start is passed, also starts
-the service.
+the service. If the service cannot be started, it is removed again
+and an InstallError returned.
 */
 func (s *SystemService) Install(start bool) error {
+	unlock, err := s.lock("install")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.install(start)
+}
+
+/*
+install is Install for callers holding the lock of the service
+*/
+func (s *SystemService) install(start bool) error {
+	if err := s.owned(); err != nil {
+		return err
+	}
+
+	if err := s.Command.validate(); err != nil {
+		return err
+	}
+
+	if err := validateWindows(s.Command); err != nil {
+		return err
+	}
+
 	name := s.Command.Name
 	exePath := s.Command.Program
 	args := s.Command.Args
 	desc := s.Command.Description
 
-	logger.Log("installing system service: ", name)
+	s.log().Info("installing system service", "service", name)
 
 	// Connect to Windows service manager
 	m, err := mgr.Connect()
 	if err != nil {
-		logger.Log("error connecting to service manager: ", err)
+		s.log().Warn("error connecting to service manager", "error", err)
 		return err
 	}
 	defer m.Disconnect()
@@ -84,12 +127,12 @@ func (s *SystemService) Install(start bool) error {
 	// Open the service so we can manage it
 	srv, err := m.OpenService(name)
 	if err == nil {
-		logger.Log("error opening the service: ", name)
+		s.log().Warn("service already exists", "service", name)
 		srv.Close()
 		return fmt.Errorf("service %s already exists", name)
 	}
 
-	logger.Logf("creating service \"%s\" at path \"%s\" with args \"%s\"", name, exePath, args)
+	s.log().Info("creating service", "service", name, "path", exePath, "args", args)
 
 	// Create the system service
 	conf := mgr.Config{
@@ -99,28 +142,42 @@ func (s *SystemService) Install(start bool) error {
 	}
 	srv, err = m.CreateService(name, exePath, conf, args...)
 	if err != nil {
-		logger.Log("error creating service: ", err)
+		s.log().Warn("error creating service", "error", err)
 		return err
 	}
 	defer srv.Close()
 
+	if env := s.Command.environment(); len(env) > 0 {
+		s.log().Info("setting service environment", "service", name)
+
+		if err := setServiceEnvironment(name, env); err != nil {
+			s.log().Warn("error setting service environment", "error", err)
+			srv.Delete()
+			return fmt.Errorf("setting service environment failed: %s", err)
+		}
+	}
+
 	// Remove event log if it is there
 	_ = eventlog.Remove(name)
 
-	logger.Log("setting up event logs: ", name)
+	s.log().Info("setting up event logs", "service", name)
 
 	err = eventlog.InstallAsEventCreate(name, eventlog.Error|eventlog.Warning|eventlog.Info)
 	if err != nil {
-		logger.Log("error creating service logs: ", err)
+		s.log().Warn("error creating service logs", "error", err)
 		srv.Delete()
 		return fmt.Errorf("setting up event log failed: %s", err)
 	}
 
-	logger.Log("starting service: ", name)
+	s.log().Info("starting service", "service", name)
 	if start {
-		if err := s.Start(); err != nil {
-			logger.Log("error starting service: ", err)
-			return err
+		if err := s.start(); err != nil {
+			s.log().Warn("error starting service, removing it again", "error", err)
+
+			// The service did not exist before, so rolling back
+			// means removing it
+			_ = eventlog.Remove(name)
+			return &InstallError{Err: err, RollbackErr: srv.Delete()}
 		}
 	}
 
@@ -139,7 +196,7 @@ func (s *SystemService) Install(start bool) error {
 	// 	// "boot",
 	// }
 
-	// out, err := runScCommand(args...)
+	// out, err := s.sc(args...)
 
 	// if err != nil {
 	// 	if strings.Contains(err.Error(), "exit status 1073") {
@@ -155,44 +212,97 @@ func (s *SystemService) Install(start bool) error {
 	// // }
 }
 
+/*
+validateWindows returns an error if the command uses an option the
+service control manager has no equivalent for
+*/
+func validateWindows(cmd ServiceCommand) error {
+	if cmd.Root != "" {
+		return &UnsupportedOptionError{
+			Backend: "windows",
+			Option:  "Root",
+			Reason:  "services are registered with the running service control manager",
+		}
+	}
+
+	if cmd.Sockets.enabled() {
+		return &UnsupportedOptionError{
+			Backend: "windows",
+			Option:  "Sockets",
+			Reason:  "the service control manager cannot start services on demand",
+		}
+	}
+
+	if cmd.Schedule.enabled() {
+		return &UnsupportedOptionError{
+			Backend: "windows",
+			Option:  "Schedule",
+			Reason:  "use the task scheduler for periodic jobs",
+		}
+	}
+
+	if len(cmd.Triggers) > 0 {
+		return &UnsupportedOptionError{
+			Backend: "windows",
+			Option:  "Triggers",
+			Reason:  "the service control manager cannot watch paths",
+		}
+	}
+
+	return nil
+}
+
 /*
 Start the system service if it is installed
 */
 func (s *SystemService) Start() error {
+	unlock, err := s.lock("start")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.start()
+}
+
+/*
+start is Start for callers holding the lock of the service
+*/
+func (s *SystemService) start() error {
 	name := s.Command.Name
 
-	logger.Log("starting system service: ", name)
+	s.log().Info("starting system service", "service", name)
 
 	// Connect to Windows service manager
 	m, err := mgr.Connect()
 	if err != nil {
-		logger.Log("error connecting to service manager: ", err)
+		s.log().Warn("error connecting to service manager", "error", err)
 		return fmt.Errorf("could not connect to service manager: %v", err)
 	}
 	defer m.Disconnect()
 
-	logger.Log("opening system service")
+	s.log().Debug("opening system service")
 
 	// Open the service so we can manage it
 	srv, err := m.OpenService(name)
 	if err != nil {
-		logger.Log("error opening service: ", err)
+		s.log().Warn("error opening service", "error", err)
 		return fmt.Errorf("could not access service: %v", err)
 	}
 	defer srv.Close()
 
-	logger.Log("attempting to start system service")
+	s.log().Debug("attempting to start system service")
 
 	err = srv.Start(s.Command.Args...)
 	if err != nil {
-		logger.Log("error starting service: ", err)
+		s.log().Warn("error starting service", "error", err)
 		return fmt.Errorf("could not start service: %v", err)
 	}
 
-	logger.Log("running service")
+	s.log().Debug("running service")
 
 	return nil
-	// _, err := runScCommand("start", fmt.Sprintf("\"%s\"", s.Command.Name))
+	// _, err := s.sc("start", fmt.Sprintf("\"%s\"", s.Command.Name))
 
 	// if err != nil {
 	// 	logger.Log("start service error: ", err)
@@ -206,11 +316,24 @@ func (s *SystemService) Start() error {
 Restart attempts to stop the service if running then starts it again
 */
 func (s *SystemService) Restart() error {
-	if err := s.Stop(); err != nil {
+	unlock, err := s.lock("restart")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.restart()
+}
+
+/*
+restart is Restart for callers holding the lock of the service
+*/
+func (s *SystemService) restart() error {
+	if err := s.stop(); err != nil {
 		return err
 	}
 
-	if err := s.Start(); err != nil {
+	if err := s.start(); err != nil {
 		return err
 	}
 
@@ -221,6 +344,19 @@ func (s *SystemService) Restart() error {
 Stop stops the system service by unloading the unit file
 */
 func (s *SystemService) Stop() error {
+	unlock, err := s.lock("stop")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.stop()
+}
+
+/*
+stop is Stop for callers holding the lock of the service
+*/
+func (s *SystemService) stop() error {
 	err := s.control(svc.Stop, svc.Stopped)
 	if err != nil {
 		e := err.Error()
@@ -236,10 +372,10 @@ func (s *SystemService) Stop() error {
 	for {
 		attempt++
 
-		logger.Log("waiting for service to stop")
+		s.log().Debug("waiting for service to stop")
 
 		// // Wait a few seconds before retrying.
-		time.Sleep(wait)
+		s.sleep(wait)
 
 		// // Attempt to start the service again.
 		stat, err := s.Status()
@@ -264,7 +400,7 @@ func (s *SystemService) Stop() error {
 	}
 
 	return nil
-	// _, err := runScCommand("stop", fmt.Sprintf("\"%s\"", s.Command.Name))
+	// _, err := s.sc("stop", fmt.Sprintf("\"%s\"", s.Command.Name))
 
 	// if err != nil {
 	// 	logger.Log("stop service error: ", err)
@@ -284,6 +420,16 @@ Uninstall the system service by first stopping it then removing
 the unit file.
 */
 func (s *SystemService) Uninstall() error {
+	unlock, err := s.lock("uninstall")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := s.owned(); err != nil {
+		return err
+	}
+
 	name := s.Command.Name
 
 	// Connect to Windows service manager
@@ -319,13 +465,13 @@ func (s *SystemService) Uninstall() error {
 	return nil
 	// name := s.Command.Name
 
-	// err := s.Stop()
+	// err := s.stop()
 
 	// if err != nil {
 	// 	return err
 	// }
 
-	// _, err = runScCommand("delete", fmt.Sprintf("\"%s\"", name))
+	// _, err = s.sc("delete", fmt.Sprintf("\"%s\"", name))
 
 	// if err != nil {
 	// 	logger.Log("delete service error: ", err)
@@ -339,36 +485,40 @@ func (s *SystemService) Uninstall() error {
 Status returns whether or not the system service is running
 */
 func (s *SystemService) Status() (status *ServiceStatus, err error) {
+	if err := s.checkBackend(); err != nil {
+		return nil, err
+	}
+
 	name := s.Command.Name
 	status = &ServiceStatus{}
 
-	logger.Log("connecting to service manager: ", name)
+	s.log().Debug("connecting to service manager", "service", name)
 
 	// Connect to Windows service manager
 	m, err := mgr.Connect()
 	if err != nil {
-		logger.Log("error connecting to service manager: ", err)
+		s.log().Warn("error connecting to service manager", "error", err)
 		return status, fmt.Errorf("could not connect to service manager: %v", err)
 	}
 	defer m.Disconnect()
 
-	logger.Log("opening system service")
+	s.log().Debug("opening system service")
 
 	// Open the service so we can manage it
 	srv, err := m.OpenService(name)
 	if err != nil {
-		logger.Log("error opening service: ", err)
+		s.log().Warn("error opening service", "error", err)
 		return status, fmt.Errorf("could not access service: %v", err)
 	}
 	defer srv.Close()
 
 	stat, err := srv.Query()
 	if err != nil {
-		logger.Log("error getting service status: ", err)
+		s.log().Warn("error getting service status", "error", err)
 		return status, fmt.Errorf("could not get service status: %v", err)
 	}
 
-	logger.Logf("service status: %#v", stat)
+	s.log().Debug("service status", "state", stat.State, "pid", stat.ProcessId)
 
 	status.PID = int(stat.ProcessId)
 	status.Running = stat.State == svc.Running
@@ -379,10 +529,10 @@ func (s *SystemService) Status() (status *ServiceStatus, err error) {
 Return whether or not the unit file eixts
 */
 func (s *SystemService) Exists() bool {
-	_, err := runScCommand("queryex", fmt.Sprintf("\"%s\"", s.Command.Name))
+	_, err := s.sc("queryex", fmt.Sprintf("\"%s\"", s.Command.Name))
 
 	if err != nil {
-		logger.Log("exists service error: ", err)
+		s.log().Debug("exists service error", "error", err)
 		// Service does not exist
 		// if strings.Contains(err.Error(), "FAILED 1060") {
 		// return false
@@ -413,14 +563,14 @@ func (s *SystemService) control(command svc.Cmd, state svc.State) error {
 		return fmt.Errorf("could not send control=%d: %v", command, err)
 	}
 
-	timeout := time.Now().Add(10 * time.Second)
+	timeout := s.now().Add(10 * time.Second)
 	for status.State != state {
 		// Exit if a timeout is reached
-		if timeout.Before(time.Now()) {
+		if timeout.Before(s.now()) {
 			return fmt.Errorf("timeout waiting for service to go to state=%d", state)
 		}
 
-		time.Sleep(300 * time.Millisecond)
+		s.sleep(300 * time.Millisecond)
 
 		// Make sure transition happens to the desired state
 		status, err = srv.Query()
@@ -514,3 +664,341 @@ func (s *SystemService) control(command svc.Cmd, state svc.State) error {
 // logger.Logf("service: %+v", serv)
 
 // pid := getPID(name)
+
+/*
+InstallInstance installs an instance of the service. The instance is a
+copy of the service whose label and name are suffixed with
+"@<instance>" and where "%i" in the arguments and environment is
+replaced by the instance name. If start is passed, also starts the
+instance.
+*/
+func (s *SystemService) InstallInstance(instance string, start bool) error {
+	if err := validateInstance(instance); err != nil {
+		return err
+	}
+
+	if err := s.Command.validateInstanced(); err != nil {
+		return err
+	}
+
+	return s.instance(instance).Install(start)
+}
+
+/*
+StartInstance starts an instance of the service
+*/
+func (s *SystemService) StartInstance(instance string) error {
+	if err := validateInstance(instance); err != nil {
+		return err
+	}
+
+	return s.instance(instance).Start()
+}
+
+/*
+StopInstance stops an instance of the service
+*/
+func (s *SystemService) StopInstance(instance string) error {
+	if err := validateInstance(instance); err != nil {
+		return err
+	}
+
+	return s.instance(instance).Stop()
+}
+
+/*
+UninstallInstance stops and removes an instance of the service
+*/
+func (s *SystemService) UninstallInstance(instance string) error {
+	if err := validateInstance(instance); err != nil {
+		return err
+	}
+
+	return s.instance(instance).Uninstall()
+}
+
+/*
+instance returns the service of a single instance
+*/
+func (s *SystemService) instance(instance string) *SystemService {
+	inst := *s
+	inst.Command = s.Command.forInstance(instance)
+	return &inst
+}
+
+/*
+ListInstances returns the names of the installed instances of the
+service
+*/
+func (s *SystemService) ListInstances() ([]string, error) {
+	if err := s.checkBackend(); err != nil {
+		return nil, err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, err
+	}
+	defer m.Disconnect()
+
+	names, err := m.ListServices()
+	if err != nil {
+		return nil, fmt.Errorf("could not list services: %v", err)
+	}
+
+	return instancesFromNames(s.Command.Name, names), nil
+}
+
+/*
+SetOverride is not supported, services have no drop-in configuration
+*/
+func (s *SystemService) SetOverride(name string, directives Section) error {
+	return s.overridesUnsupported()
+}
+
+/*
+ListOverrides is not supported, services have no drop-in configuration
+*/
+func (s *SystemService) ListOverrides() ([]string, error) {
+	return nil, s.overridesUnsupported()
+}
+
+/*
+RemoveOverride is not supported, services have no drop-in configuration
+*/
+func (s *SystemService) RemoveOverride(name string) error {
+	return s.overridesUnsupported()
+}
+
+/*
+EffectiveConfig is not supported, services have no drop-in configuration
+*/
+func (s *SystemService) EffectiveConfig() ([]Section, error) {
+	return nil, s.overridesUnsupported()
+}
+
+func (s *SystemService) overridesUnsupported() error {
+	return &UnsupportedOptionError{
+		Backend: "windows",
+		Option:  "drop-in overrides",
+		Reason:  "services have no drop-in configuration",
+	}
+}
+
+/*
+windowsConfig is the part of the configuration of a service which is
+derived from the command
+*/
+type windowsConfig struct {
+	BinaryPathName string
+	DisplayName    string
+	Description    string
+	StartType      uint32
+	Environment    []string
+}
+
+/*
+String lists the configuration one setting per line, so it can be
+diffed like a file
+*/
+func (c windowsConfig) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "BinaryPathName=%s\n", c.BinaryPathName)
+	fmt.Fprintf(&b, "DisplayName=%s\n", c.DisplayName)
+	fmt.Fprintf(&b, "Description=%s\n", c.Description)
+	fmt.Fprintf(&b, "StartType=%d\n", c.StartType)
+	for _, e := range c.Environment {
+		fmt.Fprintf(&b, "Environment=%s\n", e)
+	}
+	return b.String()
+}
+
+/*
+desiredConfig returns the configuration Install creates the service
+with. The binary path is quoted the way CreateService does it.
+*/
+func (s *SystemService) desiredConfig() windowsConfig {
+	path := syscall.EscapeArg(s.Command.Program)
+	for _, arg := range s.Command.Args {
+		path += " " + syscall.EscapeArg(arg)
+	}
+
+	return windowsConfig{
+		BinaryPathName: path,
+		DisplayName:    s.Command.Name,
+		Description:    s.Command.Description,
+		StartType:      mgr.StartAutomatic,
+		Environment:    s.Command.environment(),
+	}
+}
+
+/*
+installedConfig returns the configuration of the installed service,
+or nil if it is not installed
+*/
+func (s *SystemService) installedConfig(m *mgr.Mgr) (*windowsConfig, error) {
+	srv, err := m.OpenService(s.Command.Name)
+	if err != nil {
+		return nil, nil
+	}
+	defer srv.Close()
+
+	conf, err := srv.Config()
+	if err != nil {
+		return nil, fmt.Errorf("could not read service config: %v", err)
+	}
+
+	env, err := getServiceEnvironment(s.Command.Name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read service environment: %v", err)
+	}
+
+	return &windowsConfig{
+		BinaryPathName: conf.BinaryPathName,
+		DisplayName:    conf.DisplayName,
+		Description:    conf.Description,
+		StartType:      conf.StartType,
+		Environment:    env,
+	}, nil
+}
+
+/*
+Diff compares the configuration of the installed service with the one
+Install would create. The registry key of the service is used as the
+path of the diff.
+*/
+func (s *SystemService) Diff() (*Diff, error) {
+	if err := s.checkBackend(); err != nil {
+		return nil, err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to service manager: %v", err)
+	}
+	defer m.Disconnect()
+
+	return s.diff(m)
+}
+
+func (s *SystemService) diff(m *mgr.Mgr) (*Diff, error) {
+	diff := &Diff{}
+	path := `HKEY_LOCAL_MACHINE\` + serviceKey(s.Command.Name)
+	desired := s.desiredConfig().String()
+
+	installed, err := s.installedConfig(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if installed == nil {
+		diff.Files = append(diff.Files, FileDiff{Path: path, Desired: desired, Missing: true})
+	} else if installed.String() != desired {
+		diff.Files = append(diff.Files, FileDiff{Path: path, Installed: installed.String(), Desired: desired})
+	}
+
+	return diff, nil
+}
+
+/*
+Reconcile installs or updates the service so it matches the command.
+The configuration is only updated if it changed, in which case the
+service is restarted if it was running and its program, arguments or
+environment changed. New services are installed and started. Returns
+whether or not anything changed.
+*/
+func (s *SystemService) Reconcile(ctx context.Context) (bool, error) {
+	unlock, err := s.lock("reconcile")
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	if err := s.owned(); err != nil {
+		return false, err
+	}
+
+	if err := s.Command.validate(); err != nil {
+		return false, err
+	}
+
+	if err := validateWindows(s.Command); err != nil {
+		return false, err
+	}
+
+	name := s.Command.Name
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return false, fmt.Errorf("could not connect to service manager: %v", err)
+	}
+	defer m.Disconnect()
+
+	diff, err := s.diff(m)
+	if err != nil {
+		return false, err
+	}
+
+	if !diff.Changed() {
+		s.log().Debug("service is up to date", "service", name)
+		return false, nil
+	}
+
+	s.log().Info("updating service", "diff", diff.String())
+
+	if diff.Files[0].Missing {
+		return true, s.install(true)
+	}
+
+	installed, err := s.installedConfig(m)
+	if err != nil {
+		return true, err
+	}
+
+	desired := s.desiredConfig()
+
+	srv, err := m.OpenService(name)
+	if err != nil {
+		return true, fmt.Errorf("could not access service: %v", err)
+	}
+	defer srv.Close()
+
+	conf, err := srv.Config()
+	if err != nil {
+		return true, fmt.Errorf("could not read service config: %v", err)
+	}
+
+	conf.BinaryPathName = desired.BinaryPathName
+	conf.DisplayName = desired.DisplayName
+	conf.Description = desired.Description
+	conf.StartType = desired.StartType
+
+	if err := srv.UpdateConfig(conf); err != nil {
+		return true, fmt.Errorf("could not update service config: %v", err)
+	}
+
+	if err := setServiceEnvironment(name, desired.Environment); err != nil {
+		return true, fmt.Errorf("setting service environment failed: %s", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return true, err
+	}
+
+	// The display name, description and start type apply right away
+	restart := installed.BinaryPathName != desired.BinaryPathName ||
+		strings.Join(installed.Environment, "\n") != strings.Join(desired.Environment, "\n")
+
+	if !restart {
+		return true, nil
+	}
+
+	status, err := s.Status()
+	if err != nil || !status.Running {
+		return true, nil
+	}
+
+	s.log().Info("restarting service", "service", name)
+
+	return true, s.restart()
+}
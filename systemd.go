@@ -3,17 +3,21 @@
 package systemservice
 
 import (
-	"bytes"
-	"os"
+	"fmt"
 	"path/filepath"
 	"strings"
-	"text/template"
+
+	"github.com/danawoodman/systemservice/unit"
 )
 
-func runSystemCtlCommand(cmd string, label string) (out string, err error) {
+/*
+systemctl runs a systemctl command for the unit, for the user's
+service manager if the service is a user service
+*/
+func (s *SystemService) systemctl(cmd string, label string) (out string, err error) {
 	args := strings.Split(cmd, " ")
 
-	if !isRoot() {
+	if s.scope() == ScopeUser {
 		args = append(args, "--user")
 	}
 
@@ -21,22 +25,44 @@ func runSystemCtlCommand(cmd string, label string) (out string, err error) {
 		args = append(args, label)
 	}
 
-	logger.Log("running command: systemctl ", strings.Join(args, " "))
+	return s.run("systemctl", args...)
+}
 
-	return runCommand("systemctl", args...)
+/*
+generatedFile is a file written by Install and removed by Uninstall
+*/
+type generatedFile interface {
+	Generate() (string, error)
+	Path() string
 }
 
 /*
 unitFile represents a launchctl unitFile file
 */
 type unitFile struct {
-	Label         string
-	Command       string
-	Description   string
-	Documentation string
-	StdOutPath    string
-	StdErrPath    string
-	User          string
+	Dir             string
+	Label           string
+	Template        bool
+	Command         string
+	Description     string
+	Documentation   string
+	StdOutPath      string
+	StdErrPath      string
+	User            string
+	Type            ServiceType
+	Restart         string
+	PIDFile         string
+	RemainAfterExit bool
+	WatchdogSec     string
+	NotifyAccess    string
+	TimeoutStopSec  string
+	Environment     []Directive
+	Dependencies    []Directive
+	Account         []Directive
+	Directories     []Directive
+	Hardening       []Directive
+	WantedBy        string
+	Extra           []Section
 }
 
 func newUnitFile(serv *SystemService) unitFile {
@@ -44,63 +70,344 @@ func newUnitFile(serv *SystemService) unitFile {
 	label := cmd.Label
 
 	user := username()
-	if isRoot() {
+	if serv.scope() == ScopeSystem {
 		user = "root"
 	}
 
+	// Oneshot services run to completion, restarting them on failure
+	// is not supported by older systemd versions.
+	restart := "on-failure"
+	if cmd.serviceType() == ServiceTypeOneshot {
+		restart = "no"
+	}
+
+	// Only notify services accept notifications by default, others
+	// need to opt in to be able to ping the watchdog.
+	watchdog, notifyAccess := "", ""
+	if cmd.WatchdogSec > 0 {
+		watchdog = timespan(cmd.WatchdogSec)
+		if t := cmd.serviceType(); t != ServiceTypeNotify && t != ServiceTypeNotifyReload {
+			notifyAccess = "main"
+		}
+	}
+
+	timeoutStop := ""
+	if cmd.StopTimeout > 0 {
+		timeoutStop = timespan(cmd.StopTimeout)
+	}
+
 	unit := unitFile{
-		Label:         label,
-		Command:       cmd.String(),
-		Description:   cmd.Description,
-		Documentation: cmd.Documentation,
-		User:          user,
+		Dir:             serv.unitDir(),
+		Label:           label,
+		Template:        cmd.Sockets.Accept,
+		Command:         unit.QuoteTemplateCommand(append([]string{cmd.Program}, cmd.Args...)...),
+		Description:     cmd.Description,
+		Documentation:   cmd.Documentation,
+		User:            user,
+		Type:            cmd.serviceType(),
+		Restart:         restart,
+		PIDFile:         cmd.PIDFile,
+		RemainAfterExit: cmd.RemainAfterExit,
+		WatchdogSec:     watchdog,
+		NotifyAccess:    notifyAccess,
+		TimeoutStopSec:  timeoutStop,
+		Environment:     cmd.environmentDirectives(),
+		Dependencies:    cmd.Dependencies.directives(),
+		Account:         cmd.accountDirectives(),
+		Directories:     cmd.Directories.directives(),
+		Hardening:       cmd.Hardening.directives(),
+		WantedBy:        serv.scope().defaultTarget(),
+		Extra:           cmd.extraSections(""),
 	}
 
 	return unit
 }
 
 func (u *unitFile) Generate() (string, error) {
-	var tmpl bytes.Buffer
-	t := template.Must(template.New("unitFile").Parse(unitFileTemplate()))
-	if err := t.Execute(&tmpl, u); err != nil {
-		return "", err
+	return generateUnit(u.File())
+}
+
+/*
+File returns the service unit
+*/
+func (u *unitFile) File() *unit.File {
+	f := &unit.File{Name: u.Name()}
+
+	f.Add("Unit", u.Dependencies...)
+	f.Add("Unit",
+		Directive{Key: "Description", Value: u.Description},
+		Directive{Key: "Documentation", Value: u.Documentation},
+	)
+
+	f.Add("Service",
+		Directive{Key: "ExecStart", Value: u.Command},
+		Directive{Key: "Restart", Value: u.Restart},
+		Directive{Key: "Type", Value: string(u.Type)},
+	)
+	remainAfterExit := ""
+	if u.RemainAfterExit {
+		remainAfterExit = "yes"
+	}
+	for _, d := range []Directive{
+		{Key: "PIDFile", Value: u.PIDFile},
+		{Key: "RemainAfterExit", Value: remainAfterExit},
+		{Key: "WatchdogSec", Value: u.WatchdogSec},
+		{Key: "NotifyAccess", Value: u.NotifyAccess},
+		{Key: "TimeoutStopSec", Value: u.TimeoutStopSec},
+	} {
+		// Optional directives are left out rather than reset
+		if d.Value != "" {
+			f.Add("Service", d)
+		}
+	}
+	f.Add("Service", u.Environment...)
+	if u.Template {
+		// Services started per connection talk to the client over
+		// their standard input and output, which inherits the socket
+		f.Add("Service", Directive{Key: "StandardInput", Value: "socket"})
+	} else {
+		f.Add("Service", Directive{Key: "StandardOutput", Value: "null"})
 	}
+	f.Add("Service", u.Account...)
+	f.Add("Service", u.Directories...)
+	f.Add("Service", u.Hardening...)
 
-	return tmpl.String(), nil
+	f.Add("Install", Directive{Key: "WantedBy", Value: u.WantedBy})
+
+	addSections(f, u.Extra)
+
+	return f
+}
+
+/*
+Name returns the name of the unit. Services started per connection
+are installed as a template.
+*/
+func (u *unitFile) Name() string {
+	if u.Template {
+		return u.Label + "@.service"
+	}
+	return u.Label + ".service"
 }
 
 func (u *unitFile) Path() string {
-	file := u.Label + ".service"
+	return filepath.Join(u.Dir, u.Name())
+}
+
+/*
+socketUnitFile represents the companion .socket unit of a socket
+activated service
+*/
+type socketUnitFile struct {
+	Dir         string
+	Label       string
+	Description string
+	Sockets     []Directive
+	Extra       []Section
+}
+
+func newSocketUnitFile(serv *SystemService) socketUnitFile {
+	cmd := serv.Command
+
+	description := cmd.Description
+	if description == "" {
+		description = cmd.Label
+	}
+
+	return socketUnitFile{
+		Dir:         serv.unitDir(),
+		Label:       cmd.Label,
+		Description: description,
+		Sockets:     cmd.Sockets.directives(),
+		Extra:       cmd.extraSections("Socket"),
+	}
+}
+
+func (u *socketUnitFile) Generate() (string, error) {
+	f := companionUnit(u.Name(), u.Description+" (sockets)", "Socket", u.Sockets, "sockets.target")
+	addSections(f, u.Extra)
+	return generateUnit(f)
+}
+
+func (u *socketUnitFile) Name() string {
+	return u.Label + ".socket"
+}
+
+func (u *socketUnitFile) Path() string {
+	return filepath.Join(u.Dir, u.Name())
+}
+
+/*
+timerUnitFile represents the companion .timer unit of a scheduled
+service
+*/
+type timerUnitFile struct {
+	Dir         string
+	Label       string
+	Description string
+	Schedule    Schedule
+	Timers      []Directive
+	Extra       []Section
+}
+
+func newTimerUnitFile(serv *SystemService) timerUnitFile {
+	cmd := serv.Command
+
+	description := cmd.Description
+	if description == "" {
+		description = cmd.Label
+	}
 
-	if isRoot() {
-		return filepath.Join("/etc/systemd/system", file)
+	return timerUnitFile{
+		Dir:         serv.unitDir(),
+		Label:       cmd.Label,
+		Description: description,
+		Schedule:    cmd.Schedule,
+		Extra:       cmd.extraSections("Timer"),
 	}
+}
+
+func (u *timerUnitFile) Generate() (string, error) {
+	timers, err := u.Schedule.directives()
+	if err != nil {
+		return "", err
+	}
+	u.Timers = timers
+
+	f := companionUnit(u.Name(), u.Description+" (timer)", "Timer", u.Timers, "timers.target")
+	addSections(f, u.Extra)
+	return generateUnit(f)
+}
+
+func (u *timerUnitFile) Name() string {
+	return u.Label + ".timer"
+}
+
+func (u *timerUnitFile) Path() string {
+	return filepath.Join(u.Dir, u.Name())
+}
+
+/*
+pathUnitFile represents the companion .path unit of a service started
+by triggers
+*/
+type pathUnitFile struct {
+	Dir         string
+	Label       string
+	Description string
+	Paths       []Directive
+	Extra       []Section
+}
+
+func newPathUnitFile(serv *SystemService) pathUnitFile {
+	cmd := serv.Command
+
+	description := cmd.Description
+	if description == "" {
+		description = cmd.Label
+	}
+
+	return pathUnitFile{
+		Dir:         serv.unitDir(),
+		Label:       cmd.Label,
+		Description: description,
+		Paths:       cmd.triggerDirectives(),
+		Extra:       cmd.extraSections("Path"),
+	}
+}
+
+func (u *pathUnitFile) Generate() (string, error) {
+	f := companionUnit(u.Name(), u.Description+" (triggers)", "Path", u.Paths, "paths.target")
+	addSections(f, u.Extra)
+	return generateUnit(f)
+}
+
+func (u *pathUnitFile) Name() string {
+	return u.Label + ".path"
+}
+
+func (u *pathUnitFile) Path() string {
+	return filepath.Join(u.Dir, u.Name())
+}
 
-	return filepath.Join(homeDir(), ".config/systemd/user", file)
+/*
+dropInFile represents a drop-in overriding directives of a unit
+*/
+type dropInFile struct {
+	Dir     string
+	Unit    string
+	Name    string
+	Section Section
+}
+
+func newDropInFile(serv *SystemService, name string, section Section) dropInFile {
+	unit := newUnitFile(serv)
+	return dropInFile{Dir: serv.dropInDir(unit.Name()), Unit: unit.Name(), Name: name, Section: section}
+}
+
+func (d *dropInFile) Generate() (string, error) {
+	return formatSections([]Section{d.Section}), nil
+}
+
+func (d *dropInFile) Path() string {
+	return filepath.Join(d.Dir, d.Name+".conf")
 }
 
-func (u *unitFile) Remove() error {
-	return os.Remove(u.Path())
+/*
+dropInDir returns the folder the drop-ins of a unit are written to
+*/
+func (s *SystemService) dropInDir(unit string) string {
+	return filepath.Join(s.unitDir(), unit+".d")
 }
 
 /*
-unitFileTemplate generates the contents of the unitFile file.
+unitDir returns the folder units are installed to, below Root if set
 */
-func unitFileTemplate() string {
-	return `[Unit]
-After=network.target
-Description={{ .Description }}
-Documentation={{ .Documentation }}
+func (s *SystemService) unitDir() string {
+	return filepath.Join(s.Command.Root, s.scope().unitDir())
+}
 
-[Service]
-ExecStart={{ .Command }}
-Restart=on-failure
-Type=simple
-StandardOutput=null
+/*
+unitDir returns the folder units of the scope are installed to
+*/
+func (s Scope) unitDir() string {
+	if s == ScopeSystem {
+		return "/etc/systemd/system"
+	}
 
-[Install]
-WantedBy=multi-user.target
-`
+	return filepath.Join(homeDir(), ".config/systemd/user")
 }
 
-// StandardOutput=file:{{ .LogPath }}
+/*
+companionUnit returns a unit activating the service through the
+directives of its section, e.g. a .socket or .timer unit
+*/
+func companionUnit(name string, description string, section string, directives []Directive, wantedBy string) *unit.File {
+	f := &unit.File{Name: name}
+	f.Add("Unit", Directive{Key: "Description", Value: description})
+	f.Add(section, directives...)
+	f.Add("Install", Directive{Key: "WantedBy", Value: wantedBy})
+	return f
+}
+
+/*
+addSections appends the directives of the sections to the sections of
+the same name, adding the sections which the unit does not have
+*/
+func addSections(f *unit.File, sections []Section) {
+	for _, s := range sections {
+		f.Add(s.Name, s.Directives...)
+	}
+}
+
+/*
+generateUnit returns the contents of a unit, or an error if a value
+would change the meaning of the file
+*/
+func generateUnit(f *unit.File) (string, error) {
+	var b strings.Builder
+	if _, err := f.WriteTo(&b); err != nil {
+		return "", fmt.Errorf("%s: %v", f.Name, err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,317 @@
+// +build linux
+
+package systemservice
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	systemdDestination = "org.freedesktop.systemd1"
+	systemdPath        = dbus.ObjectPath("/org/freedesktop/systemd1")
+	managerInterface   = "org.freedesktop.systemd1.Manager"
+)
+
+/*
+jobTimeout is how long to wait for a job to finish. systemd cancels
+jobs on its own after the timeouts of the unit, this only guards
+against missing signals.
+*/
+var jobTimeout = 5 * time.Minute
+
+/*
+busConn is the part of a D-Bus connection the manager uses
+*/
+type busConn interface {
+	Object(dest string, path dbus.ObjectPath) dbus.BusObject
+	AddMatchSignal(options ...dbus.MatchOption) error
+	RemoveMatchSignal(options ...dbus.MatchOption) error
+	Signal(ch chan<- *dbus.Signal)
+	RemoveSignal(ch chan<- *dbus.Signal)
+}
+
+/*
+dbusManager manages units through the org.freedesktop.systemd1.Manager
+interface of systemd
+*/
+type dbusManager struct {
+	service *SystemService
+	conn    busConn
+	manager dbus.BusObject
+}
+
+/*
+newDBusManager connects to the system bus, or the session bus of the
+user for user services, and checks that systemd answers on it
+*/
+func newDBusManager(s *SystemService) (*dbusManager, error) {
+	connect := dbus.SystemBus
+	if s.scope() == ScopeUser {
+		connect = dbus.SessionBus
+	}
+
+	conn, err := connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return newDBusManagerOn(s, conn)
+}
+
+/*
+newDBusManagerOn returns a manager using the given connection
+*/
+func newDBusManagerOn(s *SystemService, conn busConn) (*dbusManager, error) {
+	m := &dbusManager{service: s, conn: conn, manager: conn.Object(systemdDestination, systemdPath)}
+
+	if _, err := m.manager.GetProperty(managerInterface + ".Version"); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *dbusManager) call(method string, args ...interface{}) *dbus.Call {
+	return m.manager.Call(managerInterface+"."+method, 0, args...)
+}
+
+func (m *dbusManager) Reload() error {
+	return m.call("Reload").Err
+}
+
+func (m *dbusManager) Start(name string) error {
+	return m.job("StartUnit", name)
+}
+
+func (m *dbusManager) Stop(name string) error {
+	names := []string{name}
+
+	// StopUnit does not take patterns
+	if strings.ContainsAny(name, "*?[") {
+		var err error
+		if names, err = m.ListUnits(name); err != nil {
+			return err
+		}
+	}
+
+	for _, n := range names {
+		if err := m.job("StopUnit", n); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *dbusManager) Restart(name string) error {
+	return m.job("ReloadOrRestartUnit", name)
+}
+
+func (m *dbusManager) TryRestart(name string) error {
+	return m.job("TryRestartUnit", name)
+}
+
+func (m *dbusManager) ResetFailed() error {
+	return m.call("ResetFailed").Err
+}
+
+/*
+subscribe asks systemd to send signals to the connection, which it
+only does for subscribed clients. The subscription lasts as long as
+the connection, subscribing again is not an error.
+*/
+func (m *dbusManager) subscribe() error {
+	err := m.call("Subscribe").Err
+
+	var dbusErr dbus.Error
+	if errors.As(err, &dbusErr) && dbusErr.Name == "org.freedesktop.systemd1.AlreadySubscribed" {
+		return nil
+	}
+
+	return err
+}
+
+/*
+job starts a job on the unit and waits for its JobRemoved signal,
+returning an error unless the job result is "done"
+*/
+func (m *dbusManager) job(method string, name string) error {
+	name = unitName(name)
+
+	if err := m.subscribe(); err != nil {
+		return fmt.Errorf("subscribing to systemd: %v", err)
+	}
+
+	// The match rule is removed again, the connection is shared by
+	// all services
+	match := []dbus.MatchOption{
+		dbus.WithMatchInterface(managerInterface),
+		dbus.WithMatchMember("JobRemoved"),
+	}
+
+	if err := m.conn.AddMatchSignal(match...); err != nil {
+		return err
+	}
+	defer m.conn.RemoveMatchSignal(match...)
+
+	// Listen before starting the job so its signal is not missed
+	signals := make(chan *dbus.Signal, 32)
+	m.conn.Signal(signals)
+	defer m.conn.RemoveSignal(signals)
+
+	m.service.log().Debug("calling systemd", "method", method, "unit", name)
+
+	var job dbus.ObjectPath
+	if err := m.call(method, name, "replace").Store(&job); err != nil {
+		return fmt.Errorf("%s %s: %v", method, name, err)
+	}
+
+	timeout := m.service.clockOrDefault().After(jobTimeout)
+
+	for {
+		select {
+		case signal := <-signals:
+			if signal.Name != managerInterface+".JobRemoved" || len(signal.Body) < 4 {
+				continue
+			}
+
+			if p, _ := signal.Body[1].(dbus.ObjectPath); p != job {
+				continue
+			}
+
+			if result, _ := signal.Body[3].(string); result != "done" {
+				return fmt.Errorf("%s %s: job %s", method, name, result)
+			}
+
+			return nil
+
+		case <-timeout:
+			return fmt.Errorf("%s %s: timed out waiting for the job to finish", method, name)
+		}
+	}
+}
+
+func (m *dbusManager) Enable(name string) error {
+	if err := m.call("EnableUnitFiles", []string{unitName(name)}, false, true).Err; err != nil {
+		return err
+	}
+
+	// systemctl enable reloads as well, so the new links take effect
+	return m.Reload()
+}
+
+func (m *dbusManager) Disable(name string) error {
+	if err := m.call("DisableUnitFiles", []string{unitName(name)}, false).Err; err != nil {
+		return err
+	}
+
+	return m.Reload()
+}
+
+func (m *dbusManager) IsEnabled(name string) (bool, error) {
+	var state string
+	if err := m.call("GetUnitFileState", unitName(name)).Store(&state); err != nil {
+		return false, err
+	}
+
+	return state == "enabled" || state == "enabled-runtime", nil
+}
+
+/*
+dbusUnitStatus is an entry of the ListUnitsByPatterns result
+*/
+type dbusUnitStatus struct {
+	Name        string
+	Description string
+	LoadState   string
+	ActiveState string
+	SubState    string
+	Followed    string
+	Path        dbus.ObjectPath
+	JobID       uint32
+	JobType     string
+	JobPath     dbus.ObjectPath
+}
+
+func (m *dbusManager) ListUnits(pattern string) ([]string, error) {
+	var units []dbusUnitStatus
+	if err := m.call("ListUnitsByPatterns", []string{}, []string{pattern}).Store(&units); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(units))
+	for i, u := range units {
+		names[i] = u.Name
+	}
+
+	return names, nil
+}
+
+func (m *dbusManager) Properties(name string) (*unitProperties, error) {
+	name = unitName(name)
+
+	var unitPath dbus.ObjectPath
+	if err := m.call("LoadUnit", name).Store(&unitPath); err != nil {
+		return nil, err
+	}
+
+	unit := m.conn.Object(systemdDestination, unitPath)
+	props := &unitProperties{}
+
+	get := func(iface string, property string, dst interface{}) error {
+		v, err := unit.GetProperty("org.freedesktop.systemd1." + iface + "." + property)
+		if err != nil {
+			return err
+		}
+		return dbus.Store([]interface{}{v.Value()}, dst)
+	}
+
+	if err := get("Unit", "ActiveState", &props.ActiveState); err != nil {
+		return nil, err
+	}
+	if err := get("Unit", "FragmentPath", &props.FragmentPath); err != nil {
+		return nil, err
+	}
+	if err := get("Unit", "DropInPaths", &props.DropInPaths); err != nil {
+		return nil, err
+	}
+
+	switch path.Ext(name) {
+	case ".service":
+		var pid uint32
+		if err := get("Service", "MainPID", &pid); err != nil {
+			return nil, err
+		}
+		props.MainPID = int(pid)
+
+	case ".timer":
+		var next, last uint64
+		if err := get("Timer", "NextElapseUSecRealtime", &next); err != nil {
+			return nil, err
+		}
+		if err := get("Timer", "LastTriggerUSec", &last); err != nil {
+			return nil, err
+		}
+		props.NextElapse = usecTime(next)
+		props.LastTrigger = usecTime(last)
+	}
+
+	return props, nil
+}
+
+/*
+usecTime converts microseconds since the epoch, returning the zero
+time for 0
+*/
+func usecTime(usec uint64) time.Time {
+	if usec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(usec)*int64(time.Microsecond))
+}
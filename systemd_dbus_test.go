@@ -0,0 +1,287 @@
+// +build linux
+
+package systemservice
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+fakeBus is an in-process systemd on a D-Bus connection. Jobs finish
+with the result set for their unit, "done" by default.
+*/
+type fakeBus struct {
+	mu         sync.Mutex
+	calls      []string
+	signals    []chan<- *dbus.Signal
+	jobs       uint32
+	results    map[string]string
+	properties map[string]interface{}
+	units      [][]interface{}
+	matches    int
+	subscribed bool
+
+	// Returned by Subscribe if set
+	subscribeErr error
+}
+
+func newFakeBus() *fakeBus {
+	return &fakeBus{results: map[string]string{}, properties: map[string]interface{}{}}
+}
+
+func (b *fakeBus) Object(dest string, path dbus.ObjectPath) dbus.BusObject {
+	return &fakeObject{bus: b, path: path}
+}
+
+func (b *fakeBus) AddMatchSignal(options ...dbus.MatchOption) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.matches++
+	return nil
+}
+
+func (b *fakeBus) RemoveMatchSignal(options ...dbus.MatchOption) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.matches--
+	return nil
+}
+
+func (b *fakeBus) Signal(ch chan<- *dbus.Signal) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.signals = append(b.signals, ch)
+}
+
+func (b *fakeBus) RemoveSignal(ch chan<- *dbus.Signal) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, c := range b.signals {
+		if c == ch {
+			b.signals = append(b.signals[:i], b.signals[i+1:]...)
+			break
+		}
+	}
+}
+
+func (b *fakeBus) emit(signal *dbus.Signal) {
+	for _, ch := range b.signals {
+		ch <- signal
+	}
+}
+
+/*
+fakeObject only implements the methods the manager uses
+*/
+type fakeObject struct {
+	dbus.BusObject
+	bus  *fakeBus
+	path dbus.ObjectPath
+}
+
+func (o *fakeObject) Call(method string, flags dbus.Flags, args ...interface{}) *dbus.Call {
+	b := o.bus
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	method = strings.TrimPrefix(method, managerInterface+".")
+
+	call := method
+	if len(args) > 0 {
+		call += fmt.Sprint(" ", args[0])
+	}
+	b.calls = append(b.calls, call)
+
+	switch method {
+	case "Subscribe":
+		if b.subscribeErr != nil {
+			return &dbus.Call{Err: b.subscribeErr}
+		}
+		if b.subscribed {
+			return &dbus.Call{Err: dbus.Error{Name: "org.freedesktop.systemd1.AlreadySubscribed"}}
+		}
+		b.subscribed = true
+
+	case "StartUnit", "StopUnit", "ReloadOrRestartUnit", "TryRestartUnit":
+		name := args[0].(string)
+		result, ok := b.results[name]
+		if !ok {
+			result = "done"
+		}
+
+		b.jobs++
+		job := dbus.ObjectPath(fmt.Sprintf("/org/freedesktop/systemd1/job/%d", b.jobs))
+
+		// Jobs of other units finish in between
+		b.emit(&dbus.Signal{
+			Name: managerInterface + ".JobRemoved",
+			Body: []interface{}{uint32(0), dbus.ObjectPath("/org/freedesktop/systemd1/job/0"), "other.service", "failed"},
+		})
+
+		if result != "" {
+			b.emit(&dbus.Signal{
+				Name: managerInterface + ".JobRemoved",
+				Body: []interface{}{b.jobs, job, name, result},
+			})
+		}
+
+		return &dbus.Call{Body: []interface{}{job}}
+
+	case "LoadUnit":
+		return &dbus.Call{Body: []interface{}{dbus.ObjectPath("/org/freedesktop/systemd1/unit/" + args[0].(string))}}
+
+	case "GetUnitFileState":
+		state, ok := b.results[args[0].(string)]
+		if !ok {
+			state = "disabled"
+		}
+		return &dbus.Call{Body: []interface{}{state}}
+
+	case "ListUnitsByPatterns":
+		return &dbus.Call{Body: []interface{}{b.units}}
+	}
+
+	return &dbus.Call{}
+}
+
+func (o *fakeObject) GetProperty(p string) (dbus.Variant, error) {
+	if p == managerInterface+".Version" {
+		return dbus.MakeVariant("252"), nil
+	}
+
+	v, ok := o.bus.properties[p]
+	if !ok {
+		return dbus.Variant{}, errors.New("unknown property " + p)
+	}
+
+	return dbus.MakeVariant(v), nil
+}
+
+func TestDBusManagerJobs(t *testing.T) {
+	assert := assert.New(t)
+
+	bus := newFakeBus()
+	bus.results["broken.service"] = "failed"
+	bus.units = [][]interface{}{
+		{"worker@a.service", "", "loaded", "active", "running", "", dbus.ObjectPath("/a"), uint32(0), "", dbus.ObjectPath("/")},
+		{"worker@b.service", "", "loaded", "active", "running", "", dbus.ObjectPath("/b"), uint32(0), "", dbus.ObjectPath("/")},
+	}
+
+	m, err := newDBusManagerOn(&SystemService{}, bus)
+	assert.NoError(err)
+
+	assert.NoError(m.Start("app"))
+	assert.NoError(m.Restart("app.socket"))
+
+	err = m.Start("broken")
+	assert.Error(err)
+	assert.Contains(err.Error(), "job failed")
+
+	assert.NoError(m.Stop("worker@*.service"))
+
+	assert.Equal([]string{
+		"Subscribe", "StartUnit app.service",
+		"Subscribe", "ReloadOrRestartUnit app.socket",
+		"Subscribe", "StartUnit broken.service",
+		"ListUnitsByPatterns []",
+		"Subscribe", "StopUnit worker@a.service",
+		"Subscribe", "StopUnit worker@b.service",
+	}, bus.calls)
+	assert.Empty(bus.signals)
+	assert.Equal(0, bus.matches, "match rules are removed after each job")
+}
+
+func TestDBusManagerSubscribeError(t *testing.T) {
+	assert := assert.New(t)
+
+	bus := newFakeBus()
+	bus.subscribeErr = dbus.Error{Name: "org.freedesktop.DBus.Error.AccessDenied", Body: []interface{}{"denied"}}
+
+	m, err := newDBusManagerOn(&SystemService{}, bus)
+	assert.NoError(err)
+
+	err = m.Start("app")
+	assert.EqualError(err, "subscribing to systemd: denied")
+	assert.Equal([]string{"Subscribe"}, bus.calls)
+	assert.Equal(0, bus.matches)
+}
+
+func TestDBusManagerJobTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	timeout := jobTimeout
+	jobTimeout = 10 * time.Millisecond
+	defer func() { jobTimeout = timeout }()
+
+	bus := newFakeBus()
+	bus.results["hanging.service"] = ""
+
+	m, err := newDBusManagerOn(&SystemService{}, bus)
+	assert.NoError(err)
+
+	err = m.Start("hanging")
+	assert.Error(err)
+	assert.Contains(err.Error(), "timed out")
+}
+
+func TestDBusManagerUnitFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	bus := newFakeBus()
+	bus.results["app.timer"] = "enabled"
+
+	m, err := newDBusManagerOn(&SystemService{}, bus)
+	assert.NoError(err)
+
+	assert.NoError(m.Enable("app.timer"))
+	assert.NoError(m.Disable("app"))
+
+	enabled, err := m.IsEnabled("app.timer")
+	assert.NoError(err)
+	assert.True(enabled)
+
+	enabled, err = m.IsEnabled("app")
+	assert.NoError(err)
+	assert.False(enabled)
+
+	assert.Equal([]string{
+		"EnableUnitFiles [app.timer]", "Reload",
+		"DisableUnitFiles [app.service]", "Reload",
+		"GetUnitFileState app.timer",
+		"GetUnitFileState app.service",
+	}, bus.calls)
+}
+
+func TestDBusManagerStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	bus := newFakeBus()
+	bus.properties["org.freedesktop.systemd1.Unit.ActiveState"] = "active"
+	bus.properties["org.freedesktop.systemd1.Unit.FragmentPath"] = "/etc/systemd/system/backup.service"
+	bus.properties["org.freedesktop.systemd1.Unit.DropInPaths"] = []string{}
+	bus.properties["org.freedesktop.systemd1.Service.MainPID"] = uint32(1234)
+	bus.properties["org.freedesktop.systemd1.Timer.NextElapseUSecRealtime"] = uint64(1700000000000000)
+	bus.properties["org.freedesktop.systemd1.Timer.LastTriggerUSec"] = uint64(0)
+
+	serv := New(ServiceCommand{
+		Label:    "backup",
+		Program:  "/bin/backup",
+		Schedule: Schedule{OnCalendar: []string{"daily"}},
+	}, WithBackend(BackendSystemd))
+	serv.units, _ = newDBusManagerOn(&SystemService{}, bus)
+
+	status, err := serv.Status()
+	assert.NoError(err)
+	assert.True(status.Running)
+	assert.Equal(1234, status.PID)
+	assert.Equal(int64(1700000000), status.NextTrigger.Unix())
+	assert.True(status.LastTrigger.IsZero())
+}
@@ -0,0 +1,213 @@
+// +build linux
+
+package systemservice
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+unitManager performs the operations of the systemd backend on units.
+Names without a type suffix are services.
+*/
+type unitManager interface {
+	// Reload makes systemd read the unit files again
+	Reload() error
+
+	// Start, Stop, Restart and TryRestart wait for the job of the
+	// unit to finish. Restart reloads the unit if it supports it,
+	// TryRestart only restarts units which are running. Stop accepts
+	// glob patterns.
+	Start(name string) error
+	Stop(name string) error
+	Restart(name string) error
+	TryRestart(name string) error
+
+	// ResetFailed resets the failed state of all units
+	ResetFailed() error
+
+	Enable(name string) error
+	Disable(name string) error
+	IsEnabled(name string) (bool, error)
+
+	// ListUnits returns the names of the loaded units matching the
+	// glob pattern
+	ListUnits(pattern string) ([]string, error)
+
+	Properties(name string) (*unitProperties, error)
+}
+
+/*
+unitProperties are the properties of a unit the backend reads
+*/
+type unitProperties struct {
+	ActiveState  string
+	MainPID      int
+	FragmentPath string
+	DropInPaths  []string
+
+	// Only set for timers
+	NextElapse  time.Time
+	LastTrigger time.Time
+}
+
+/*
+newUnitManager returns the manager for the units of the service: the
+offline manager for services installed below a Root, systemctl if the
+service has a Runner, systemd's D-Bus API if it can be reached and
+systemctl otherwise
+*/
+func newUnitManager(s *SystemService) unitManager {
+	if s.Command.Root != "" {
+		return &offlineManager{service: s}
+	}
+
+	// Commands run by a custom runner must not be bypassed
+	if s.runner != nil {
+		return &systemctlManager{service: s}
+	}
+
+	m, err := newDBusManager(s)
+	if err == nil {
+		return m
+	}
+
+	s.log().Debug("using systemctl, systemd is not reachable over D-Bus", "error", err)
+
+	return &systemctlManager{service: s}
+}
+
+/*
+manager returns the unit manager of the service, the one it was given
+or a new one
+*/
+func (s *SystemService) manager() unitManager {
+	if s.units != nil {
+		return s.units
+	}
+	return newUnitManager(s)
+}
+
+/*
+systemctlManager manages units by running systemctl
+*/
+type systemctlManager struct {
+	service *SystemService
+}
+
+func (m *systemctlManager) run(cmd string, name string) error {
+	_, err := m.service.systemctl(cmd, name)
+	return err
+}
+
+func (m *systemctlManager) Reload() error {
+	return m.run("daemon-reload", "")
+}
+
+func (m *systemctlManager) Start(name string) error {
+	return m.run("start", name)
+}
+
+func (m *systemctlManager) Stop(name string) error {
+	return m.run("stop", name)
+}
+
+func (m *systemctlManager) Restart(name string) error {
+	return m.run("reload-or-restart", name)
+}
+
+func (m *systemctlManager) TryRestart(name string) error {
+	return m.run("try-restart", name)
+}
+
+func (m *systemctlManager) ResetFailed() error {
+	return m.run("reset-failed", "")
+}
+
+func (m *systemctlManager) Enable(name string) error {
+	err := m.run("enable", name)
+
+	// systemctl reports the symlinks it creates on stderr
+	if err != nil && strings.Contains(err.Error(), "Created symlink") {
+		return nil
+	}
+
+	return err
+}
+
+func (m *systemctlManager) Disable(name string) error {
+	err := m.run("disable", name)
+
+	if err != nil && strings.Contains(err.Error(), "Removed") {
+		m.service.log().Debug("ignoring remove symlink error")
+		return nil
+	}
+
+	return err
+}
+
+func (m *systemctlManager) IsEnabled(name string) (bool, error) {
+	return m.run("is-enabled --quiet", name) == nil, nil
+}
+
+func (m *systemctlManager) ListUnits(pattern string) ([]string, error) {
+	out, err := m.service.systemctl("list-units --all --plain --no-legend --full", pattern)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(strings.TrimPrefix(strings.TrimSpace(line), "●"))
+		if len(fields) > 0 {
+			names = append(names, fields[0])
+		}
+	}
+
+	return names, nil
+}
+
+func (m *systemctlManager) Properties(name string) (*unitProperties, error) {
+	out, err := m.service.systemctl("show --property=ActiveState --property=MainPID --property=FragmentPath --property=DropInPaths --property=NextElapseUSecRealtime --property=LastTriggerUSec", name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseUnitProperties(out), nil
+}
+
+/*
+parseUnitProperties parses the "Key=value" lines printed by
+"systemctl show"
+*/
+func parseUnitProperties(out string) *unitProperties {
+	props := &unitProperties{}
+
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		switch parts[0] {
+		case "ActiveState":
+			props.ActiveState = parts[1]
+		case "MainPID":
+			props.MainPID, _ = strconv.Atoi(parts[1])
+		case "FragmentPath":
+			props.FragmentPath = parts[1]
+		case "DropInPaths":
+			props.DropInPaths = strings.Fields(parts[1])
+		case "NextElapseUSecRealtime":
+			props.NextElapse = parseSystemdTimestamp(parts[1])
+		case "LastTriggerUSec":
+			props.LastTrigger = parseSystemdTimestamp(parts[1])
+		}
+	}
+
+	return props
+}
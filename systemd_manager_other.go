@@ -0,0 +1,8 @@
+// +build !linux
+
+package systemservice
+
+/*
+unitManager is only needed for systemd, which only runs on Linux
+*/
+type unitManager interface{}
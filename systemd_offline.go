@@ -0,0 +1,224 @@
+// +build linux
+
+package systemservice
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/danawoodman/systemservice/unit"
+	"github.com/spf13/afero"
+)
+
+/*
+offlineManager manages the units of a service installed below a Root.
+There is no service manager to talk to: enabling and disabling is done
+by managing the symlinks of the [Install] section, operations which
+only affect a running manager are skipped and queries fail.
+*/
+type offlineManager struct {
+	service *SystemService
+}
+
+func (m *offlineManager) skip(operation string) error {
+	m.service.log().Debug("skipping action below root", "action", operation, "root", m.service.Command.Root)
+	return nil
+}
+
+func (m *offlineManager) Reload() error {
+	return m.skip("daemon-reload")
+}
+
+func (m *offlineManager) Start(name string) error {
+	return m.skip("start " + name)
+}
+
+func (m *offlineManager) Stop(name string) error {
+	return m.skip("stop " + name)
+}
+
+func (m *offlineManager) Restart(name string) error {
+	return m.service.online("Restart")
+}
+
+func (m *offlineManager) TryRestart(name string) error {
+	return m.skip("try-restart " + name)
+}
+
+func (m *offlineManager) ResetFailed() error {
+	return m.skip("reset-failed")
+}
+
+func (m *offlineManager) Enable(name string) error {
+	return m.service.enableOffline(name)
+}
+
+func (m *offlineManager) Disable(name string) error {
+	return m.service.disableOffline(name)
+}
+
+func (m *offlineManager) IsEnabled(name string) (bool, error) {
+	return m.service.enabledOffline(name)
+}
+
+func (m *offlineManager) ListUnits(pattern string) ([]string, error) {
+	return nil, m.service.online("ListUnits")
+}
+
+func (m *offlineManager) Properties(name string) (*unitProperties, error) {
+	return nil, m.service.online("Properties")
+}
+
+/*
+online returns an UnsupportedOptionError if the operation needs a
+running service manager and the service is installed below a Root
+*/
+func (s *SystemService) online(operation string) error {
+	if s.Command.Root == "" {
+		return nil
+	}
+
+	return &UnsupportedOptionError{
+		Backend: string(s.backend()),
+		Option:  "Root",
+		Reason:  operation + " needs a running service manager",
+	}
+}
+
+/*
+installLinks returns the symlinks "systemctl enable" creates for the
+unit, keyed by their path with the unit file they point to as value.
+The targets are the paths on the installed system, without Root.
+*/
+func (s *SystemService) installLinks(name string) (map[string]string, error) {
+	name = unitName(name)
+
+	// Instances are enabled through the file of their template
+	file := name
+	if i := strings.Index(name, "@"); i >= 0 {
+		file = name[:i+1] + name[strings.LastIndex(name, "."):]
+	}
+
+	r, err := s.fs().Open(filepath.Join(s.unitDir(), file))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	f, err := unit.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	target := filepath.Join(s.scope().unitDir(), file)
+	links := map[string]string{}
+
+	if install := f.Section("Install"); install != nil {
+		for _, d := range install.Directives {
+			for _, value := range strings.Fields(d.Value) {
+				switch d.Key {
+				case "WantedBy":
+					links[filepath.Join(s.unitDir(), value+".wants", name)] = target
+				case "RequiredBy":
+					links[filepath.Join(s.unitDir(), value+".requires", name)] = target
+				case "Alias":
+					links[filepath.Join(s.unitDir(), value)] = target
+				}
+			}
+		}
+	}
+
+	return links, nil
+}
+
+/*
+enableOffline creates the symlinks of the [Install] section of the
+unit, replacing existing ones
+*/
+func (s *SystemService) enableOffline(name string) error {
+	links, err := s.installLinks(name)
+	if err != nil {
+		return err
+	}
+
+	for link, target := range links {
+		s.log().Info("creating symlink", "path", link, "target", target)
+
+		if err := s.fs().MkdirAll(filepath.Dir(link), os.ModePerm); err != nil {
+			return err
+		}
+
+		if err := s.fs().Remove(link); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err := symlink(s.fs(), target, link); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+disableOffline removes the symlinks of the unit. Links in .wants and
+.requires folders are found by name, so they are also removed if the
+unit file is already gone.
+*/
+func (s *SystemService) disableOffline(name string) error {
+	name = unitName(name)
+
+	paths := map[string]bool{}
+	for _, pattern := range []string{"*.wants", "*.requires"} {
+		found, err := afero.Glob(s.fs(), filepath.Join(s.unitDir(), pattern, name))
+		if err != nil {
+			return err
+		}
+		for _, path := range found {
+			paths[path] = true
+		}
+	}
+
+	if links, err := s.installLinks(name); err == nil {
+		for link := range links {
+			paths[link] = true
+		}
+	}
+
+	for path := range paths {
+		s.log().Info("removing symlink", "path", path)
+
+		if err := s.fs().Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if dir := filepath.Dir(path); dir != s.unitDir() {
+			removeEmptyDir(s.fs(), dir)
+		}
+	}
+
+	return nil
+}
+
+/*
+enabledOffline returns whether or not all symlinks of the unit exist
+*/
+func (s *SystemService) enabledOffline(name string) (bool, error) {
+	links, err := s.installLinks(name)
+	if err != nil {
+		return false, err
+	}
+
+	if len(links) == 0 {
+		return false, nil
+	}
+
+	for link := range links {
+		if _, err := lstat(s.fs(), link); err != nil {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,736 @@
+// +build linux
+
+package systemservice
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/danawoodman/systemservice/unit"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnitFileServiceType(t *testing.T) {
+	assert := assert.New(t)
+	tables := []struct {
+		command  ServiceCommand
+		contains []string
+		excludes []string
+	}{
+		{
+			command:  ServiceCommand{Label: "simple", Program: "/bin/app"},
+			contains: []string{"Type=simple\n", "Restart=on-failure\n"},
+			excludes: []string{"PIDFile=", "RemainAfterExit="},
+		},
+		{
+			command: ServiceCommand{
+				Label:   "forking",
+				Program: "/usr/sbin/daemon",
+				Type:    ServiceTypeForking,
+				PIDFile: "/run/daemon.pid",
+			},
+			contains: []string{"Type=forking\n", "PIDFile=/run/daemon.pid\n"},
+		},
+		{
+			command: ServiceCommand{
+				Label:           "migrate",
+				Program:         "/bin/migrate",
+				Type:            ServiceTypeOneshot,
+				RemainAfterExit: true,
+			},
+			contains: []string{"Type=oneshot\n", "Restart=no\n", "RemainAfterExit=yes\n"},
+		},
+		{
+			command: ServiceCommand{
+				Label:       "notify",
+				Program:     "/bin/app",
+				Type:        ServiceTypeNotify,
+				WatchdogSec: 30 * time.Second,
+			},
+			contains: []string{"Type=notify\n", "WatchdogSec=30s\n"},
+			excludes: []string{"NotifyAccess="},
+		},
+		{
+			command: ServiceCommand{
+				Label:       "watchdog",
+				Program:     "/bin/app",
+				WatchdogSec: 1500 * time.Millisecond,
+			},
+			contains: []string{"WatchdogSec=1500ms\n", "NotifyAccess=main\n"},
+		},
+	}
+
+	for _, table := range tables {
+		serv := New(table.command)
+		unit := newUnitFile(&serv)
+		content, err := unit.Generate()
+		assert.NoError(err)
+		for _, c := range table.contains {
+			assert.Contains(content, c, table.command.Label)
+		}
+		for _, e := range table.excludes {
+			assert.NotContains(content, e, table.command.Label)
+		}
+	}
+}
+
+func TestSocketUnitFile(t *testing.T) {
+	assert := assert.New(t)
+
+	serv := New(ServiceCommand{
+		Label:   "app",
+		Program: "/bin/app",
+		Sockets: Sockets{
+			ListenStream: []string{"8080"},
+			Accept:       true,
+			Name:         "http",
+		},
+	})
+
+	unit := newUnitFile(&serv)
+	assert.Equal("app@.service", unit.Name())
+
+	content, err := unit.Generate()
+	assert.NoError(err)
+	assert.Contains(content, "StandardInput=socket\n")
+	assert.NotContains(content, "StandardOutput=")
+
+	socket := newSocketUnitFile(&serv)
+	content, err = socket.Generate()
+	assert.NoError(err)
+	assert.Contains(content, "[Socket]\nListenStream=8080\nAccept=yes\nFileDescriptorName=http\n")
+	assert.Contains(content, "WantedBy=sockets.target")
+
+	assert.Equal([]string{"app.socket"}, serv.activationUnits())
+	assert.Len(serv.unitFiles(), 2)
+}
+
+func TestTimerUnitFile(t *testing.T) {
+	assert := assert.New(t)
+
+	serv := New(ServiceCommand{
+		Label:   "backup",
+		Program: "/bin/backup",
+		Schedule: Schedule{
+			OnCalendar:         []string{"Mon..Fri 2:00"},
+			RandomizedDelaySec: 10 * time.Minute,
+			Persistent:         true,
+		},
+	})
+
+	timer := newTimerUnitFile(&serv)
+	content, err := timer.Generate()
+	assert.NoError(err)
+	assert.Contains(content, "[Timer]\nOnCalendar=Mon..Fri *-*-* 02:00:00\nRandomizedDelaySec=600s\nPersistent=yes\n")
+	assert.Contains(content, "WantedBy=timers.target")
+
+	unit := newUnitFile(&serv)
+	content, err = unit.Generate()
+	assert.NoError(err)
+	assert.Contains(content, "Type=oneshot\n")
+
+	assert.Equal([]string{"backup.timer"}, serv.activationUnits())
+}
+
+func TestParseSystemdTimestamp(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(parseSystemdTimestamp("n/a").IsZero())
+	assert.True(parseSystemdTimestamp("").IsZero())
+	assert.Equal(int64(1700000000), parseSystemdTimestamp("@1700000000").Unix())
+	assert.Equal(
+		time.Date(2024, 1, 8, 9, 0, 0, 0, time.UTC).Unix(),
+		parseSystemdTimestamp("Mon 2024-01-08 09:00:00 UTC").Unix(),
+	)
+}
+
+func TestPathUnitFile(t *testing.T) {
+	assert := assert.New(t)
+
+	serv := New(ServiceCommand{
+		Label:   "importer",
+		Program: "/bin/import",
+		Triggers: []Trigger{
+			{Type: TriggerDirectoryNotEmpty, Path: "/var/spool/import"},
+			{Type: TriggerPathChanged, Path: "/etc/import.conf"},
+		},
+	})
+
+	assert.NoError(serv.Command.validate())
+
+	path := newPathUnitFile(&serv)
+	content, err := path.Generate()
+	assert.NoError(err)
+	assert.Contains(content, "[Path]\nDirectoryNotEmpty=/var/spool/import\nPathChanged=/etc/import.conf\n")
+	assert.Contains(content, "WantedBy=paths.target")
+
+	assert.Equal([]string{"importer.path"}, serv.activationUnits())
+	assert.Equal(ServiceTypeOneshot, serv.Command.serviceType())
+
+	serv.Command.Triggers = []Trigger{{Type: TriggerPathExists, Path: "relative"}}
+	assert.Error(serv.Command.validate())
+}
+
+func TestDropInFile(t *testing.T) {
+	assert := assert.New(t)
+
+	serv := Open("nginx")
+	dropIn := newDropInFile(&serv, "10-limits", Section{
+		Name:       "Service",
+		Directives: []Directive{{Key: "LimitNOFILE", Value: "65536"}},
+	})
+
+	assert.Equal(filepath.Join(serv.unitDir(), "nginx.service.d", "10-limits.conf"), dropIn.Path())
+
+	content, err := dropIn.Generate()
+	assert.NoError(err)
+	assert.Equal("[Service]\nLimitNOFILE=65536\n", content)
+}
+
+func TestParseUnitProperties(t *testing.T) {
+	assert := assert.New(t)
+
+	props := parseUnitProperties("ActiveState=active\nMainPID=1234\nFragmentPath=/lib/systemd/system/nginx.service\nDropInPaths=/etc/systemd/system/nginx.service.d/10-limits.conf /run/systemd/system/nginx.service.d/debug.conf\nNextElapseUSecRealtime=@1700000000\nLastTriggerUSec=n/a\n")
+
+	assert.Equal("active", props.ActiveState)
+	assert.Equal(1234, props.MainPID)
+	assert.Equal("/lib/systemd/system/nginx.service", props.FragmentPath)
+	assert.Equal([]string{
+		"/etc/systemd/system/nginx.service.d/10-limits.conf",
+		"/run/systemd/system/nginx.service.d/debug.conf",
+	}, props.DropInPaths)
+	assert.Equal(int64(1700000000), props.NextElapse.Unix())
+	assert.True(props.LastTrigger.IsZero())
+
+	props = parseUnitProperties("FragmentPath=\nDropInPaths=\n")
+	assert.Equal("", props.FragmentPath)
+	assert.Empty(props.DropInPaths)
+}
+
+func TestUnitFileRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := ServiceCommand{
+		Label:       "worker",
+		Name:        "worker",
+		Program:     "/usr/local/bin/worker",
+		Args:        []string{"--queue", "emails", "--greeting", `say "hi"`, "--fmt=100%d", "$HOME"},
+		Description: "Queue worker",
+		Type:        ServiceTypeNotify,
+		WatchdogSec: 30 * time.Second,
+		StopTimeout: time.Minute,
+		Environment: map[string]string{"LEVEL": "debug", "RATE": "50%"},
+		Dependencies: Dependencies{
+			After:         []string{"postgresql.service"},
+			NetworkOnline: true,
+		},
+		Directories: Directories{State: ManagedDirectory{Paths: []string{"worker"}}},
+		Hardening:   Hardening{ProtectSystem: "strict", ReadWritePaths: []string{"/srv"}},
+	}
+	serv := New(cmd)
+
+	unitFile := newUnitFile(&serv)
+	content, err := unitFile.Generate()
+	assert.NoError(err)
+	assert.Contains(content, `ExecStart=/usr/local/bin/worker --queue emails --greeting "say \"hi\"" --fmt=100%%d $$HOME`+"\n")
+	assert.Contains(content, `Environment="RATE=50%%"`+"\n")
+
+	service, err := unit.Parse(strings.NewReader(content))
+	assert.NoError(err)
+	service.Name = unitFile.Name()
+
+	imported, unmapped, err := CommandFromUnit(service)
+	assert.NoError(err)
+	assert.Empty(unmapped)
+	assert.Equal(cmd, imported)
+}
+
+func TestUnitFileExtra(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := ServiceCommand{
+		Label:    "backup",
+		Program:  "/bin/backup",
+		Schedule: Schedule{OnCalendar: []string{"daily"}},
+		Extra: []Section{
+			{Name: "Service", Directives: []Directive{{Key: "LimitNOFILE", Value: "65536"}}},
+			{Name: "Timer", Directives: []Directive{{Key: "AccuracySec", Value: "1min"}}},
+			{Name: "X-Backup", Directives: []Directive{{Key: "Retention", Value: "7d"}}},
+		},
+	}
+	assert.NoError(cmd.validate())
+	serv := New(cmd)
+
+	service := newUnitFile(&serv)
+	content, err := service.Generate()
+	assert.NoError(err)
+	assert.Contains(content, "LimitNOFILE=65536\n\n[Install]\nWantedBy=multi-user.target\n\n[X-Backup]\nRetention=7d\n")
+	assert.NotContains(content, "AccuracySec")
+
+	timer := newTimerUnitFile(&serv)
+	content, err = timer.Generate()
+	assert.NoError(err)
+	assert.Contains(content, "OnCalendar=*-*-* 00:00:00\nAccuracySec=1min\n\n[Install]")
+	assert.NotContains(content, "LimitNOFILE")
+
+	cmd.Schedule = Schedule{}
+	assert.Error(cmd.validate())
+
+	cmd.Extra = []Section{{Name: "Service", Directives: []Directive{{Key: "ExecStartPre", Value: "/bin/true \\"}}}}
+	assert.Error(cmd.validate())
+}
+
+func TestGeneratedUnitsLint(t *testing.T) {
+	assert := assert.New(t)
+
+	serv := New(ServiceCommand{
+		Label:       "worker",
+		Program:     "/usr/local/bin/worker",
+		Args:        []string{"--name", "two words"},
+		Type:        ServiceTypeNotify,
+		WatchdogSec: 30 * time.Second,
+		StopTimeout: time.Minute,
+		Environment: map[string]string{"LEVEL": "debug"},
+		Schedule:    Schedule{OnCalendar: []string{"hourly"}, Persistent: true},
+		Directories: Directories{State: ManagedDirectory{Paths: []string{"worker"}, Mode: 0700}},
+		Hardening:   Hardening{Level: HardeningStrict, ReadWritePaths: []string{"/srv"}},
+		Dependencies: Dependencies{
+			After:         []string{"postgresql"},
+			NetworkOnline: true,
+		},
+	})
+
+	for _, file := range serv.unitFiles() {
+		content, err := file.Generate()
+		assert.NoError(err)
+
+		f, err := unit.Parse(strings.NewReader(content))
+		assert.NoError(err)
+		f.Name = filepath.Base(file.Path())
+
+		assert.Empty(Lint(f, currentScope()), content)
+	}
+	assert.NoError(serv.lintUnits())
+
+	serv.Command.Extra = []Section{{Name: "Service", Directives: []Directive{{Key: "RestartSec", Value: "soon"}}}}
+	err := serv.lintUnits()
+	assert.IsType(&InvalidUnitError{}, err)
+	assert.Contains(err.Error(), "worker.service: [Service] RestartSec=: error:")
+}
+
+/*
+failingManager manages units offline but fails to start them
+*/
+type failingManager struct {
+	*offlineManager
+	calls *[]string
+}
+
+func (m failingManager) Start(name string) error {
+	*m.calls = append(*m.calls, "start "+name)
+	return errors.New("job failed")
+}
+
+func (m failingManager) Stop(name string) error {
+	*m.calls = append(*m.calls, "stop "+name)
+	return m.offlineManager.Stop(name)
+}
+
+func (m failingManager) Disable(name string) error {
+	*m.calls = append(*m.calls, "disable "+name)
+	return m.offlineManager.Disable(name)
+}
+
+func (m failingManager) Enable(name string) error {
+	*m.calls = append(*m.calls, "enable "+name)
+	return m.offlineManager.Enable(name)
+}
+
+func TestInstallRollback(t *testing.T) {
+	assert := assert.New(t)
+
+	root, err := ioutil.TempDir("", "root")
+	assert.NoError(err)
+	defer os.RemoveAll(root)
+
+	units := filepath.Join(root, "etc/systemd/system")
+	link := filepath.Join(units, "multi-user.target.wants/app.service")
+
+	serv := New(ServiceCommand{Label: "app", Program: "/usr/bin/app", Root: root})
+	assert.NoError(serv.Install(true))
+
+	installed, err := ioutil.ReadFile(filepath.Join(units, "app.service"))
+	assert.NoError(err)
+
+	var calls []string
+	serv.units = failingManager{&offlineManager{service: &serv}, &calls}
+
+	// An upgrade which fails to start restores the previous unit
+	serv.Command.Args = []string{"--v2"}
+
+	err = serv.Install(true)
+	assert.IsType(&InstallError{}, err)
+	assert.NoError(err.(*InstallError).RollbackErr)
+	assert.Equal([]string{"start app", "stop app", "disable app", "enable app"}, calls)
+
+	content, err := ioutil.ReadFile(filepath.Join(units, "app.service"))
+	assert.NoError(err)
+	assert.Equal(string(installed), string(content))
+
+	_, err = os.Lstat(link)
+	assert.NoError(err)
+
+	// A new service which fails to start is removed again
+	calls = nil
+	other := New(ServiceCommand{Label: "other", Program: "/usr/bin/other", Root: root})
+	other.units = failingManager{&offlineManager{service: &other}, &calls}
+
+	err = other.Install(true)
+	assert.IsType(&InstallError{}, err)
+	assert.Equal([]string{"start other", "stop other", "disable other"}, calls)
+
+	_, err = os.Stat(filepath.Join(units, "other.service"))
+	assert.True(os.IsNotExist(err))
+}
+
+func TestInstallBelowRoot(t *testing.T) {
+	assert := assert.New(t)
+
+	root, err := ioutil.TempDir("", "root")
+	assert.NoError(err)
+	defer os.RemoveAll(root)
+
+	serv := New(ServiceCommand{
+		Label:       "backup",
+		Program:     "/usr/bin/backup",
+		Schedule:    Schedule{OnCalendar: []string{"daily"}},
+		User:        "backup",
+		CreateUser:  true,
+		Directories: Directories{State: ManagedDirectory{Paths: []string{"backup"}}},
+		Root:        root,
+	})
+
+	assert.NoError(serv.Install(true))
+
+	units := filepath.Join(root, "etc/systemd/system")
+	assert.True(fileExists(serv.fs(), filepath.Join(units, "backup.service")))
+	assert.True(fileExists(serv.fs(), filepath.Join(units, "backup.timer")))
+	assert.True(fileExists(serv.fs(), filepath.Join(root, "etc/sysusers.d/backup.conf")))
+
+	target, err := os.Readlink(filepath.Join(units, "timers.target.wants/backup.timer"))
+	assert.NoError(err)
+	assert.Equal("/etc/systemd/system/backup.timer", target)
+
+	content, err := ioutil.ReadFile(filepath.Join(units, "backup.service"))
+	assert.NoError(err)
+	assert.Contains(string(content), "WantedBy=multi-user.target\n")
+
+	enabled, err := serv.enabledOffline("backup.timer")
+	assert.NoError(err)
+	assert.True(enabled)
+
+	_, err = serv.Status()
+	assert.IsType(&UnsupportedOptionError{}, err)
+	assert.IsType(&UnsupportedOptionError{}, serv.Restart())
+
+	changed, err := serv.Reconcile(context.Background())
+	assert.NoError(err)
+	assert.False(changed)
+
+	assert.NoError(serv.Uninstall())
+
+	files, err := ioutil.ReadDir(units)
+	assert.NoError(err)
+	assert.Empty(files)
+}
+
+func TestInstallInstanceBelowRoot(t *testing.T) {
+	assert := assert.New(t)
+
+	root, err := ioutil.TempDir("", "root")
+	assert.NoError(err)
+	defer os.RemoveAll(root)
+
+	serv := New(ServiceCommand{Label: "worker", Program: "/usr/bin/worker", Args: []string{"--queue", "%i"}, Root: root})
+
+	assert.NoError(serv.InstallInstance("emails", true))
+	assert.NoError(serv.StartInstance("reports"))
+
+	instances, err := serv.ListInstances()
+	assert.NoError(err)
+	assert.Equal([]string{"emails", "reports"}, instances)
+
+	target, err := os.Readlink(filepath.Join(root, "etc/systemd/system/multi-user.target.wants/worker@emails.service"))
+	assert.NoError(err)
+	assert.Equal("/etc/systemd/system/worker@.service", target)
+
+	// Reconciling keeps the template instead of installing a plain unit
+	changed, err := serv.Reconcile(context.Background())
+	assert.NoError(err)
+	assert.False(changed)
+
+	serv.Command.Args = []string{"--queue", "%i", "--verbose"}
+	changed, err = serv.Reconcile(context.Background())
+	assert.NoError(err)
+	assert.True(changed)
+
+	content, err := ioutil.ReadFile(filepath.Join(root, "etc/systemd/system/worker@.service"))
+	assert.NoError(err)
+	assert.Contains(string(content), "ExecStart=/usr/bin/worker --queue %i --verbose\n")
+	assert.False(fileExists(serv.fs(), filepath.Join(root, "etc/systemd/system/worker.service")))
+
+	assert.NoError(serv.UninstallInstance("emails"))
+	assert.NoError(serv.UninstallInstance("reports"))
+	_, err = os.Stat(filepath.Join(root, "etc/systemd/system/worker@.service"))
+	assert.True(os.IsNotExist(err))
+}
+
+func TestReconcileInstances(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls []string
+	runner := RunnerFunc(func(name string, args ...string) (string, error) {
+		calls = append(calls, name+" "+strings.Join(args, " "))
+		if len(args) > 0 && args[0] == "list-units" {
+			return "worker@emails.service loaded active running Worker\n", nil
+		}
+		return "", nil
+	})
+
+	serv := New(ServiceCommand{Label: "worker", Program: "/usr/bin/worker", Args: []string{"%i"}},
+		WithFS(afero.NewMemMapFs()),
+		WithRunner(runner),
+		WithClock(&fakeClock{}),
+		WithScope(ScopeSystem),
+		WithBackend(BackendSystemd),
+	)
+
+	assert.NoError(serv.InstallInstance("emails", false))
+	calls = nil
+
+	serv.Command.Args = []string{"%i", "--verbose"}
+	changed, err := serv.Reconcile(context.Background())
+	assert.NoError(err)
+	assert.True(changed)
+
+	assert.Equal([]string{
+		"systemctl daemon-reload",
+		"systemctl list-units --all --plain --no-legend --full worker@*.service",
+		"systemctl try-restart worker@emails.service",
+	}, calls)
+	assert.True(fileExists(serv.fs(), filepath.Join(serv.unitDir(), "worker@.service")))
+	assert.False(fileExists(serv.fs(), filepath.Join(serv.unitDir(), "worker.service")))
+}
+
+/*
+recordingManager records the operations on units and reports all
+units as running
+*/
+type recordingManager struct {
+	calls []string
+}
+
+func (m *recordingManager) record(op string, name string) error {
+	m.calls = append(m.calls, strings.TrimSpace(op+" "+name))
+	return nil
+}
+
+func (m *recordingManager) Reload() error                { return m.record("reload", "") }
+func (m *recordingManager) Start(name string) error      { return m.record("start", name) }
+func (m *recordingManager) Stop(name string) error       { return m.record("stop", name) }
+func (m *recordingManager) Restart(name string) error    { return m.record("restart", name) }
+func (m *recordingManager) TryRestart(name string) error { return m.record("try-restart", name) }
+func (m *recordingManager) ResetFailed() error           { return m.record("reset-failed", "") }
+func (m *recordingManager) Enable(name string) error     { return m.record("enable", name) }
+func (m *recordingManager) Disable(name string) error    { return m.record("disable", name) }
+
+func (m *recordingManager) IsEnabled(name string) (bool, error) {
+	return false, nil
+}
+
+func (m *recordingManager) ListUnits(pattern string) ([]string, error) {
+	return nil, nil
+}
+
+func (m *recordingManager) Properties(name string) (*unitProperties, error) {
+	return &unitProperties{ActiveState: "active"}, nil
+}
+
+func TestInstallInMemory(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &recordingManager{}
+	fs := afero.NewMemMapFs()
+	clock := &fakeClock{}
+	serv := New(ServiceCommand{Label: "app", Program: "/usr/bin/app"}, WithFS(fs), WithClock(clock))
+	serv.units = m
+	serv.startCheckDelay = 3 * time.Second
+
+	path := filepath.Join(serv.unitDir(), "app.service")
+
+	assert.NoError(serv.Install(true))
+	assert.True(serv.Exists())
+	assert.Equal([]string{"reload", "start app", "enable app"}, m.calls)
+	assert.Equal(3*time.Second, clock.Now().Sub(time.Time{}), "the start is checked after the delay of the service")
+
+	_, err := os.Stat(path)
+	assert.True(os.IsNotExist(err), "the OS file system is not touched")
+
+	assert.NoError(serv.SetOverride("10-limits", Section{
+		Name:       "Service",
+		Directives: []Directive{{Key: "LimitNOFILE", Value: "65536"}},
+	}))
+
+	overrides, err := serv.ListOverrides()
+	assert.NoError(err)
+	assert.Equal([]string{"10-limits"}, overrides)
+
+	m.calls = nil
+	assert.NoError(serv.Uninstall())
+	assert.False(serv.Exists())
+	assert.Equal([]string{"reload", "stop app", "disable app", "reload", "reset-failed"}, m.calls)
+
+	_, err = serv.FS.Stat(serv.dropInDir("app.service"))
+	assert.True(os.IsNotExist(err))
+}
+
+func TestInstallBelowRootInMemory(t *testing.T) {
+	assert := assert.New(t)
+
+	fs := newLinkMemFs()
+	serv := New(ServiceCommand{
+		Label:      "backup",
+		Program:    "/usr/bin/backup",
+		Schedule:   Schedule{OnCalendar: []string{"daily"}},
+		User:       "backup",
+		CreateUser: true,
+		Root:       "/image",
+	})
+	serv.FS = fs
+
+	assert.NoError(serv.Install(true))
+
+	units := "/image/etc/systemd/system"
+	assert.True(fileExists(fs, filepath.Join(units, "backup.service")))
+	assert.True(fileExists(fs, "/image/etc/sysusers.d/backup.conf"))
+
+	target, err := readlink(fs, filepath.Join(units, "timers.target.wants/backup.timer"))
+	assert.NoError(err)
+	assert.Equal("/etc/systemd/system/backup.timer", target)
+
+	diff, err := serv.Diff()
+	assert.NoError(err)
+	assert.False(diff.Changed())
+
+	assert.NoError(serv.Uninstall())
+
+	files, err := afero.ReadDir(fs, units)
+	assert.NoError(err)
+	assert.Empty(files)
+	assert.False(fileExists(fs, "/image/etc/sysusers.d/backup.conf"))
+}
+
+func TestInstallWithRunner(t *testing.T) {
+	assert := assert.New(t)
+
+	var calls []string
+	runner := RunnerFunc(func(name string, args ...string) (string, error) {
+		calls = append(calls, name+" "+strings.Join(args, " "))
+		switch args[0] {
+		case "is-enabled":
+			return "disabled\n", errors.New("exit status 1")
+		case "show":
+			return "ActiveState=active\nMainPID=42\n", nil
+		}
+		return "", nil
+	})
+
+	serv := New(ServiceCommand{Label: "app", Program: "/usr/bin/app"},
+		WithFS(afero.NewMemMapFs()),
+		WithRunner(runner),
+		WithClock(&fakeClock{}),
+		WithScope(ScopeSystem),
+		WithBackend(BackendSystemd),
+	)
+
+	assert.NoError(serv.Install(true))
+	assert.True(fileExists(serv.FS, "/etc/systemd/system/app.service"))
+	assert.Subset(calls, []string{
+		"systemctl daemon-reload",
+		"systemctl start app",
+		"systemctl enable app",
+	})
+
+	status, err := serv.Status()
+	assert.NoError(err)
+	assert.Equal(&ServiceStatus{Running: true, PID: 42}, status)
+}
+
+func TestOpenExists(t *testing.T) {
+	assert := assert.New(t)
+
+	fragment := "/usr/lib/systemd/system/nginx.service"
+	runner := RunnerFunc(func(name string, args ...string) (string, error) {
+		return "ActiveState=inactive\nFragmentPath=" + fragment + "\n", nil
+	})
+
+	serv := Open("nginx.service", WithRunner(runner), WithFS(afero.NewMemMapFs()), WithBackend(BackendSystemd))
+	assert.True(serv.Exists(), "distribution units are not in /etc/systemd/system")
+
+	fragment = ""
+	assert.False(serv.Exists())
+
+	fs := afero.NewMemMapFs()
+	assert.NoError(afero.WriteFile(fs, "/image/lib/systemd/system/nginx.service", nil, 0644))
+
+	serv = Open("nginx", WithFS(fs))
+	serv.Command.Root = "/image"
+	assert.True(serv.Exists())
+
+	serv = Open("apache2", WithFS(fs))
+	serv.Command.Root = "/image"
+	assert.False(serv.Exists())
+}
+
+func TestInstallUnknownExtraDirective(t *testing.T) {
+	assert := assert.New(t)
+
+	runner := RunnerFunc(func(name string, args ...string) (string, error) {
+		if args[0] == "is-enabled" {
+			return "disabled\n", errors.New("exit status 1")
+		}
+		return "", nil
+	})
+
+	serv := New(ServiceCommand{
+		Label:   "app",
+		Program: "/usr/bin/app",
+		Extra: []Section{
+			{Name: "Service", Directives: []Directive{
+				{Key: "LoadCredential", Value: "token:/etc/app/token"},
+				{Key: "RestartSteps", Value: "5"},
+			}},
+		},
+	}, WithFS(afero.NewMemMapFs()), WithRunner(runner), WithScope(ScopeSystem), WithBackend(BackendSystemd))
+
+	assert.NoError(serv.Install(false), "directives missing from the lint table are only warned about")
+
+	content, err := afero.ReadFile(serv.FS, "/etc/systemd/system/app.service")
+	assert.NoError(err)
+	assert.Contains(string(content), "LoadCredential=token:/etc/app/token\nRestartSteps=5\n")
+
+	// Documentation which is not a URI is ignored by systemd as well
+	serv.Command.Documentation = "www.example.com"
+	assert.NoError(serv.Install(false))
+
+	content, err = afero.ReadFile(serv.FS, "/etc/systemd/system/app.service")
+	assert.NoError(err)
+	assert.Contains(string(content), "Documentation=www.example.com\n")
+}
@@ -0,0 +1,409 @@
+/*
+Package systemservicetest provides an in-memory implementation of
+systemservice.Service to test code managing services without a
+service manager.
+*/
+package systemservicetest
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/danawoodman/systemservice"
+)
+
+/*
+Call is a recorded call of a method of the fake
+*/
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+/*
+String returns the call in Go syntax, e.g. `Install(true)`
+*/
+func (c Call) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = fmt.Sprintf("%#v", arg)
+	}
+	return c.Method + "(" + strings.Join(args, ", ") + ")"
+}
+
+/*
+Fake is an in-memory service. It follows the state transitions of a
+service installed with systemd: starting the service enables it and
+stopping it disables it, Install only starts and enables it if start
+is passed, Status and Running report a service which is not installed
+as not running, Uninstall stops it first and a crashed service is
+restarted unless RestartOnFailure is turned off. It is safe for
+concurrent use.
+*/
+type Fake struct {
+	// The name used in errors. Optional, defaults to "fake".
+	Name string
+
+	mu         sync.Mutex
+	installed  bool
+	enabled    bool
+	failed     bool
+	keepFailed bool
+	status     systemservice.ServiceStatus
+	lastPID    int
+	restarts   int
+	calls      []Call
+	failures   map[string]*failure
+}
+
+type failure struct {
+	err  error
+	once bool
+}
+
+var _ systemservice.Service = &Fake{}
+
+/*
+New returns a fake service which is not installed yet, the zero value
+works as well
+*/
+func New(name string) *Fake {
+	return &Fake{Name: name}
+}
+
+/*
+Install installs the service. If start is passed, it also starts and
+enables it. Installing an installed service replaces it, like
+SystemService does, keeping whether or not it is enabled.
+*/
+func (f *Fake) Install(start bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call("Install", start); err != nil {
+		return err
+	}
+
+	f.installed = true
+
+	if start {
+		f.start()
+		f.enabled = true
+	}
+
+	return nil
+}
+
+/*
+Start starts the service if it is not running and enables it
+*/
+func (f *Fake) Start() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call("Start"); err != nil {
+		return err
+	}
+
+	if err := f.exists(); err != nil {
+		return err
+	}
+
+	f.start()
+	f.enabled = true
+
+	return nil
+}
+
+/*
+Restart stops and starts the service, it gets a new PID
+*/
+func (f *Fake) Restart() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call("Restart"); err != nil {
+		return err
+	}
+
+	if err := f.exists(); err != nil {
+		return err
+	}
+
+	f.stop()
+	f.start()
+
+	return nil
+}
+
+/*
+Stop stops the service if it is running and disables it
+*/
+func (f *Fake) Stop() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call("Stop"); err != nil {
+		return err
+	}
+
+	if err := f.exists(); err != nil {
+		return err
+	}
+
+	f.stop()
+	f.enabled = false
+
+	return nil
+}
+
+/*
+Uninstall stops, disables and removes the service
+*/
+func (f *Fake) Uninstall() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call("Uninstall"); err != nil {
+		return err
+	}
+
+	if err := f.exists(); err != nil {
+		return err
+	}
+
+	f.stop()
+	f.installed = false
+	f.enabled = false
+	f.failed = false
+
+	return nil
+}
+
+/*
+Status returns the status of the service, as changed by the other
+methods or set with SetStatus. A service which is not installed is not
+running.
+*/
+func (f *Fake) Status() (*systemservice.ServiceStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call("Status"); err != nil {
+		return nil, err
+	}
+
+	status := f.status
+	return &status, nil
+}
+
+/*
+Running returns whether or not the service is running
+*/
+func (f *Fake) Running() (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.call("Running"); err != nil {
+		return false, err
+	}
+
+	return f.status.Running, nil
+}
+
+/*
+Exists returns whether or not the service is installed
+*/
+func (f *Fake) Exists() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, Call{Method: "Exists"})
+
+	return f.installed
+}
+
+/*
+Crash simulates the program of the service exiting with an error. The
+service is restarted right away with a new PID, unless
+RestartOnFailure was turned off: then it stays failed until started.
+Crashing a service which is not running does nothing.
+*/
+func (f *Fake) Crash() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.status.Running {
+		return
+	}
+
+	f.stop()
+	f.failed = true
+
+	if !f.keepFailed {
+		f.start()
+		f.restarts++
+	}
+}
+
+/*
+RestartOnFailure sets whether or not a crashed service is restarted,
+it is by default
+*/
+func (f *Fake) RestartOnFailure(restart bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.keepFailed = !restart
+}
+
+/*
+SetStatus replaces the status of the service, e.g. to simulate a
+service started or stopped by someone else. The service is installed
+if it was not.
+*/
+func (f *Fake) SetStatus(status systemservice.ServiceStatus) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.installed = true
+	f.status = status
+	if status.PID > f.lastPID {
+		f.lastPID = status.PID
+	}
+}
+
+/*
+Fail makes all calls of the method, e.g. "Start", return the error
+without changing the service. Passing a nil error clears the failure.
+*/
+func (f *Fake) Fail(method string, err error) {
+	f.setFailure(method, err, false)
+}
+
+/*
+FailOnce makes the next call of the method return the error without
+changing the service
+*/
+func (f *Fake) FailOnce(method string, err error) {
+	f.setFailure(method, err, true)
+}
+
+func (f *Fake) setFailure(method string, err error, once bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failures == nil {
+		f.failures = map[string]*failure{}
+	}
+
+	if err == nil {
+		delete(f.failures, method)
+		return
+	}
+
+	f.failures[method] = &failure{err: err, once: once}
+}
+
+/*
+Calls returns the calls of the methods of the Service interface, in
+order
+*/
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]Call(nil), f.calls...)
+}
+
+/*
+Methods returns the names of the called methods, in order
+*/
+func (f *Fake) Methods() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	methods := make([]string, len(f.calls))
+	for i, c := range f.calls {
+		methods[i] = c.Method
+	}
+	return methods
+}
+
+/*
+Enabled returns whether or not the service starts on boot
+*/
+func (f *Fake) Enabled() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.enabled
+}
+
+/*
+Failed returns whether or not the service crashed and was not started
+since
+*/
+func (f *Fake) Failed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.failed
+}
+
+/*
+Restarts returns how often the service was restarted after a crash
+*/
+func (f *Fake) Restarts() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.restarts
+}
+
+/*
+call records a call and returns its injected failure
+*/
+func (f *Fake) call(method string, args ...interface{}) error {
+	f.calls = append(f.calls, Call{Method: method, Args: args})
+
+	fail, ok := f.failures[method]
+	if !ok {
+		return nil
+	}
+
+	if fail.once {
+		delete(f.failures, method)
+	}
+
+	return fail.err
+}
+
+func (f *Fake) exists() error {
+	if !f.installed {
+		name := f.Name
+		if name == "" {
+			name = "fake"
+		}
+		return systemservice.NewServiceDoesNotExistError(name)
+	}
+	return nil
+}
+
+func (f *Fake) start() {
+	f.failed = false
+
+	if f.status.Running {
+		return
+	}
+
+	f.lastPID++
+	f.status.Running = true
+	f.status.PID = f.lastPID
+}
+
+func (f *Fake) stop() {
+	f.status.Running = false
+	f.status.PID = 0
+}
@@ -0,0 +1,148 @@
+package systemservicetest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/danawoodman/systemservice"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+ensureRunning is the kind of code the fake is meant to test
+*/
+func ensureRunning(s systemservice.Service) error {
+	if !s.Exists() {
+		return s.Install(true)
+	}
+
+	running, err := s.Running()
+	if err != nil || running {
+		return err
+	}
+
+	return s.Start()
+}
+
+func TestFakeLifecycle(t *testing.T) {
+	assert := assert.New(t)
+
+	f := New("app")
+
+	// Like systemd, a missing service is not running
+	status, err := f.Status()
+	assert.NoError(err)
+	assert.Equal(&systemservice.ServiceStatus{}, status)
+
+	err = f.Start()
+	var notExist *systemservice.ServiceDoesNotExistError
+	assert.True(errors.As(err, &notExist))
+	assert.Equal(`the service "app" does not exist`, err.Error())
+
+	assert.NoError(ensureRunning(f))
+	assert.True(f.Enabled())
+
+	status, err = f.Status()
+	assert.NoError(err)
+	assert.Equal(&systemservice.ServiceStatus{Running: true, PID: 1}, status)
+
+	assert.NoError(f.Restart())
+	status, _ = f.Status()
+	assert.Equal(2, status.PID)
+
+	// Stopping disables the service, starting enables it again
+	assert.NoError(f.Stop())
+	assert.False(f.Enabled())
+	assert.NoError(ensureRunning(f))
+	assert.True(f.Enabled())
+	status, _ = f.Status()
+	assert.Equal(&systemservice.ServiceStatus{Running: true, PID: 3}, status)
+
+	assert.NoError(f.Uninstall())
+	assert.False(f.Exists())
+	assert.False(f.Enabled())
+
+	assert.Equal([]string{
+		"Status", "Start",
+		"Exists", "Install", "Status",
+		"Restart", "Status",
+		"Stop", "Exists", "Running", "Start", "Status",
+		"Uninstall", "Exists",
+	}, f.Methods())
+	assert.Equal("Install(true)", f.Calls()[3].String())
+}
+
+func TestFakeInstallWithoutStart(t *testing.T) {
+	assert := assert.New(t)
+
+	f := New("app")
+	assert.NoError(f.Install(false))
+	assert.True(f.Exists())
+	assert.False(f.Enabled(), "only starting enables the service")
+
+	running, err := f.Running()
+	assert.NoError(err)
+	assert.False(running)
+
+	assert.NoError(f.Start())
+	assert.True(f.Enabled())
+
+	// Reinstalling keeps the service enabled
+	assert.NoError(f.Install(false))
+	assert.True(f.Enabled())
+}
+
+func TestFakeCrash(t *testing.T) {
+	assert := assert.New(t)
+
+	f := New("app")
+	assert.NoError(f.Install(true))
+
+	f.Crash()
+	running, err := f.Running()
+	assert.NoError(err)
+	assert.True(running)
+	assert.Equal(1, f.Restarts())
+
+	f.RestartOnFailure(false)
+	f.Crash()
+	running, _ = f.Running()
+	assert.False(running)
+	assert.True(f.Failed())
+	assert.Equal(1, f.Restarts())
+
+	// Crashing a stopped service does nothing
+	f.Crash()
+	assert.Equal(1, f.Restarts())
+
+	assert.NoError(f.Start())
+	assert.False(f.Failed())
+}
+
+func TestFakeFailures(t *testing.T) {
+	assert := assert.New(t)
+
+	boom := errors.New("boom")
+
+	var f Fake
+	f.FailOnce("Install", boom)
+	assert.Equal(boom, f.Install(true))
+	assert.False(f.Exists(), "a failed call does not change the service")
+	assert.NoError(f.Install(false))
+
+	f.Fail("Start", boom)
+	assert.Equal(boom, f.Start())
+	assert.Equal(boom, f.Start())
+	f.Fail("Start", nil)
+	assert.NoError(f.Start())
+
+	f.SetStatus(systemservice.ServiceStatus{Running: false})
+	running, err := f.Running()
+	assert.NoError(err)
+	assert.False(running)
+
+	f.SetStatus(systemservice.ServiceStatus{Running: true, PID: 42})
+	assert.NoError(f.Restart())
+	status, _ := f.Status()
+	assert.Equal(43, status.PID)
+}
@@ -0,0 +1,79 @@
+// +build linux
+
+package systemservice
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+func (s *SystemService) sysusers(args ...string) (out string, err error) {
+	return s.run("systemd-sysusers", args...)
+}
+
+/*
+sysusersFile represents a sysusers.d fragment which creates the
+dedicated system account of the service
+*/
+type sysusersFile struct {
+	Dir         string
+	Label       string
+	User        string
+	Group       string
+	Description string
+	Home        string
+}
+
+func newSysusersFile(serv *SystemService) sysusersFile {
+	cmd := serv.Command
+
+	home := "-"
+	if len(cmd.Directories.State.Paths) > 0 {
+		home = filepath.Join("/var/lib", cmd.Directories.State.Paths[0])
+	}
+
+	description := cmd.Description
+	if description == "" {
+		description = cmd.Name
+	}
+
+	return sysusersFile{
+		Dir:         filepath.Join(cmd.Root, "/etc/sysusers.d"),
+		Label:       cmd.Label,
+		User:        cmd.User,
+		Group:       cmd.Group,
+		Description: strings.Replace(description, `"`, "'", -1),
+		Home:        home,
+	}
+}
+
+func (f *sysusersFile) Generate() (string, error) {
+	var tmpl bytes.Buffer
+	t := template.Must(template.New("sysusersFile").Parse(sysusersFileTemplate()))
+	if err := t.Execute(&tmpl, f); err != nil {
+		return "", err
+	}
+
+	return tmpl.String(), nil
+}
+
+func (f *sysusersFile) Path() string {
+	return filepath.Join(f.Dir, f.Label+".conf")
+}
+
+/*
+sysusersFileTemplate generates the contents of the sysusers.d file.
+
+When a separate group is requested it is created first and the user
+is made a member of it, otherwise sysusers creates a group of the
+same name as the user.
+*/
+func sysusersFileTemplate() string {
+	return `# Created by systemservice for {{ .Label }}
+{{ if and .Group (ne .Group .User) }}g {{ .Group }} -
+{{ end }}u {{ .User }} - {{ if .Description }}"{{ .Description }}"{{ else }}-{{ end }} {{ .Home }}
+{{ if and .Group (ne .Group .User) }}m {{ .User }} {{ .Group }}
+{{ end }}`
+}
@@ -0,0 +1,376 @@
+// +build linux
+
+package systemservice
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+/*
+initScript represents the LSB init script of a service managed by
+SysV init
+*/
+type initScript struct {
+	Dir              string
+	Label            string
+	ShortDescription string
+	Description      string
+	RequiredStart    string
+	ShouldStart      string
+	PIDFile          string
+	StopTimeout      int
+	Environment      []string
+	Start            string
+}
+
+func newInitScript(serv *SystemService) initScript {
+	cmd := serv.Command
+
+	short := cmd.Description
+	if short == "" {
+		short = cmd.Name
+	}
+	if short == "" {
+		short = cmd.Label
+	}
+
+	var env []string
+	for _, e := range cmd.environment() {
+		kv := strings.SplitN(e, "=", 2)
+		env = append(env, kv[0]+"="+shellQuote(kv[1]))
+	}
+
+	return initScript{
+		Dir:              filepath.Join(cmd.Root, "/etc/init.d"),
+		Label:            cmd.Label,
+		ShortDescription: oneLine(short),
+		Description:      oneLine(cmd.Description),
+		RequiredStart:    strings.Join(append([]string{"$remote_fs", "$syslog", "$network"}, lsbNames(cmd.Dependencies.Requires, cmd.Dependencies.BindsTo)...), " "),
+		ShouldStart:      strings.Join(lsbNames(cmd.Dependencies.After, cmd.Dependencies.Wants), " "),
+		PIDFile:          cmd.sysvPIDFile(),
+		StopTimeout:      int(cmd.stopTimeout().Seconds()),
+		Environment:      env,
+		Start:            cmd.sysvStart(),
+	}
+}
+
+/*
+sysvPIDFile returns the PID file of the service: the one the program
+writes for forking services, one written by the script otherwise
+*/
+func (c *ServiceCommand) sysvPIDFile() string {
+	if c.PIDFile != "" {
+		return c.PIDFile
+	}
+	return "/var/run/" + c.Label + ".pid"
+}
+
+/*
+sysvStart returns the body of the start action. Programs are run in
+the background with start-stop-daemon if it is installed, the shell
+otherwise.
+*/
+func (c *ServiceCommand) sysvStart() string {
+	commandLine := shellQuote(c.Program)
+	if args := shellQuoteAll(c.Args); args != "" {
+		commandLine += " " + args
+	}
+
+	// Runs a shell command as the user of the service
+	asUser := func(command string) string {
+		if c.User == "" {
+			return command
+		}
+		return "su -m -s /bin/sh -c " + shellQuote(command) + " " + shellQuote(c.User)
+	}
+
+	chuid := ""
+	if c.User != "" {
+		user := c.User
+		if c.Group != "" {
+			user += ":" + c.Group
+		}
+		chuid = " --chuid " + shellQuote(user)
+	}
+
+	daemon := func(options string) string {
+		return "start-stop-daemon --start --quiet" + options + ` --pidfile "$PIDFILE"` + chuid +
+			" --exec " + shellQuote(c.Program) + " -- " + shellQuoteAll(c.Args)
+	}
+
+	switch c.serviceType() {
+	case ServiceTypeOneshot:
+		return "\t" + asUser(commandLine) + "\n"
+	case ServiceTypeForking:
+		return "\tif command -v start-stop-daemon >/dev/null 2>&1; then\n" +
+			"\t\t" + daemon("") + "\n" +
+			"\telse\n" +
+			"\t\t" + asUser(commandLine) + "\n" +
+			"\tfi\n"
+	}
+
+	background := commandLine + " >/dev/null 2>&1 &"
+	fallback := "\t\t" + background + "\n\t\techo $! > \"$PIDFILE\"\n"
+	if c.User != "" {
+		fallback = "\t\t" + asUser(background+" echo $!") + " > \"$PIDFILE\"\n"
+	}
+
+	return "\tif command -v start-stop-daemon >/dev/null 2>&1; then\n" +
+		"\t\t" + daemon(" --background --make-pidfile") + "\n" +
+		"\telse\n" +
+		fallback +
+		"\tfi\n"
+}
+
+/*
+validateSysV returns an error if the command uses an option SysV init
+has no equivalent for
+*/
+func validateSysV(serv *SystemService) error {
+	cmd := serv.Command
+
+	unsupported := func(option, reason string) error {
+		return &UnsupportedOptionError{Backend: string(BackendSysV), Option: option, Reason: reason}
+	}
+
+	if serv.scope() == ScopeUser {
+		return unsupported("user scope", "init scripts are system wide, install the service as root")
+	}
+
+	if !filepath.IsAbs(cmd.Program) {
+		return unsupported("relative Program", "init scripts run with a minimal PATH, use an absolute path")
+	}
+
+	if err := cmd.validateShellEnvironment(); err != nil {
+		return err
+	}
+
+	switch t := cmd.serviceType(); t {
+	case ServiceTypeNotify, ServiceTypeNotifyReload:
+		return unsupported(fmt.Sprintf("service type %q", t), "there is no readiness notification")
+	case ServiceTypeForking:
+		if cmd.PIDFile == "" {
+			return unsupported("forking service without PIDFile", "the script tracks the program through its PID file")
+		}
+	}
+
+	switch {
+	case cmd.Sockets.enabled():
+		return unsupported("Sockets", "init cannot start services on demand, use inetd")
+	case cmd.Schedule.enabled():
+		return unsupported("Schedule", "use cron for periodic jobs")
+	case len(cmd.Triggers) > 0:
+		return unsupported("Triggers", "init cannot watch paths")
+	case cmd.WatchdogSec > 0:
+		return unsupported("WatchdogSec", "init does not supervise services")
+	case len(cmd.Hardening.directives()) > 0:
+		return unsupported("Hardening", "init cannot sandbox services")
+	case cmd.DynamicUser:
+		return unsupported("DynamicUser", "init cannot allocate users")
+	case cmd.CreateUser:
+		return unsupported("CreateUser", "create the account when provisioning the machine")
+	case len(cmd.Directories.directives()) > 0:
+		return unsupported("Directories", "init does not manage directories")
+	case len(cmd.Extra) > 0:
+		return unsupported("Extra", "raw directives are systemd unit directives")
+	}
+
+	return nil
+}
+
+/*
+lsbNames returns the init script names of the given units or labels,
+skipping systemd targets which have no equivalent
+*/
+func lsbNames(lists ...[]string) []string {
+	var names []string
+	for _, list := range lists {
+		for _, name := range list {
+			if strings.HasSuffix(name, ".target") {
+				continue
+			}
+			names = append(names, strings.TrimSuffix(name, ".service"))
+		}
+	}
+	return names
+}
+
+/*
+shellNamePattern matches the names of shell variables
+*/
+var shellNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+/*
+validateShellEnvironment returns an error if a key of the environment
+is not a shell variable name. Scripts export the keys as they are, only
+the values are quoted.
+*/
+func (c *ServiceCommand) validateShellEnvironment() error {
+	keys := make([]string, 0, len(c.Environment))
+	for k := range c.Environment {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if !shellNamePattern.MatchString(k) {
+			return fmt.Errorf("environment variable %q is not a valid name, it must match %s", k, shellNamePattern)
+		}
+	}
+	return nil
+}
+
+/*
+oneLine joins the lines of s, header fields must fit on a line
+*/
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+/*
+shellQuote quotes s for the shell if it contains anything but safe
+characters
+*/
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("@%+=:,./_-", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func shellQuoteAll(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (i *initScript) Generate() (string, error) {
+	var out bytes.Buffer
+	t := template.Must(template.New("initScript").Parse(initScriptTemplate))
+	if err := t.Execute(&out, i); err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
+
+func (i *initScript) Path() string {
+	return filepath.Join(i.Dir, i.Label)
+}
+
+/*
+Quote returns a shell quoted value for the template
+*/
+func (i *initScript) Quote(s string) string {
+	return shellQuote(s)
+}
+
+/*
+initScriptTemplate is the LSB init script. Exit codes follow the LSB
+init script actions: status returns 0 if the program is running, 1 if
+it died leaving its PID file behind and 3 if it is not running.
+*/
+const initScriptTemplate = `#!/bin/sh
+### BEGIN INIT INFO
+# Provides:          {{ .Label }}
+# Required-Start:    {{ .RequiredStart }}
+# Required-Stop:     {{ .RequiredStart }}{{ if .ShouldStart }}
+# Should-Start:      {{ .ShouldStart }}
+# Should-Stop:       {{ .ShouldStart }}{{ end }}
+# Default-Start:     2 3 4 5
+# Default-Stop:      0 1 6
+# Short-Description: {{ .ShortDescription }}{{ if .Description }}
+# Description:       {{ .Description }}{{ end }}
+### END INIT INFO
+
+# Generated by systemservice, changes are overwritten on install
+
+NAME={{ .Quote .Label }}
+PIDFILE={{ .Quote .PIDFile }}
+STOP_TIMEOUT={{ .StopTimeout }}
+{{ range .Environment }}
+export {{ . }}{{ end }}
+
+running() {
+	[ -f "$PIDFILE" ] && kill -0 "$(cat "$PIDFILE")" 2>/dev/null
+}
+
+do_start() {
+	running && return 0
+{{ .Start }}}
+
+do_stop() {
+	if ! running; then
+		rm -f "$PIDFILE"
+		return 0
+	fi
+
+	pid=$(cat "$PIDFILE")
+	kill -TERM "$pid"
+
+	waited=0
+	while kill -0 "$pid" 2>/dev/null; do
+		if [ "$waited" -ge "$STOP_TIMEOUT" ]; then
+			kill -KILL "$pid"
+			break
+		fi
+		sleep 1
+		waited=$((waited + 1))
+	done
+
+	rm -f "$PIDFILE"
+}
+
+do_reload() {
+	running || return 7
+	kill -HUP "$(cat "$PIDFILE")"
+}
+
+do_status() {
+	if running; then
+		echo "$NAME is running"
+		return 0
+	fi
+	if [ -f "$PIDFILE" ]; then
+		echo "$NAME is dead but its PID file exists"
+		return 1
+	fi
+	echo "$NAME is not running"
+	return 3
+}
+
+case "$1" in
+	start)
+		do_start
+		;;
+	stop)
+		do_stop
+		;;
+	restart|force-reload)
+		do_stop && do_start
+		;;
+	reload)
+		do_reload
+		;;
+	status)
+		do_status
+		;;
+	*)
+		echo "Usage: $0 {start|stop|restart|reload|force-reload|status}" >&2
+		exit 3
+		;;
+esac
+`
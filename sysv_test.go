@@ -0,0 +1,237 @@
+// +build linux
+
+package systemservice
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitScript(t *testing.T) {
+	assert := assert.New(t)
+
+	serv := New(ServiceCommand{
+		Label:       "app",
+		Description: "My\napp",
+		Program:     "/usr/bin/app",
+		Args:        []string{"--name", "it's me"},
+		Environment: map[string]string{"GREETING": "hello world"},
+		StopTimeout: 5 * time.Second,
+		Dependencies: Dependencies{
+			After:    []string{"network-online.target", "postgresql.service"},
+			Requires: []string{"redis"},
+		},
+	}, WithBackend(BackendSysV))
+
+	script := newInitScript(&serv)
+	content, err := script.Generate()
+	assert.NoError(err)
+
+	assert.Equal("/etc/init.d/app", script.Path())
+	assert.Contains(content, "# Provides:          app\n")
+	assert.Contains(content, "# Required-Start:    $remote_fs $syslog $network redis\n")
+	assert.Contains(content, "# Should-Start:      postgresql\n")
+	assert.Contains(content, "# Short-Description: My app\n")
+	assert.Contains(content, "PIDFILE=/var/run/app.pid\nSTOP_TIMEOUT=5\n")
+	assert.Contains(content, "export GREETING='hello world'\n")
+	assert.Contains(content, `--exec /usr/bin/app -- --name 'it'\''s me'`)
+}
+
+func TestSysVStart(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      ServiceCommand
+		contains []string
+	}{
+		{
+			name: "simple",
+			cmd:  ServiceCommand{Label: "app", Program: "/usr/bin/app"},
+			contains: []string{
+				`start-stop-daemon --start --quiet --background --make-pidfile --pidfile "$PIDFILE" --exec /usr/bin/app -- `,
+				"/usr/bin/app >/dev/null 2>&1 &\n\t\techo $! > \"$PIDFILE\"",
+			},
+		},
+		{
+			name: "user",
+			cmd:  ServiceCommand{Label: "app", Program: "/usr/bin/app", User: "app", Group: "staff"},
+			contains: []string{
+				"--chuid app:staff --exec /usr/bin/app",
+				`su -m -s /bin/sh -c '/usr/bin/app >/dev/null 2>&1 & echo $!' app > "$PIDFILE"`,
+			},
+		},
+		{
+			name: "forking",
+			cmd:  ServiceCommand{Label: "app", Program: "/usr/sbin/appd", Type: ServiceTypeForking, PIDFile: "/run/appd.pid"},
+			contains: []string{
+				`start-stop-daemon --start --quiet --pidfile "$PIDFILE" --exec /usr/sbin/appd`,
+				"\t\t/usr/sbin/appd\n",
+			},
+		},
+		{
+			name:     "oneshot",
+			cmd:      ServiceCommand{Label: "app", Program: "/usr/bin/migrate", Args: []string{"up"}, Type: ServiceTypeOneshot},
+			contains: []string{"\t/usr/bin/migrate up\n"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start := test.cmd.sysvStart()
+			for _, s := range test.contains {
+				assert.Contains(t, start, s)
+			}
+		})
+	}
+}
+
+func TestValidateSysV(t *testing.T) {
+	tests := []struct {
+		name   string
+		cmd    ServiceCommand
+		option string
+	}{
+		{"valid", ServiceCommand{Label: "app", Program: "/usr/bin/app"}, ""},
+		{"relative program", ServiceCommand{Label: "app", Program: "app"}, "relative Program"},
+		{"notify", ServiceCommand{Label: "app", Program: "/usr/bin/app", Type: ServiceTypeNotify}, `service type "notify"`},
+		{"forking without pid file", ServiceCommand{Label: "app", Program: "/usr/bin/app", Type: ServiceTypeForking}, "forking service without PIDFile"},
+		{"watchdog", ServiceCommand{Label: "app", Program: "/usr/bin/app", WatchdogSec: 10}, "WatchdogSec"},
+		{"extra", ServiceCommand{Label: "app", Program: "/usr/bin/app", Extra: []Section{{Name: "Service", Directives: []Directive{{Key: "Nice", Value: "5"}}}}}, "Extra"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serv := New(test.cmd, WithBackend(BackendSysV), WithScope(ScopeSystem))
+
+			err := validateSysV(&serv)
+			if test.option == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			var unsupported *UnsupportedOptionError
+			if assert.True(t, errors.As(err, &unsupported)) {
+				assert.Equal(t, "sysv", unsupported.Backend)
+				assert.Equal(t, test.option, unsupported.Option)
+			}
+		})
+	}
+}
+
+func TestInstallSysV(t *testing.T) {
+	assert := assert.New(t)
+
+	fs := newLinkMemFs()
+	assert.NoError(afero.WriteFile(fs, "/usr/sbin/update-rc.d", nil, 0755))
+
+	var calls []string
+	runner := RunnerFunc(func(name string, args ...string) (string, error) {
+		calls = append(calls, name+" "+strings.Join(args, " "))
+		switch {
+		case name == "/etc/init.d/app" && args[0] == "start":
+			assert.NoError(afero.WriteFile(fs, "/var/run/app.pid", []byte("42\n"), 0644))
+			assert.NoError(fs.MkdirAll("/proc/42", 0755))
+		case name == "/etc/init.d/app" && args[0] == "stop":
+			assert.NoError(fs.RemoveAll("/proc/42"))
+		}
+		return "", nil
+	})
+
+	serv := New(ServiceCommand{Label: "app", Program: "/usr/bin/app"},
+		WithFS(fs),
+		WithRunner(runner),
+		WithClock(&fakeClock{}),
+		WithScope(ScopeSystem),
+		WithBackend(BackendSysV),
+	)
+
+	assert.NoError(serv.Install(true))
+	assert.True(serv.Exists())
+
+	info, err := fs.Stat("/etc/init.d/app")
+	assert.NoError(err)
+	assert.Equal("-rwxr-xr-x", info.Mode().String())
+
+	status, err := serv.Status()
+	assert.NoError(err)
+	assert.Equal(&ServiceStatus{Running: true, PID: 42}, status)
+
+	assert.NoError(serv.Uninstall())
+	assert.False(serv.Exists())
+
+	assert.Equal([]string{
+		"/usr/sbin/update-rc.d app defaults",
+		"/etc/init.d/app start",
+		"/etc/init.d/app stop",
+		"/usr/sbin/update-rc.d -f app remove",
+	}, calls)
+
+	var unsupported *UnsupportedOptionError
+	_, err = serv.ListInstances()
+	assert.True(errors.As(err, &unsupported))
+	assert.Equal("instances", unsupported.Option)
+}
+
+func TestSysVEnvironmentNames(t *testing.T) {
+	assert := assert.New(t)
+
+	fs := newLinkMemFs()
+	serv := New(ServiceCommand{
+		Label:       "app",
+		Program:     "/usr/bin/app",
+		Environment: map[string]string{"PORT": "80", "A;rm -rf /;B": "x"},
+	}, WithFS(fs), WithScope(ScopeSystem), WithBackend(BackendSysV))
+
+	err := serv.Install(false)
+	assert.EqualError(err, `environment variable "A;rm -rf /;B" is not a valid name, it must match ^[A-Za-z_][A-Za-z0-9_]*$`)
+	assert.False(fileExists(fs, "/etc/init.d/app"))
+
+	for _, name := range []string{"_", "HTTP_PORT", "a1"} {
+		serv.Command.Environment = map[string]string{name: "x"}
+		assert.NoError(validateSysV(&serv), name)
+	}
+	for _, name := range []string{"", "1A", "A-B", "A B", "A=B", "$(id)"} {
+		serv.Command.Environment = map[string]string{name: "x"}
+		assert.Error(validateSysV(&serv), name)
+	}
+}
+
+func TestInstallSysVBelowRoot(t *testing.T) {
+	assert := assert.New(t)
+
+	fs := newLinkMemFs()
+	serv := New(ServiceCommand{Label: "app", Program: "/usr/bin/app", Root: "/image"},
+		WithFS(fs),
+		WithRunner(RunnerFunc(func(name string, args ...string) (string, error) {
+			t.Errorf("unexpected command %s %v", name, args)
+			return "", nil
+		})),
+		WithBackend(BackendSysV),
+	)
+
+	assert.NoError(serv.Install(true))
+	assert.True(fileExists(fs, "/image/etc/init.d/app"))
+
+	for link, target := range map[string]string{
+		"/image/etc/rc2.d/S20app": "../init.d/app",
+		"/image/etc/rc5.d/S20app": "../init.d/app",
+		"/image/etc/rc0.d/K80app": "../init.d/app",
+		"/image/etc/rc6.d/K80app": "../init.d/app",
+	} {
+		assert.Equal(target, fs.links[link], link)
+	}
+
+	diff, err := serv.Diff()
+	assert.NoError(err)
+	assert.False(diff.Changed())
+
+	assert.NoError(serv.Uninstall())
+	links, err := afero.Glob(fs, "/image/etc/rc[0-6].d/*")
+	assert.NoError(err)
+	assert.Empty(links)
+	assert.False(fileExists(fs, "/image/etc/init.d/app"))
+}
@@ -0,0 +1,85 @@
+package systemservice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+timespan formats a duration as a systemd time span, e.g. "30s" or
+"1500ms"
+*/
+func timespan(d time.Duration) string {
+	switch {
+	case d%time.Second == 0:
+		return strconv.FormatInt(int64(d/time.Second), 10) + "s"
+	case d%time.Millisecond == 0:
+		return strconv.FormatInt(int64(d/time.Millisecond), 10) + "ms"
+	default:
+		return strconv.FormatInt(int64(d/time.Microsecond), 10) + "us"
+	}
+}
+
+/*
+timespanUnits are the units systemd accepts in time spans
+*/
+var timespanUnits = map[string]time.Duration{
+	"us": time.Microsecond, "usec": time.Microsecond, "µs": time.Microsecond,
+	"ms": time.Millisecond, "msec": time.Millisecond,
+	"s": time.Second, "sec": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+	"M": 2629800 * time.Second, "month": 2629800 * time.Second, "months": 2629800 * time.Second,
+	"y": 31557600 * time.Second, "year": 31557600 * time.Second, "years": 31557600 * time.Second,
+}
+
+/*
+parseTimespan parses a systemd time span such as "30", "1min 30s" or
+"500ms". Numbers without a unit are seconds.
+*/
+func parseTimespan(s string) (time.Duration, error) {
+	rest := strings.TrimSpace(s)
+	if rest == "" {
+		return 0, fmt.Errorf("empty time span")
+	}
+
+	var total time.Duration
+	for rest != "" {
+		i := 0
+		for i < len(rest) && (rest[i] >= '0' && rest[i] <= '9' || rest[i] == '.') {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("invalid time span %q", s)
+		}
+
+		n, err := strconv.ParseFloat(rest[:i], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time span %q", s)
+		}
+
+		rest = strings.TrimLeft(rest[i:], " ")
+
+		j := 0
+		for j < len(rest) && rest[j] != ' ' && (rest[j] < '0' || rest[j] > '9') {
+			j++
+		}
+
+		unit := time.Second
+		if j > 0 {
+			var ok bool
+			if unit, ok = timespanUnits[rest[:j]]; !ok {
+				return 0, fmt.Errorf("unknown unit %q in time span %q", rest[:j], s)
+			}
+		}
+
+		total += time.Duration(n * float64(unit))
+		rest = strings.TrimLeft(rest[j:], " ")
+	}
+
+	return total, nil
+}
@@ -0,0 +1,192 @@
+package systemservice
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+/*
+defaultStartCheckDelay is how long a started service has to keep
+running before Install considers it started, unless the service sets
+its own delay
+*/
+const defaultStartCheckDelay = time.Second
+
+/*
+startCheck waits for the start check delay of the service
+*/
+func (s *SystemService) startCheck() {
+	delay := s.startCheckDelay
+	if delay == 0 {
+		delay = defaultStartCheckDelay
+	}
+	s.sleep(delay)
+}
+
+/*
+writeFileAtomic writes the file through a temporary file in the same
+folder which is synced and renamed over it, so the file either has
+its old or its new content after a crash. The folder is created if
+needed.
+*/
+func writeFileAtomic(fs afero.Fs, path string, content []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+
+	if err := fs.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	// Starts with a dot so service managers do not pick it up
+	tmp, err := afero.TempFile(fs, dir, "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		fs.Remove(tmpPath)
+		return err
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		fs.Remove(tmpPath)
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		fs.Remove(tmpPath)
+		return err
+	}
+
+	if err := fs.Chmod(tmpPath, perm); err != nil {
+		fs.Remove(tmpPath)
+		return err
+	}
+
+	if err := fs.Rename(tmpPath, path); err != nil {
+		fs.Remove(tmpPath)
+		return err
+	}
+
+	syncDir(fs, dir)
+
+	return nil
+}
+
+/*
+syncDir makes a rename in the folder durable. Not all platforms can
+sync folders, so errors are ignored.
+*/
+func syncDir(fs afero.Fs, dir string) {
+	d, err := fs.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+/*
+fileBackup is the content of a file before a transaction changed it
+*/
+type fileBackup struct {
+	path    string
+	content []byte
+	mode    os.FileMode
+	existed bool
+}
+
+/*
+transaction records the previous content of the files written during
+an install, so they can be restored if a later step fails
+*/
+type transaction struct {
+	fs      afero.Fs
+	log     fieldLogger
+	backups []fileBackup
+}
+
+/*
+newTransaction starts a transaction on the file system of the service
+*/
+func (s *SystemService) newTransaction() *transaction {
+	return &transaction{fs: s.fs(), log: s.log()}
+}
+
+/*
+backup records the current content of the file, only the first call
+for a path has an effect
+*/
+func (t *transaction) backup(path string) error {
+	for _, b := range t.backups {
+		if b.path == path {
+			return nil
+		}
+	}
+
+	b := fileBackup{path: path}
+
+	info, err := t.fs.Stat(path)
+
+	if err == nil {
+		b.existed = true
+		b.mode = info.Mode().Perm()
+
+		if b.content, err = afero.ReadFile(t.fs, path); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	t.backups = append(t.backups, b)
+
+	return nil
+}
+
+/*
+writeFile backs up the file and writes it atomically
+*/
+func (t *transaction) writeFile(path string, content []byte, perm os.FileMode) error {
+	if err := t.backup(path); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(t.fs, path, content, perm)
+}
+
+/*
+rollback restores the files written during the transaction, removing
+the ones which did not exist before. All files are restored even if
+one fails, the first error is returned.
+*/
+func (t *transaction) rollback() error {
+	var first error
+
+	for i := len(t.backups) - 1; i >= 0; i-- {
+		b := t.backups[i]
+
+		var err error
+		if b.existed {
+			t.log.Debug("restoring file", "path", b.path)
+			err = writeFileAtomic(t.fs, b.path, b.content, b.mode)
+		} else {
+			t.log.Debug("removing file", "path", b.path)
+			if err = t.fs.Remove(b.path); os.IsNotExist(err) {
+				err = nil
+			}
+		}
+
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,81 @@
+package systemservice
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "atomic")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sub", "app.service")
+
+	assert.NoError(writeFileAtomic(afero.NewOsFs(), path, []byte("new\n"), 0640))
+
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.Equal("new\n", string(content))
+
+	info, err := os.Stat(path)
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0640), info.Mode().Perm())
+
+	// No temporary files are left behind
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	assert.NoError(err)
+	assert.Len(files, 1)
+}
+
+func TestTransactionRollback(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "transaction")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	existing := filepath.Join(dir, "app.service")
+	added := filepath.Join(dir, "app.timer")
+	assert.NoError(ioutil.WriteFile(existing, []byte("old\n"), 0600))
+
+	serv := New(ServiceCommand{}, WithFS(afero.NewOsFs()))
+	tx := serv.newTransaction()
+	assert.NoError(tx.writeFile(existing, []byte("new\n"), 0644))
+	assert.NoError(tx.writeFile(existing, []byte("newer\n"), 0644))
+	assert.NoError(tx.writeFile(added, []byte("timer\n"), 0644))
+
+	assert.NoError(tx.rollback())
+
+	content, err := ioutil.ReadFile(existing)
+	assert.NoError(err)
+	assert.Equal("old\n", string(content))
+
+	info, err := os.Stat(existing)
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	_, err = os.Stat(added)
+	assert.True(os.IsNotExist(err))
+}
+
+func TestInstallError(t *testing.T) {
+	assert := assert.New(t)
+
+	cause := errors.New("start failed")
+
+	err := &InstallError{Err: cause}
+	assert.Equal("install failed and was rolled back: start failed", err.Error())
+	assert.True(errors.Is(err, cause))
+
+	err.RollbackErr = errors.New("permission denied")
+	assert.Equal("install failed: start failed, rolling back failed: permission denied", err.Error())
+}
@@ -0,0 +1,95 @@
+package systemservice
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+/*
+TriggerType is the condition on a path which starts the service
+*/
+type TriggerType string
+
+const (
+	// TriggerPathExists starts the service while the path exists.
+	TriggerPathExists TriggerType = "PathExists"
+
+	// TriggerPathChanged starts the service when a file is closed
+	// after writing, or the path is created, moved or deleted.
+	TriggerPathChanged TriggerType = "PathChanged"
+
+	// TriggerPathModified is like TriggerPathChanged but also starts
+	// the service on every write, not only on close.
+	TriggerPathModified TriggerType = "PathModified"
+
+	// TriggerDirectoryNotEmpty starts the service while the directory
+	// contains files, e.g. for spool directories.
+	TriggerDirectoryNotEmpty TriggerType = "DirectoryNotEmpty"
+)
+
+/*
+Trigger starts the service when something happens to a path.
+
+With systemd the triggers are installed as a companion <label>.path
+unit. With launchd, TriggerDirectoryNotEmpty maps to QueueDirectories
+and all other types to WatchPaths.
+*/
+type Trigger struct {
+	Type TriggerType
+
+	// The absolute path to watch
+	Path string
+}
+
+/*
+validateTriggers checks that the triggers have a known type and an
+absolute path
+*/
+func (c *ServiceCommand) validateTriggers() error {
+	for _, t := range c.Triggers {
+		switch t.Type {
+		case TriggerPathExists, TriggerPathChanged, TriggerPathModified, TriggerDirectoryNotEmpty:
+		default:
+			return fmt.Errorf("unknown trigger type %q", t.Type)
+		}
+
+		if !filepath.IsAbs(t.Path) {
+			return fmt.Errorf("trigger path %q must be absolute", t.Path)
+		}
+	}
+	return nil
+}
+
+/*
+triggerDirectives returns the [Path] directives for systemd
+*/
+func (c *ServiceCommand) triggerDirectives() []Directive {
+	var out []Directive
+	for _, t := range c.Triggers {
+		out = append(out, Directive{Key: string(t.Type), Value: t.Path})
+	}
+	return out
+}
+
+/*
+launchdTriggers splits the triggers into the WatchPaths and
+QueueDirectories of launchd
+*/
+func (c *ServiceCommand) launchdTriggers() (watchPaths []string, queueDirectories []string) {
+	for _, t := range c.Triggers {
+		if t.Type == TriggerDirectoryNotEmpty {
+			queueDirectories = append(queueDirectories, t.Path)
+		} else {
+			watchPaths = append(watchPaths, t.Path)
+		}
+	}
+	return watchPaths, queueDirectories
+}
+
+/*
+activated returns whether or not the service is started on demand by
+a socket, schedule or trigger instead of running all the time
+*/
+func (c *ServiceCommand) activated() bool {
+	return c.Sockets.enabled() || c.Schedule.enabled() || len(c.Triggers) > 0
+}
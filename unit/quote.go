@@ -0,0 +1,211 @@
+package unit
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+escapes are the C style escapes understood in quoted words, keyed by
+the character following the backslash
+*/
+var escapes = map[byte]byte{
+	'n': '\n', 't': '\t', 'r': '\r', '\\': '\\', '"': '"', '\'': '\'', ' ': ' ',
+}
+
+/*
+Quote returns s as a double quoted word, escaping backslashes, quotes
+and control characters. Percent and dollar signs are not escaped, see
+EscapeSpecifiers and EscapeVariables.
+*/
+func Quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '"':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+/*
+QuoteWord returns s unchanged if systemd reads it as a single word,
+and quoted otherwise, see Quote
+*/
+func QuoteWord(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\r\n\"'\\") {
+		return Quote(s)
+	}
+	return s
+}
+
+/*
+QuoteCommand joins a program and its arguments into a command line for
+ExecStart= and the other Exec directives, quoting the words which
+need it. Percent and dollar signs are escaped, so systemd passes the
+words on as they are.
+*/
+func QuoteCommand(words ...string) string {
+	return quoteCommand("", words)
+}
+
+/*
+QuoteTemplateCommand is QuoteCommand for template units: "%i" is left
+for systemd to replace by the instance name
+*/
+func QuoteTemplateCommand(words ...string) string {
+	return quoteCommand("i", words)
+}
+
+func quoteCommand(keep string, words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = QuoteWord(EscapeVariables(EscapeSpecifiers(w, keep)))
+	}
+	return strings.Join(quoted, " ")
+}
+
+/*
+SplitCommand splits a command line of an Exec directive into words,
+decoding "%%" and "$$". It is the inverse of QuoteCommand. Other
+specifiers and variables are left as they are, see Specifiers and
+HasVariables.
+*/
+func SplitCommand(s string) ([]string, error) {
+	words, err := SplitWords(s)
+	if err != nil {
+		return nil, err
+	}
+	for i, w := range words {
+		words[i] = strings.Replace(UnescapeSpecifiers(w), "$$", "$", -1)
+	}
+	return words, nil
+}
+
+/*
+EscapeSpecifiers doubles the percent signs of s so systemd does not
+expand them, except for the specifiers whose letters are in keep
+*/
+func EscapeSpecifiers(s string, keep string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && (i+1 == len(s) || strings.IndexByte(keep, s[i+1]) < 0) {
+			b.WriteByte('%')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+/*
+UnescapeSpecifiers replaces "%%" by a single percent sign, other
+specifiers are kept
+*/
+func UnescapeSpecifiers(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		b.WriteByte(s[i])
+		if s[i] == '%' && i+1 < len(s) {
+			i++
+			if s[i] != '%' {
+				b.WriteByte(s[i])
+			}
+		}
+	}
+	return b.String()
+}
+
+/*
+EscapeVariables doubles the dollar signs of s, so systemd does not
+substitute environment variables in Exec directives
+*/
+func EscapeVariables(s string) string {
+	return strings.Replace(s, "$", "$$", -1)
+}
+
+/*
+HasVariables returns whether or not systemd substitutes environment
+variables in s, i.e. it has a dollar sign which is not doubled
+*/
+func HasVariables(s string) bool {
+	return strings.Contains(strings.Replace(s, "$$", "", -1), "$")
+}
+
+/*
+SplitWords splits a command line or a list of environment assignments
+the way systemd does: words are separated by whitespace and may be
+quoted with single or double quotes, in which backslash escapes are
+decoded. Specifiers and variables are not decoded, see SplitCommand.
+*/
+func SplitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		case c == '"' || c == '\'':
+			inWord = true
+			quote := c
+			i++
+			for ; i < len(s) && s[i] != quote; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+					word.WriteByte(unescape(s[i]))
+					continue
+				}
+				word.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, fmt.Errorf("unterminated quote in %q", s)
+			}
+
+		case c == '\\' && i+1 < len(s):
+			inWord = true
+			i++
+			word.WriteByte(unescape(s[i]))
+
+		default:
+			inWord = true
+			word.WriteByte(c)
+		}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+/*
+unescape decodes the character following a backslash. Unknown escapes
+stand for the character itself.
+*/
+func unescape(c byte) byte {
+	if e, ok := escapes[c]; ok {
+		return e
+	}
+	return c
+}
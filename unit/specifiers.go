@@ -0,0 +1,56 @@
+package unit
+
+import "fmt"
+
+/*
+Expand replaces the specifiers (e.g. "%i" or "%h") in a value with the
+given values, keyed by the specifier letter. "%%" is replaced by a
+single percent sign. Specifiers without a value are an error, so
+callers notice values which depend on the host they are resolved on.
+*/
+func Expand(value string, specifiers map[byte]string) (string, error) {
+	var out []byte
+
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' {
+			out = append(out, value[i])
+			continue
+		}
+
+		if i+1 == len(value) {
+			return "", fmt.Errorf("incomplete specifier at the end of %q", value)
+		}
+
+		i++
+		if value[i] == '%' {
+			out = append(out, '%')
+			continue
+		}
+
+		v, ok := specifiers[value[i]]
+		if !ok {
+			return "", fmt.Errorf("unknown specifier %%%c in %q", value[i], value)
+		}
+		out = append(out, v...)
+	}
+
+	return string(out), nil
+}
+
+/*
+Specifiers returns the specifier letters used in a value, in order of
+appearance and without "%%"
+*/
+func Specifiers(value string) []byte {
+	var out []byte
+	for i := 0; i+1 < len(value); i++ {
+		if value[i] != '%' {
+			continue
+		}
+		i++
+		if value[i] != '%' {
+			out = append(out, value[i])
+		}
+	}
+	return out
+}
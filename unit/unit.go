@@ -0,0 +1,212 @@
+/*
+Package unit reads and writes systemd unit files as ordered sections
+of directives.
+*/
+package unit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/*
+File is a unit file: its sections in order, each with its directives
+in order. Comments are kept with the section or directive they
+precede, so a parsed file is written back the way it was read.
+*/
+type File struct {
+	// The file name of the unit, e.g. "nginx.service". Empty if the
+	// unit was not read from a file.
+	Name string
+
+	Sections []Section
+
+	// The comment and empty lines after the last directive, each
+	// ending with a newline
+	Footer string
+}
+
+/*
+Section is a "[Name]" section of a unit file together with its
+directives in the order they appear.
+*/
+type Section struct {
+	Name       string
+	Directives []Directive
+
+	// The comment and empty lines before the section header, each
+	// ending with a newline
+	Comment string
+}
+
+/*
+Directive is a single "Key=Value" assignment of a unit file section.
+An empty Value resets the directive, dropping the values assigned by
+the unit file or earlier drop-ins.
+*/
+type Directive struct {
+	Key   string
+	Value string
+
+	// The comment and empty lines before the directive, each ending
+	// with a newline
+	Comment string
+}
+
+/*
+Section returns the first section with the given name, or nil if the
+file has no such section
+*/
+func (f *File) Section(name string) *Section {
+	for i := range f.Sections {
+		if f.Sections[i].Name == name {
+			return &f.Sections[i]
+		}
+	}
+	return nil
+}
+
+/*
+Add appends directives to the first section with the given name,
+adding the section to the end of the file if there is none
+*/
+func (f *File) Add(section string, directives ...Directive) {
+	s := f.Section(section)
+	if s == nil {
+		f.Sections = append(f.Sections, Section{Name: section})
+		s = &f.Sections[len(f.Sections)-1]
+	}
+	s.Directives = append(s.Directives, directives...)
+}
+
+/*
+Get returns the last value assigned to the key in the section, which
+is the one systemd uses for directives taking a single value
+*/
+func (s Section) Get(key string) (string, bool) {
+	values := s.Values(key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+/*
+Values returns all values assigned to the key in the section
+*/
+func (s Section) Values(key string) []string {
+	var values []string
+	for _, d := range s.Directives {
+		if d.Key == key {
+			values = append(values, d.Value)
+		}
+	}
+	return values
+}
+
+/*
+Parse reads a unit file. Lines ending with a backslash are joined with
+the next one and repeated keys are kept in order. Comments and empty
+lines are kept with the section or directive which follows them,
+except for comments within a continued line, which are dropped.
+Values are not unquoted and specifiers are not expanded, see
+SplitWords and Expand.
+*/
+func Parse(r io.Reader) (*File, error) {
+	f := &File{}
+	var comment strings.Builder
+	var continued string
+	var continuing bool
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line != "" && (line[0] == '#' || line[0] == ';') {
+			if !continuing {
+				comment.WriteString(line + "\n")
+			}
+			continue
+		}
+
+		if continuing {
+			line = continued + " " + line
+			continuing = false
+		} else if line == "" {
+			comment.WriteString("\n")
+			continue
+		}
+
+		if strings.HasSuffix(line, "\\") {
+			continued = strings.TrimSpace(strings.TrimSuffix(line, "\\"))
+			continuing = true
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || len(line) == 2 {
+				return nil, fmt.Errorf("line %d: invalid section header %q", n, line)
+			}
+			f.Sections = append(f.Sections, Section{
+				Name:    line[1 : len(line)-1],
+				Comment: comment.String(),
+			})
+			comment.Reset()
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("line %d: expected Key=Value, got %q", n, line)
+		}
+
+		if len(f.Sections) == 0 {
+			return nil, fmt.Errorf("line %d: directive %q outside of a section", n, line)
+		}
+
+		current := &f.Sections[len(f.Sections)-1]
+		current.Directives = append(current.Directives, Directive{
+			Key:     strings.TrimSpace(parts[0]),
+			Value:   strings.TrimSpace(parts[1]),
+			Comment: comment.String(),
+		})
+		comment.Reset()
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if continuing {
+		return nil, fmt.Errorf("unexpected end of file after line continuation")
+	}
+
+	f.Footer = comment.String()
+
+	return f, nil
+}
+
+/*
+ParseFile reads the unit file at path, setting the name of the unit
+to the file name
+*/
+func ParseFile(path string) (*File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	f, err := Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	f.Name = filepath.Base(path)
+
+	return f, nil
+}
@@ -0,0 +1,224 @@
+package unit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := Parse(strings.NewReader(`# Installed by the package
+[Unit]
+Description = Web server
+After=network.target
+
+[Service]
+; comments start with a semicolon too
+ExecStart=/usr/sbin/nginx \
+# comments within a continuation are skipped
+  -g "daemon off;"
+Environment=A=1
+Environment=B=2
+ExecStartPre=
+`))
+
+	assert.NoError(err)
+	assert.Equal([]Section{
+		{Name: "Unit", Comment: "# Installed by the package\n", Directives: []Directive{
+			{Key: "Description", Value: "Web server"},
+			{Key: "After", Value: "network.target"},
+		}},
+		{Name: "Service", Comment: "\n", Directives: []Directive{
+			{Key: "ExecStart", Value: `/usr/sbin/nginx -g "daemon off;"`, Comment: "; comments start with a semicolon too\n"},
+			{Key: "Environment", Value: "A=1"},
+			{Key: "Environment", Value: "B=2"},
+			{Key: "ExecStartPre", Value: ""},
+		}},
+	}, f.Sections)
+
+	service := f.Section("Service")
+	assert.NotNil(service)
+	assert.Nil(f.Section("Install"))
+
+	value, ok := service.Get("Environment")
+	assert.True(ok)
+	assert.Equal("B=2", value)
+	assert.Equal([]string{"A=1", "B=2"}, service.Values("Environment"))
+
+	_, ok = service.Get("User")
+	assert.False(ok)
+}
+
+func TestParseErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, content := range []string{
+		"Key=Value\n",
+		"[Unit\n",
+		"[]\n",
+		"[Unit]\nno assignment\n",
+		"[Unit]\n=value\n",
+		"[Unit]\nDescription=a \\\n",
+	} {
+		_, err := Parse(strings.NewReader(content))
+		assert.Error(err, content)
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "unit")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.service")
+	assert.NoError(ioutil.WriteFile(path, []byte("[Service]\nExecStart=/bin/app\n"), 0644))
+
+	f, err := ParseFile(path)
+	assert.NoError(err)
+	assert.Equal("app.service", f.Name)
+	assert.Len(f.Sections, 1)
+
+	_, err = ParseFile(filepath.Join(dir, "missing.service"))
+	assert.Error(err)
+}
+
+func TestExpand(t *testing.T) {
+	assert := assert.New(t)
+
+	specifiers := map[byte]string{'i': "eth0", 'n': "dhcp@eth0.service"}
+
+	value, err := Expand("--interface %i --unit=%n --percent=100%%", specifiers)
+	assert.NoError(err)
+	assert.Equal("--interface eth0 --unit=dhcp@eth0.service --percent=100%", value)
+
+	_, err = Expand("%h/data", specifiers)
+	assert.Error(err)
+
+	_, err = Expand("trailing %", specifiers)
+	assert.Error(err)
+
+	assert.Equal([]byte{'h', 'i'}, Specifiers("%h/%%/%i"))
+}
+
+func TestRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	content := `# Installed by the package
+
+[Unit]
+Description=Web server
+# Start after the network is up
+After=network.target
+[Service]
+ExecStart=/usr/sbin/nginx -g "daemon off;"
+Environment=A=1
+Environment=A=2
+
+[Install]
+WantedBy=multi-user.target
+; end of file
+`
+
+	f, err := Parse(strings.NewReader(content))
+	assert.NoError(err)
+
+	var b strings.Builder
+	_, err = f.WriteTo(&b)
+	assert.NoError(err)
+	assert.Equal(strings.Replace(content, "After=network.target\n[Service]", "After=network.target\n\n[Service]", 1), b.String())
+
+	again, err := Parse(strings.NewReader(b.String()))
+	assert.NoError(err)
+	assert.Equal(b.String(), again.String())
+}
+
+func TestAdd(t *testing.T) {
+	assert := assert.New(t)
+
+	f := &File{}
+	f.Add("Service", Directive{Key: "ExecStart", Value: "/bin/app"})
+	f.Add("Install", Directive{Key: "WantedBy", Value: "multi-user.target"})
+	f.Add("Service", Directive{Key: "LimitNOFILE", Value: "65536"})
+
+	assert.Equal("[Service]\nExecStart=/bin/app\nLimitNOFILE=65536\n\n[Install]\nWantedBy=multi-user.target\n", f.String())
+}
+
+func TestValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	valid := func(s Section) *File { return &File{Sections: []Section{s}} }
+
+	assert.NoError(valid(Section{Name: "Service", Comment: "# limits\n\n", Directives: []Directive{{Key: "LimitNOFILE", Value: "65536"}}}).Validate())
+
+	for _, f := range []*File{
+		valid(Section{Directives: []Directive{{Key: "LimitNOFILE", Value: "65536"}}}),
+		valid(Section{Name: "Ser]vice"}),
+		valid(Section{Name: "Service", Comment: "no comment character\n"}),
+		valid(Section{Name: "Service", Comment: "# no newline"}),
+		valid(Section{Name: "Service", Directives: []Directive{{Key: "Limit NOFILE", Value: "65536"}}}),
+		valid(Section{Name: "Service", Directives: []Directive{{Key: "ExecStart", Value: "/bin/app\n[Install]"}}}),
+		valid(Section{Name: "Service", Directives: []Directive{{Key: "ExecStart", Value: `/bin/app \`}}}),
+		{Footer: "trailing text"},
+	} {
+		assert.Error(f.Validate(), f.String())
+
+		_, err := f.WriteTo(ioutil.Discard)
+		assert.Error(err)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	assert := assert.New(t)
+	tables := []struct {
+		words    []string
+		expected string
+	}{
+		{[]string{"/bin/app", "--port", "8080"}, `/bin/app --port 8080`},
+		{[]string{"/bin/app", "--name", "two words"}, `/bin/app --name "two words"`},
+		{[]string{"/bin/app", `say "hi"`, `C:\dir`}, `/bin/app "say \"hi\"" "C:\\dir"`},
+		{[]string{"/bin/app", "", "line\nbreak"}, `/bin/app "" "line\nbreak"`},
+		{[]string{"/bin/app", "--fmt=100%d", "$HOME"}, `/bin/app --fmt=100%%d $$HOME`},
+		{[]string{"/bin/app", "--instance=%i", "50% of ${A}"}, `/bin/app --instance=%%i "50%% of $${A}"`},
+		{[]string{"/bin/app", "%", "$"}, `/bin/app %% $$`},
+	}
+
+	for _, table := range tables {
+		command := QuoteCommand(table.words...)
+		assert.Equal(table.expected, command)
+
+		words, err := SplitCommand(command)
+		assert.NoError(err)
+		assert.Equal(table.words, words)
+	}
+
+	template := QuoteTemplateCommand("/bin/app", "--instance=%i", "--fmt=%d", "$HOME")
+	assert.Equal(`/bin/app --instance=%i --fmt=%%d $$HOME`, template)
+	words, err := SplitCommand(template)
+	assert.NoError(err)
+	assert.Equal([]string{"/bin/app", "--instance=%i", "--fmt=%d", "$HOME"}, words)
+
+	assert.Equal(`"A=1"`, Quote("A=1"))
+	assert.Equal("100%%-%i", EscapeSpecifiers("100%-%i", "i"))
+	assert.Equal("100%-%i", UnescapeSpecifiers("100%%-%i"))
+	assert.True(HasVariables("$HOME"))
+	assert.False(HasVariables("$$HOME"))
+}
+
+func TestSplitWords(t *testing.T) {
+	assert := assert.New(t)
+
+	words, err := SplitWords(`/bin/app  --name "two words" 'single \'quoted\'' escaped\ space`)
+	assert.NoError(err)
+	assert.Equal([]string{"/bin/app", "--name", "two words", "single 'quoted'", "escaped space"}, words)
+
+	_, err = SplitWords(`/bin/app "unterminated`)
+	assert.Error(err)
+}
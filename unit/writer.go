@@ -0,0 +1,118 @@
+package unit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+Validate checks that the file can be written without changing its
+meaning when it is read back
+*/
+func (f *File) Validate() error {
+	for _, s := range f.Sections {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+	return validateComment(f.Footer)
+}
+
+/*
+Validate checks the section name and the section's directives, see
+File.Validate
+*/
+func (s Section) Validate() error {
+	if s.Name == "" || strings.ContainsAny(s.Name, "[]\r\n") {
+		return fmt.Errorf("invalid section name %q", s.Name)
+	}
+
+	if err := validateComment(s.Comment); err != nil {
+		return fmt.Errorf("section %q: %v", s.Name, err)
+	}
+
+	for _, d := range s.Directives {
+		if err := d.Validate(); err != nil {
+			return fmt.Errorf("section %q: %v", s.Name, err)
+		}
+	}
+
+	return nil
+}
+
+/*
+Validate checks that the key is a valid directive name and that the
+value fits on one line, see File.Validate
+*/
+func (d Directive) Validate() error {
+	if d.Key == "" || strings.ContainsAny(d.Key, "=#;[] \t\r\n") {
+		return fmt.Errorf("invalid directive name %q", d.Key)
+	}
+	if strings.ContainsAny(d.Value, "\r\n") {
+		return fmt.Errorf("value of %s= must not contain a newline", d.Key)
+	}
+	if strings.HasSuffix(d.Value, `\`) {
+		return fmt.Errorf("value of %s= must not end with a backslash", d.Key)
+	}
+	return validateComment(d.Comment)
+}
+
+/*
+validateComment checks that every line of a comment is empty or a
+comment line, and that the comment ends with a newline
+*/
+func validateComment(comment string) error {
+	if comment == "" {
+		return nil
+	}
+	if !strings.HasSuffix(comment, "\n") {
+		return fmt.Errorf("comment %q must end with a newline", comment)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(comment, "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && line[0] != '#' && line[0] != ';' {
+			return fmt.Errorf("comment line %q must start with # or ;", line)
+		}
+	}
+	return nil
+}
+
+/*
+WriteTo writes the file in unit file syntax after validating it.
+Sections without a comment are separated by an empty line.
+*/
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	if err := f.Validate(); err != nil {
+		return 0, err
+	}
+	n, err := io.WriteString(w, f.String())
+	return int64(n), err
+}
+
+/*
+String returns the file in unit file syntax without validating it,
+see WriteTo
+*/
+func (f *File) String() string {
+	var b bytes.Buffer
+
+	for i, s := range f.Sections {
+		if s.Comment != "" {
+			b.WriteString(s.Comment)
+		} else if i > 0 {
+			b.WriteString("\n")
+		}
+
+		fmt.Fprintf(&b, "[%s]\n", s.Name)
+		for _, d := range s.Directives {
+			b.WriteString(d.Comment)
+			fmt.Fprintf(&b, "%s=%s\n", d.Key, d.Value)
+		}
+	}
+
+	b.WriteString(f.Footer)
+
+	return b.String()
+}
@@ -3,10 +3,12 @@
 package systemservice
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
 
+	"golang.org/x/sys/windows/registry"
 	"golang.org/x/sys/windows/svc"
 	"golang.org/x/sys/windows/svc/debug"
 	"golang.org/x/sys/windows/svc/mgr"
@@ -21,7 +23,7 @@ func connectService(name string) (s *mgr.Service, err error) {
 	m, err := mgr.Connect()
 
 	if err != nil {
-		logger.Log("open manager error: ", err)
+		defaultLog().Warn("error opening service manager", "error", err)
 		return nil, err
 	}
 
@@ -29,7 +31,7 @@ func connectService(name string) (s *mgr.Service, err error) {
 
 	if err != nil {
 		e := err.Error()
-		logger.Log("open manager error: ", e)
+		defaultLog().Warn("error opening service manager", "error", e)
 
 		if strings.Contains(e, "specified service does not exist") {
 			return nil, &ServiceDoesNotExistError{serviceName: name}
@@ -43,60 +45,123 @@ func connectService(name string) (s *mgr.Service, err error) {
 	return s, nil
 }
 
+/*
+setServiceEnvironment sets the environment variables of a service,
+which the service control manager reads from the registry
+*/
+func setServiceEnvironment(name string, env []string) error {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, serviceKey(name), registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+
+	if len(env) == 0 {
+		err = key.DeleteValue("Environment")
+		if err == registry.ErrNotExist {
+			return nil
+		}
+		return err
+	}
+
+	return key.SetStringsValue("Environment", env)
+}
+
+/*
+getServiceEnvironment returns the environment variables of a service
+*/
+func getServiceEnvironment(name string) ([]string, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, serviceKey(name), registry.QUERY_VALUE)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Close()
+
+	env, _, err := key.GetStringsValue("Environment")
+	if err == registry.ErrNotExist {
+		return nil, nil
+	}
+
+	return env, err
+}
+
+/*
+serviceKey returns the registry key the service control manager keeps
+the configuration of a service in
+*/
+func serviceKey(name string) string {
+	return `SYSTEM\CurrentControlSet\Services\` + name
+}
+
 /*
 runScCommand makes calls to the sc.exe binary.
 
 See this page for reference:
 https://www.computerhope.com/sc-command.htm
 */
-func runScCommand(args ...string) (out string, err error) {
-	logger.Log("running command: sc ", strings.Join(args, " "))
-	return runCommand("sc", args...)
+func (s *SystemService) sc(args ...string) (out string, err error) {
+	return s.run("sc", args...)
 }
 
 var elog debug.Log
 
-type windowsService struct{}
+/*
+windowsService maps the control requests of the service control
+manager to the callbacks of the program
+*/
+type windowsService struct {
+	program     Program
+	stopTimeout time.Duration
+	log         fieldLogger
+}
 
 func (m *windowsService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
-	logger.Log("execute called")
-	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue
+	m.log.Debug("execute called")
+
+	cmdsAccepted := svc.AcceptStop | svc.AcceptShutdown
+	reloader, canReload := m.program.(Reloader)
+	if canReload {
+		cmdsAccepted |= svc.AcceptParamChange
+	}
+
 	changes <- svc.Status{State: svc.StartPending}
-	// fasttick := time.Tick(500 * time.Millisecond)
-	// slowtick := time.Tick(2 * time.Second)
-	// tick := fasttick
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := m.program.Start(ctx); err != nil {
+		elog.Error(1, fmt.Sprintf("program failed to start: %v", err))
+		return true, 1
+	}
+
 	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
 loop:
-	for {
-		// logger.Log("Loop!")
-		select {
-		// case <-tick:
-		// 	beep()
-		// 	elog.Info(1, "beep")
-		case c := <-r:
-			switch c.Cmd {
-			case svc.Interrogate:
-				changes <- c.CurrentStatus
-				// Testing deadlock from https://code.google.com/p/winsvc/issues/detail?id=4
-				time.Sleep(100 * time.Millisecond)
-				changes <- c.CurrentStatus
-			case svc.Stop, svc.Shutdown:
-				// golang.org/x/sys/windows/svc.TestExample is verifying this output.
-				testOutput := strings.Join(args, "-")
-				testOutput += fmt.Sprintf("-%d", c.Context)
-				elog.Info(1, testOutput)
-				break loop
-			case svc.Pause:
-				changes <- svc.Status{State: svc.Paused, Accepts: cmdsAccepted}
-				// tick = slowtick
-			case svc.Continue:
-				changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
-				// tick = fasttick
-			default:
-				elog.Error(1, fmt.Sprintf("unexpected control request #%d", c))
+	for c := range r {
+		switch c.Cmd {
+		case svc.Interrogate:
+			changes <- c.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			break loop
+		case svc.ParamChange:
+			if !canReload {
+				continue
+			}
+			if err := reloader.Reload(ctx); err != nil {
+				elog.Error(1, fmt.Sprintf("program failed to reload: %v", err))
 			}
+			changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
+		default:
+			elog.Error(1, fmt.Sprintf("unexpected control request #%d", c))
 		}
 	}
-	changes <- svc.Status{State: svc.StopPending}
-	return
+
+	changes <- svc.Status{State: svc.StopPending, WaitHint: uint32(m.stopTimeout / time.Millisecond)}
+	cancel()
+
+	if err := stopProgram(m.program, m.stopTimeout); err != nil {
+		elog.Error(1, fmt.Sprintf("program failed to stop: %v", err))
+		return true, 2
+	}
+
+	return false, 0
 }